@@ -0,0 +1,147 @@
+package upload
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/gif"
+	"time"
+
+	"github.com/lsldigital/gocipe-upload/core"
+)
+
+// truncateGIF drops the frames of an animated GIF beyond maxFrames or maxDuration
+// It returns the content untouched and false when nothing was dropped.
+// At least one frame is always kept; the loop count is preserved while several frames remain.
+func truncateGIF(content []byte, maxFrames int, maxDuration time.Duration) ([]byte, bool, error) {
+	anim, err := gif.DecodeAll(bytes.NewReader(content))
+	if err != nil {
+		return content, false, err
+	}
+
+	keep := len(anim.Image)
+	if maxFrames != core.NoLimit && maxFrames > 0 && keep > maxFrames {
+		keep = maxFrames
+	}
+
+	if maxDuration > 0 {
+		var total time.Duration
+		for i := 0; i < keep; i++ {
+			// Delays are in 100ths of a second
+			total += time.Duration(anim.Delay[i]) * 10 * time.Millisecond
+			if total > maxDuration && i > 0 {
+				keep = i
+				break
+			}
+		}
+	}
+
+	if keep == len(anim.Image) {
+		return content, false, nil
+	}
+
+	anim.Image = anim.Image[:keep]
+	anim.Delay = anim.Delay[:keep]
+	if len(anim.Disposal) > keep {
+		anim.Disposal = anim.Disposal[:keep]
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		return content, false, err
+	}
+	return buf.Bytes(), true, nil
+}
+
+// setGIFLoopCount returns the animated GIF content looping loopCount times
+// Frames are re-encoded losslessly, GIF frames being paletted already
+func setGIFLoopCount(content []byte, loopCount int) ([]byte, error) {
+	anim, err := gif.DecodeAll(bytes.NewReader(content))
+	if err != nil {
+		return content, err
+	}
+	if anim.LoopCount == loopCount {
+		return content, nil
+	}
+	anim.LoopCount = loopCount
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		return content, err
+	}
+	return buf.Bytes(), nil
+}
+
+// isAnimated checks if content is an animated GIF or PNG (APNG) from its structure,
+// without decoding frames
+func isAnimated(content []byte) bool {
+	switch {
+	case bytes.HasPrefix(content, []byte("GIF8")):
+		return gifFrames(content, 2) > 1
+	case bytes.HasPrefix(content, []byte("\x89PNG\r\n\x1a\n")):
+		return hasAnimationControl(content)
+	}
+	return false
+}
+
+// gifFrames counts the frames of a GIF, stopping at max
+// Truncated content counts the frames found so far
+func gifFrames(content []byte, max int) int {
+	// Header and logical screen descriptor
+	pos := 6 + 7
+	if len(content) < pos {
+		return 0
+	}
+	if packed := content[10]; packed&0x80 != 0 {
+		pos += 3 << (packed&0x07 + 1)
+	}
+
+	frames := 0
+	for pos < len(content) && frames < max {
+		switch content[pos] {
+		case 0x21: // Extension: label then sub-blocks
+			pos = skipSubBlocks(content, pos+2)
+		case 0x2c: // Image descriptor, local color table, LZW code size then sub-blocks
+			frames++
+			if pos+10 > len(content) {
+				return frames
+			}
+			if packed := content[pos+9]; packed&0x80 != 0 {
+				pos += 3 << (packed&0x07 + 1)
+			}
+			pos = skipSubBlocks(content, pos+10+1)
+		default: // Trailer or garbage
+			return frames
+		}
+	}
+	return frames
+}
+
+// skipSubBlocks returns the position following the GIF sub-blocks starting at pos
+func skipSubBlocks(content []byte, pos int) int {
+	for pos < len(content) {
+		size := int(content[pos])
+		pos++
+		if size == 0 {
+			break
+		}
+		pos += size
+	}
+	return pos
+}
+
+// hasAnimationControl checks if a PNG has an acTL chunk before its image data
+func hasAnimationControl(content []byte) bool {
+	pos := 8
+	for pos+8 <= len(content) {
+		length := int(binary.BigEndian.Uint32(content[pos:]))
+		switch string(content[pos+4 : pos+8]) {
+		case "acTL":
+			return true
+		case "IDAT", "IEND":
+			return false
+		}
+		// Length, type, data and CRC
+		pos += 4 + 4 + length + 4
+	}
+	return false
+}
@@ -0,0 +1,191 @@
+package upload
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lsldigital/gocipe-upload/core"
+)
+
+func encodeTestGIF(t *testing.T, frames int, delay int) []byte {
+	palette := color.Palette{color.Black, color.White}
+	anim := &gif.GIF{LoopCount: 3}
+	for i := 0; i < frames; i++ {
+		anim.Image = append(anim.Image, image.NewPaletted(image.Rect(0, 0, 4, 4), palette))
+		anim.Delay = append(anim.Delay, delay)
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestTruncateGIF(t *testing.T) {
+	content := encodeTestGIF(t, 10, 50)
+
+	tests := []struct {
+		name        string
+		maxFrames   int
+		maxDuration time.Duration
+		frames      int
+	}{
+		{"No Limit", core.NoLimit, 0, 10},
+		{"Max Frames", 4, 0, 4},
+		{"Max Duration", core.NoLimit, 2 * time.Second, 4},
+		{"Both", 3, 2 * time.Second, 3},
+		{"First Frame Kept", core.NoLimit, time.Millisecond, 1},
+	}
+
+	for _, tt := range tests {
+		out, dropped, err := truncateGIF(content, tt.maxFrames, tt.maxDuration)
+		if err != nil {
+			t.Fatalf("%v: %v", tt.name, err)
+		}
+		if dropped != (tt.frames != 10) {
+			t.Errorf("%v: unexpected dropped %v", tt.name, dropped)
+		}
+
+		anim, err := gif.DecodeAll(bytes.NewReader(out))
+		if err != nil {
+			t.Fatalf("%v: %v", tt.name, err)
+		}
+		if len(anim.Image) != tt.frames {
+			t.Errorf("%v: expected %d frames, got %d", tt.name, tt.frames, len(anim.Image))
+		}
+		if tt.frames > 1 && anim.LoopCount != 3 {
+			t.Errorf("%v: expected loop count preserved, got %d", tt.name, anim.LoopCount)
+		}
+	}
+}
+
+func TestLoopCount(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loopcount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := encodeTestGIF(t, 3, 10)
+	tests := []struct {
+		name     string
+		opts     []Option
+		expected int
+	}{
+		{"Preserved", nil, 3},
+		{"Forever", []Option{LoopCount(0)}, 0},
+		{"Limited", []Option{LoopCount(2)}, 2},
+	}
+
+	for _, tt := range tests {
+		uploader := NewImageUploader(EvaluateOptions(append(tt.opts, Dir(dir))...))
+		uploaded, err := uploader.Upload(tt.name+".gif", content)
+		if err != nil {
+			t.Fatalf("%v: %v", tt.name, err)
+		}
+
+		out, err := ioutil.ReadFile(uploaded.DiskPath())
+		if err != nil {
+			t.Fatalf("%v: %v", tt.name, err)
+		}
+		anim, err := gif.DecodeAll(bytes.NewReader(out))
+		if err != nil {
+			t.Fatalf("%v: %v", tt.name, err)
+		}
+		if anim.LoopCount != tt.expected {
+			t.Errorf("%v: expected loop count %d, got %d", tt.name, tt.expected, anim.LoopCount)
+		}
+		if len(anim.Image) != 3 {
+			t.Errorf("%v: expected 3 frames, got %d", tt.name, len(anim.Image))
+		}
+	}
+}
+
+func TestIsAnimated(t *testing.T) {
+	var apng bytes.Buffer
+	apng.WriteString("\x89PNG\r\n\x1a\n")
+	apng.Write([]byte{0, 0, 0, 13})
+	apng.WriteString("IHDR")
+	apng.Write(make([]byte, 13+4))
+	apng.Write([]byte{0, 0, 0, 8})
+	apng.WriteString("acTL")
+	apng.Write(make([]byte, 8+4))
+
+	var static bytes.Buffer
+	if err := png.Encode(&static, image.NewGray(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		content  []byte
+		animated bool
+	}{
+		{"Animated GIF", encodeTestGIF(t, 3, 10), true},
+		{"Static GIF", encodeTestGIF(t, 1, 10), false},
+		{"APNG", apng.Bytes(), true},
+		{"Static PNG", static.Bytes(), false},
+		{"Truncated GIF", encodeTestGIF(t, 3, 10)[:20], false},
+		{"Not An Image", []byte("GIF"), false},
+	}
+
+	for _, test := range tests {
+		if animated := isAnimated(test.content); animated != test.animated {
+			t.Errorf("%s: expected animated %v, got %v", test.name, test.animated, animated)
+		}
+	}
+}
+
+func TestAnimatedFormats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "animated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := NewImageProcessor(
+		Formats("thumb", 4, 4, false),
+		AnimatedFormats(Formats("thumb", 2, 2, false)),
+	)
+
+	for _, test := range []struct {
+		frames int
+		width  int
+	}{{3, 2}, {1, 4}} {
+		content := encodeTestGIF(t, test.frames, 10)
+		file := &UploadedFile{diskPath: filepath.Join(dir, "anim.gif"), content: content}
+		if err := ioutil.WriteFile(file.diskPath, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		job, err := p.Process(file, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		<-job.Done
+
+		if job.Animated != (test.frames > 1) {
+			t.Errorf("%d frames: expected animated %v", test.frames, test.frames > 1)
+		}
+		variant, err := ioutil.ReadFile(file.diskPath + ":thumb")
+		if err != nil {
+			t.Fatal(err)
+		}
+		config, err := gif.DecodeConfig(bytes.NewReader(variant))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config.Width != test.width {
+			t.Errorf("%d frames: expected width %d, got %d", test.frames, test.width, config.Width)
+		}
+	}
+}
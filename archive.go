@@ -0,0 +1,231 @@
+package upload
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/disintegration/imaging"
+)
+
+// ListVariants returns the disk paths of existing variants of an image by format name
+func (p *ImageProcessor) ListVariants(baseDiskPath string) map[string]string {
+	variants := make(map[string]string)
+	for _, format := range p.options.formats {
+		if format.name == "" {
+			continue
+		}
+
+		fileDiskPath := p.options.variantPath(baseDiskPath, format)
+		if _, err := os.Stat(fileDiskPath); err == nil {
+			variants[format.name] = fileDiskPath
+		}
+	}
+	return variants
+}
+
+// ArchiveVariants streams a zip of an image and all its existing variants to w
+// Variants are named after their format, e.g. thumb.jpg
+func (p *ImageProcessor) ArchiveVariants(baseDiskPath string, w io.Writer) error {
+	archive := zip.NewWriter(w)
+
+	if err := addZipEntry(archive, filepath.Base(baseDiskPath), baseDiskPath); err != nil {
+		return err
+	}
+
+	variants := p.ListVariants(baseDiskPath)
+	for _, format := range p.options.formats {
+		fileDiskPath, ok := variants[format.name]
+		if !ok {
+			continue
+		}
+
+		if err := addZipEntry(archive, p.options.entryName(baseDiskPath, format), fileDiskPath); err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+// entryName returns the name of the variant of format in archives, e.g. thumb.jpg
+func (o OptionsImage) entryName(baseDiskPath string, format Format) string {
+	if o.converted(baseDiskPath, format) {
+		return format.name + "." + strings.TrimPrefix(format.output, ".")
+	}
+	return format.name + filepath.Ext(baseDiskPath)
+}
+
+// addZipEntry copies the file at fileDiskPath into archive as name
+func addZipEntry(archive *zip.Writer, name, fileDiskPath string) error {
+	file, err := os.Open(fileDiskPath)
+	if err != nil {
+		log.Printf("error opening %v: %v\n", fileDiskPath, err)
+		return err
+	}
+	defer file.Close()
+
+	entry, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(entry, file)
+	return err
+}
+
+// ProcessToTar generates the variants of the image in buf, named baseName (e.g. photo.jpg),
+// and streams them to w as a tar of entries named after their format, e.g. thumb.jpg
+// Nothing is written to disk: variants are encoded in memory one at a time, each written
+// as an entry once encoded. Formats skipped are left out; the first format failing aborts.
+// With Deterministic, entries are dated from the Unix epoch so that archives are reproducible
+func (p *ImageProcessor) ProcessToTar(buf []byte, baseName string, w io.Writer) error {
+	if len(buf) == 0 {
+		return ErrEmptyUpload
+	}
+	if !p.options.isImage(buf) {
+		return fmt.Errorf("image type invalid")
+	}
+
+	formats := p.options.formats
+	if isAnimated(buf) && p.options.animatedFormats != nil {
+		formats = p.options.animatedFormats
+	}
+
+	config, imgType, err := image.DecodeConfig(bytes.NewReader(buf))
+	if err != nil {
+		log.Printf("error decoding image: %v", err)
+		return err
+	}
+	if p.options.palettedSources == PalettedReject && isPaletted(config.ColorModel) {
+		log.Printf("image %v is paletted\n", baseName)
+		return ErrPalettedSource
+	}
+
+	reserved := reserve(decodedSize(&config))
+	defer reserved.release()
+
+	start := time.Now()
+	src, err := decodeTimeout(p.options.decodeTimeout, reserved, func() (image.Image, error) {
+		return imaging.Decode(bytes.NewReader(buf))
+	})
+	if err != nil {
+		log.Printf("Image error: %v\n", err)
+		return err
+	}
+	src = toRGB(src)
+	if p.options.palettedSources == PalettedConvert {
+		src = fromPalette(src)
+	}
+	if p.options.convertSRGB && imgType == TypeImageJPEG {
+		src = convertToSRGB(src, buf)
+	}
+
+	source := &source{
+		diskPath:   baseName,
+		img:        src,
+		config:     &config,
+		imgType:    imgType,
+		decodeTime: time.Since(start),
+	}
+
+	archive := tar.NewWriter(w)
+	storage := &tarStorage{archive: archive, names: make(map[string]string)}
+	if p.options.deterministic {
+		storage.modTime = time.Unix(0, 0)
+	}
+
+	// Variants go through the tar storage under their entry names, unsharded and without sidecars
+	options := *p.options
+	options.storage = storage
+	options.shardLevels = 0
+	options.allowedDirs = nil
+	options.writePolicy = WriteOverwrite
+	options.writeSidecar = false
+	tarred := &ImageProcessor{options: &options}
+
+	formats = p.filterFormats(formats, func(format Format, err error) {
+		p.formatSkipped(FormatSkipped{Name: format.name, Path: baseName, Reason: SkippedInvalid, Err: err})
+	})
+	for _, format := range formats {
+		name := options.entryName(baseName, format)
+		fileDiskPath := options.variantPath(baseName, format)
+		storage.names[fileDiskPath] = name
+		storage.names[previewPath(fileDiskPath)] = name + ".preview"
+
+		switch _, err := tarred.processFormat(source, format); err {
+		case nil:
+		case ErrFormatSkipped:
+			p.formatSkipped(FormatSkipped{Name: format.name, Path: baseName, Reason: SkippedSmaller})
+		default:
+			log.Printf("Image tar format error: %v", err)
+			return &FormatError{Name: format.name, Path: baseName, Err: err}
+		}
+	}
+
+	return archive.Close()
+}
+
+// tarStorage implements the Storage interface as entries of a tar archive
+// Entries are buffered until closed, the tar header needing their size
+type tarStorage struct {
+	archive *tar.Writer
+	names   map[string]string // Entry names by key, the key base name otherwise
+	modTime time.Time         // Modification time of the entries, the time they are closed if zero
+}
+
+func (s *tarStorage) Create(key string) (StorageWriter, error) {
+	name, ok := s.names[key]
+	if !ok {
+		name = filepath.Base(key)
+	}
+	return &tarWriter{archive: s.archive, name: name, modTime: s.modTime}, nil
+}
+
+// Delete cannot remove entries already streamed
+func (s *tarStorage) Delete(key string) error {
+	return nil
+}
+
+type tarWriter struct {
+	bytes.Buffer
+	archive *tar.Writer
+	name    string
+	modTime time.Time
+}
+
+func (w *tarWriter) Close() error {
+	modTime := w.modTime
+	if modTime.IsZero() {
+		modTime = time.Now()
+	}
+	header := &tar.Header{
+		Name:    w.name,
+		Mode:    0644,
+		Size:    int64(w.Len()),
+		ModTime: modTime,
+	}
+	if err := w.archive.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := w.archive.Write(w.Bytes())
+	return err
+}
+
+// Abort drops the buffered entry, entries being written only on close
+func (w *tarWriter) Abort() error {
+	w.Reset()
+	return nil
+}
+
+func (w *tarWriter) Location() string {
+	return w.name
+}
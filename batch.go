@@ -0,0 +1,186 @@
+package upload
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// progressSmoothing is the weight of the latest duration in the moving average
+	progressSmoothing = 0.2
+)
+
+// Progress reports the progress of a batch
+type Progress struct {
+	Completed          int
+	Total              int
+	ElapsedAvg         time.Duration // Moving average of the time taken per file
+	EstimatedRemaining time.Duration
+}
+
+// BatchResult holds the outcome of processing one file of a batch
+type BatchResult struct {
+	File Uploaded
+	Job  *Job
+	Err  error
+}
+
+// Reason returns why the file failed: the error of its job, or else the formats
+// that failed within it, nil if it fully succeeded
+func (r BatchResult) Reason() error {
+	if r.Err != nil || r.Job == nil || len(r.Job.Failed) == 0 {
+		return r.Err
+	}
+
+	failed := make([]string, len(r.Job.Failed))
+	for i, formatErr := range r.Job.Failed {
+		failed[i] = formatErr.Error()
+	}
+	return fmt.Errorf("formats failed: %s", strings.Join(failed, "; "))
+}
+
+// BatchSummary counts the files of a batch that succeeded and failed, e.g. "8 of 10 processed"
+type BatchSummary struct {
+	Total     int
+	Succeeded int
+	Failed    int
+	Errors    map[string]error // Reason of each failed file by disk path
+}
+
+// SummarizeBatch returns the BatchSummary of the results of ProcessBatch
+// Files with some formats failed count as failed
+func SummarizeBatch(results []BatchResult) BatchSummary {
+	summary := BatchSummary{Total: len(results), Errors: make(map[string]error)}
+	for _, result := range results {
+		if err := result.Reason(); err != nil {
+			summary.Failed++
+			summary.Errors[result.File.DiskPath()] = err
+			continue
+		}
+		summary.Succeeded++
+	}
+	return summary
+}
+
+// ErrBatchAborted is reported for files not processed after a batch failed fast
+var ErrBatchAborted = errors.New("batch aborted")
+
+// ProcessBatch processes files concurrently and waits for their jobs to be done
+// Results are in the order of files, each with its own error (see SummarizeBatch); the first
+// error encountered is also returned, the results of other files still being valid.
+// If progress is not nil, it receives an update after each file and is closed on return.
+func (p *ImageProcessor) ProcessBatch(files []Uploaded, validate bool, progress chan<- Progress, opts ...OptionBatch) ([]BatchResult, error) {
+	return runBatch(files, EvaluateBatchOptions(opts...), progress, func(file Uploaded) BatchResult {
+		return p.processAndWait(file, validate)
+	})
+}
+
+// runBatch calls process for files, at most Concurrency at a time, as ProcessBatch does
+func runBatch(files []Uploaded, options *OptionsBatch, progress chan<- Progress, process func(Uploaded) BatchResult) ([]BatchResult, error) {
+	if progress != nil {
+		defer close(progress)
+	}
+
+	var (
+		results  = make([]BatchResult, len(files))
+		tracker  = newProgressTracker(len(files), options.concurrency)
+		tokens   = make(chan struct{}, options.concurrency)
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+
+	for i, file := range files {
+		tokens <- struct{}{}
+
+		mu.Lock()
+		aborted := options.failFast && firstErr != nil
+		mu.Unlock()
+		if aborted {
+			<-tokens
+			results[i] = BatchResult{File: file, Err: ErrBatchAborted}
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, file Uploaded) {
+			defer func() {
+				<-tokens
+				wg.Done()
+			}()
+
+			start := time.Now()
+			result := process(file)
+			results[i] = result
+
+			mu.Lock()
+			if result.Err != nil && firstErr == nil {
+				firstErr = result.Err
+			}
+			update := tracker.done(time.Since(start))
+			if progress != nil {
+				progress <- update
+			}
+			mu.Unlock()
+		}(i, file)
+	}
+	wg.Wait()
+
+	return results, firstErr
+}
+
+// processAndWait processes a file and waits for its job to be done
+func (p *ImageProcessor) processAndWait(file Uploaded, validate bool) BatchResult {
+	job, err := p.Process(file, validate)
+	if err != nil {
+		return BatchResult{File: file, Err: err}
+	}
+
+	<-job.Done
+	return BatchResult{File: file, Job: job, Err: job.Err}
+}
+
+// progressTracker estimates the remaining time of a batch
+type progressTracker struct {
+	completed   int
+	total       int
+	concurrency int
+	avg         time.Duration
+}
+
+func newProgressTracker(total, concurrency int) *progressTracker {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &progressTracker{total: total, concurrency: concurrency}
+}
+
+// done records a completed file and returns the updated progress
+func (t *progressTracker) done(elapsed time.Duration) Progress {
+	t.completed++
+	if t.completed == 1 {
+		t.avg = elapsed
+	} else {
+		t.avg = time.Duration(progressSmoothing*float64(elapsed) + (1-progressSmoothing)*float64(t.avg))
+	}
+
+	// Remaining files are processed Concurrency at a time
+	remaining := t.total - t.completed
+	concurrency := t.concurrency
+	if remaining < concurrency {
+		concurrency = remaining
+	}
+
+	progress := Progress{
+		Completed:  t.completed,
+		Total:      t.total,
+		ElapsedAvg: t.avg,
+	}
+	if remaining > 0 {
+		progress.EstimatedRemaining = t.avg * time.Duration(remaining) / time.Duration(concurrency)
+	}
+	return progress
+}
@@ -0,0 +1,184 @@
+package upload
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProgressTracker(t *testing.T) {
+	tracker := newProgressTracker(3, 1)
+
+	progress := tracker.done(10 * time.Second)
+	if progress.ElapsedAvg != 10*time.Second || progress.EstimatedRemaining != 20*time.Second {
+		t.Errorf("unexpected first progress: %+v", progress)
+	}
+
+	progress = tracker.done(20 * time.Second)
+	if progress.ElapsedAvg != 12*time.Second || progress.EstimatedRemaining != 12*time.Second {
+		t.Errorf("unexpected second progress: %+v", progress)
+	}
+
+	progress = tracker.done(20 * time.Second)
+	if progress.Completed != 3 || progress.EstimatedRemaining != 0 {
+		t.Errorf("unexpected last progress: %+v", progress)
+	}
+
+	// 4 remaining files, 2 at a time, then the last one alone
+	tracker = newProgressTracker(5, 2)
+	if progress := tracker.done(10 * time.Second); progress.EstimatedRemaining != 20*time.Second {
+		t.Errorf("unexpected concurrent progress: %+v", progress)
+	}
+	tracker.done(10 * time.Second)
+	tracker.done(10 * time.Second)
+	if progress := tracker.done(10 * time.Second); progress.EstimatedRemaining != 10*time.Second {
+		t.Errorf("unexpected concurrent last progress: %+v", progress)
+	}
+}
+
+func TestRunBatch(t *testing.T) {
+	files := make([]Uploaded, 8)
+	for i := range files {
+		files[i] = &UploadedFile{diskPath: fmt.Sprintf("%d.jpg", i)}
+	}
+
+	var (
+		mu            sync.Mutex
+		running, peak int
+	)
+	progress := make(chan Progress, len(files))
+	results, err := runBatch(files, EvaluateBatchOptions(BatchConcurrency(3)), progress, func(file Uploaded) BatchResult {
+		mu.Lock()
+		running++
+		if running > peak {
+			peak = running
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return BatchResult{File: file, Job: &Job{}}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peak != 3 {
+		t.Errorf("expected 3 files processed concurrently, got %d", peak)
+	}
+	for i, result := range results {
+		if result.File != files[i] {
+			t.Errorf("expected result %d for %v, got %v", i, files[i].DiskPath(), result.File.DiskPath())
+		}
+	}
+	updates := 0
+	for range progress {
+		updates++
+	}
+	if updates != len(files) {
+		t.Errorf("expected %d progress updates, got %d", len(files), updates)
+	}
+}
+
+func TestRunBatchFailFast(t *testing.T) {
+	files := make([]Uploaded, 4)
+	for i := range files {
+		files[i] = &UploadedFile{diskPath: fmt.Sprintf("%d.jpg", i)}
+	}
+	invalid := errors.New("image type invalid")
+	process := func(file Uploaded) BatchResult {
+		if file == files[1] {
+			return BatchResult{File: file, Err: invalid}
+		}
+		return BatchResult{File: file, Job: &Job{}}
+	}
+
+	results, err := runBatch(files, EvaluateBatchOptions(BatchFailFast(true)), nil, process)
+	if err != invalid {
+		t.Errorf("expected first error returned, got %v", err)
+	}
+	expected := []error{nil, invalid, ErrBatchAborted, ErrBatchAborted}
+	for i, result := range results {
+		if result.Err != expected[i] || result.File != files[i] {
+			t.Errorf("file %d: expected %v, got %v", i, expected[i], result.Err)
+		}
+	}
+
+	// Without FailFast, files after the error are still processed
+	results, err = runBatch(files, EvaluateBatchOptions(), nil, process)
+	if err != invalid || results[2].Err != nil || results[3].Err != nil {
+		t.Errorf("expected only file 1 failed, got %v", results)
+	}
+}
+
+func TestProcessBatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "batch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var files []Uploaded
+	for _, name := range []string{"normal.jpg", "normal.txt", "normal.png"} {
+		content, err := ioutil.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, &UploadedFile{diskPath: filepath.Join(dir, name), content: content})
+		if err := ioutil.WriteFile(filepath.Join(dir, name), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := NewImageProcessor(Formats("thumb", 50, 50, false))
+	results, err := p.ProcessBatch(files, false, nil, BatchConcurrency(2))
+	if err == nil {
+		t.Error("expected error of the text file")
+	}
+	if results[0].Err != nil || results[0].Job == nil || len(results[0].Job.Variants) != 1 {
+		t.Errorf("expected jpg processed, got %v", results[0].Err)
+	}
+	if results[1].Err == nil {
+		t.Error("expected text file failed")
+	}
+	if results[2].Err != nil || results[2].File != files[2] {
+		t.Errorf("expected png processed in order, got %v", results[2].Err)
+	}
+}
+
+func TestSummarizeBatch(t *testing.T) {
+	files := []Uploaded{
+		&UploadedFile{diskPath: "a.jpg"},
+		&UploadedFile{diskPath: "b.jpg"},
+		&UploadedFile{diskPath: "c.jpg"},
+		&UploadedFile{diskPath: "d.jpg"},
+	}
+	invalid := errors.New("image type invalid")
+	results := []BatchResult{
+		{File: files[0], Job: &Job{}},
+		{File: files[1], Err: invalid},
+		{File: files[2], Job: &Job{Failed: []*FormatError{{Name: "thumb", Path: "c.jpg", Err: errors.New("encode")}}}},
+		{File: files[3], Err: ErrBatchAborted},
+	}
+
+	summary := SummarizeBatch(results)
+	if summary.Total != 4 || summary.Succeeded != 1 || summary.Failed != 3 {
+		t.Errorf("expected 1 of 4 succeeded and 3 failed, got %+v", summary)
+	}
+	if summary.Errors["b.jpg"] != invalid || summary.Errors["d.jpg"] != ErrBatchAborted {
+		t.Errorf("unexpected errors %v", summary.Errors)
+	}
+	if err := summary.Errors["c.jpg"]; err == nil || err.Error() != "formats failed: thumb: encode" {
+		t.Errorf("expected failed formats reported, got %v", err)
+	}
+	if _, ok := summary.Errors["a.jpg"]; ok {
+		t.Error("expected a.jpg not reported")
+	}
+}
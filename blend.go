@@ -0,0 +1,70 @@
+package upload
+
+import (
+	"image"
+
+	"github.com/disintegration/imaging"
+)
+
+// Watermark blend modes
+const (
+	// BlendNormal overlays the watermark by its alpha
+	BlendNormal = iota
+	// BlendMultiply darkens the background by the watermark
+	BlendMultiply
+	// BlendScreen lightens the background by the watermark
+	BlendScreen
+	// BlendOverlay multiplies dark and screens light regions of the background
+	BlendOverlay
+)
+
+// blendChannel returns the blend of background cb and watermark cs channels, in [0, 1]
+func blendChannel(mode int, cb, cs float64) float64 {
+	switch mode {
+	case BlendMultiply:
+		return cb * cs
+	case BlendScreen:
+		return cb + cs - cb*cs
+	case BlendOverlay:
+		if cb <= 0.5 {
+			return 2 * cb * cs
+		}
+		return 1 - 2*(1-cb)*(1-cs)
+	}
+	return cs
+}
+
+// blend composites src over img at pos with a blend mode, following the W3C compositing model
+func blend(img, src image.Image, pos image.Point, mode int) *image.NRGBA {
+	if mode == BlendNormal {
+		return imaging.Overlay(img, src, pos, 1.0)
+	}
+
+	dst := imaging.Clone(img)
+	overlay := imaging.Clone(src)
+	region := dst.Bounds().Intersect(overlay.Bounds().Add(pos))
+
+	for y := region.Min.Y; y < region.Max.Y; y++ {
+		for x := region.Min.X; x < region.Max.X; x++ {
+			d := dst.PixOffset(x, y)
+			s := overlay.PixOffset(x-pos.X, y-pos.Y)
+
+			as := float64(overlay.Pix[s+3]) / 255
+			if as == 0 {
+				continue
+			}
+			ab := float64(dst.Pix[d+3]) / 255
+			ao := as + ab*(1-as)
+
+			for ch := 0; ch < 3; ch++ {
+				cb := float64(dst.Pix[d+ch]) / 255
+				cs := float64(overlay.Pix[s+ch]) / 255
+				mixed := (1-ab)*cs + ab*blendChannel(mode, cb, cs)
+				co := (as*mixed + ab*cb*(1-as)) / ao
+				dst.Pix[d+ch] = uint8(co*255 + 0.5)
+			}
+			dst.Pix[d+3] = uint8(ao*255 + 0.5)
+		}
+	}
+	return dst
+}
@@ -0,0 +1,41 @@
+package upload
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestBlend(t *testing.T) {
+	background := imaging.New(4, 4, color.NRGBA{200, 100, 50, 255})
+	watermark := imaging.New(2, 2, color.NRGBA{128, 128, 128, 255})
+
+	tests := []struct {
+		mode int
+		want color.NRGBA
+	}{
+		{BlendNormal, color.NRGBA{128, 128, 128, 255}},
+		{BlendMultiply, color.NRGBA{100, 50, 25, 255}},
+		{BlendScreen, color.NRGBA{228, 178, 153, 255}},
+		{BlendOverlay, color.NRGBA{200, 100, 50, 255}},
+	}
+
+	for _, test := range tests {
+		blended := blend(background, watermark, image.Pt(1, 1), test.mode)
+		if c := blended.NRGBAAt(1, 1); c != test.want {
+			t.Errorf("mode %d: expected %v, got %v", test.mode, test.want, c)
+		}
+		// Outside the watermark the background is untouched
+		if c := blended.NRGBAAt(0, 0); c != background.NRGBAAt(0, 0) {
+			t.Errorf("mode %d: expected background untouched, got %v", test.mode, c)
+		}
+	}
+
+	// Transparent watermarks leave the background untouched
+	transparent := imaging.New(2, 2, color.NRGBA{0, 0, 0, 0})
+	if c := blend(background, transparent, image.Pt(1, 1), BlendMultiply).NRGBAAt(1, 1); c != background.NRGBAAt(1, 1) {
+		t.Errorf("expected transparent watermark invisible, got %v", c)
+	}
+}
@@ -0,0 +1,93 @@
+package upload
+
+import (
+	"image"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	// _decodeBudget bounds the memory of sources decoded concurrently across all jobs
+	_decodeBudget = newByteSemaphore(0)
+)
+
+// DecodeMemory sets the number of bytes sources decoded concurrently may take in memory
+// Each job reserves width×height×4 bytes of its source before decoding it and releases
+// them once done; a job larger than the whole budget runs alone (default: 0, no limit)
+func DecodeMemory(n int64) {
+	_decodeBudget.resize(n)
+}
+
+// decodedSize estimates the memory taken by a decoded image of config
+func decodedSize(config *image.Config) int64 {
+	return int64(config.Width) * int64(config.Height) * 4
+}
+
+// reservation holds bytes of the decode budget until every holder released it
+// Methods of a nil reservation do nothing, for decodes outside of the budget
+type reservation struct {
+	n       int64
+	holders int32
+}
+
+// reserve blocks until n bytes of the decode budget are available and returns their reservation
+func reserve(n int64) *reservation {
+	_decodeBudget.acquire(n)
+	return &reservation{n: n, holders: 1}
+}
+
+// hold adds a holder to r, e.g. a decode going on in the background
+func (r *reservation) hold() {
+	if r != nil {
+		atomic.AddInt32(&r.holders, 1)
+	}
+}
+
+// release removes a holder from r, the last one returning its bytes to the budget
+func (r *reservation) release() {
+	if r != nil && atomic.AddInt32(&r.holders, -1) == 0 {
+		_decodeBudget.release(r.n)
+	}
+}
+
+// byteSemaphore bounds a total of bytes in use
+type byteSemaphore struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	limit int64
+	used  int64
+}
+
+func newByteSemaphore(limit int64) *byteSemaphore {
+	s := &byteSemaphore{limit: limit}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// resize changes the limit, 0 or less meaning no limit
+func (s *byteSemaphore) resize(limit int64) {
+	s.mu.Lock()
+	s.limit = limit
+	s.mu.Unlock()
+	s.cond.Broadcast()
+}
+
+// acquire blocks until n bytes are available
+// Reservations larger than the limit wait for all others to be released
+func (s *byteSemaphore) acquire(n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for s.limit > 0 && s.used > 0 && s.used+n > s.limit {
+		s.cond.Wait()
+	}
+	s.used += n
+}
+
+// release returns n reserved bytes
+func (s *byteSemaphore) release(n int64) {
+	s.mu.Lock()
+	s.used -= n
+	s.mu.Unlock()
+	s.cond.Broadcast()
+}
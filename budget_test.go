@@ -0,0 +1,86 @@
+package upload
+
+import (
+	"bytes"
+	"image/color"
+	"testing"
+	"time"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestByteSemaphore(t *testing.T) {
+	sem := newByteSemaphore(100)
+	sem.acquire(60)
+
+	acquired := make(chan struct{})
+	go func() {
+		sem.acquire(50)
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("expected acquire over budget to block")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	sem.release(60)
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("expected acquire once released")
+	}
+	sem.release(50)
+
+	// Larger than the budget, runs alone
+	done := make(chan struct{})
+	go func() {
+		sem.acquire(500)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected oversized acquire on an idle semaphore")
+	}
+}
+
+func TestVerifyDecodeBudget(t *testing.T) {
+	var content bytes.Buffer
+	if err := imaging.Encode(&content, imaging.New(40, 40, color.White), imaging.JPEG); err != nil {
+		t.Fatal(err)
+	}
+
+	DecodeMemory(1)
+	defer DecodeMemory(0)
+	held := reserve(1)
+
+	p := NewImageProcessor(VerifyDecode(true))
+	file := &UploadedFile{diskPath: "a.jpg", content: content.Bytes()}
+	jobs := make(chan *Job, 1)
+	go func() {
+		job, err := p.Process(file, false)
+		if err != nil {
+			t.Error(err)
+		}
+		jobs <- job
+	}()
+
+	// The verifying decode waits for the memory of the source
+	select {
+	case <-jobs:
+		t.Fatal("expected the verifying decode to wait for the budget")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	held.release()
+	select {
+	case job := <-jobs:
+		if job != nil {
+			<-job.Done
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the upload processed once the budget is released")
+	}
+}
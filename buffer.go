@@ -0,0 +1,59 @@
+package upload
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// defaultEncodeBufferMax is the largest encode buffer capacity kept for reuse
+	defaultEncodeBufferMax = 4 << 20
+)
+
+var (
+	// _encodeBuffers holds the buffers variants are encoded into
+	_encodeBuffers = newBufferPool(defaultEncodeBufferMax)
+)
+
+// EncodeBufferMax sets the largest capacity of encode buffers kept for reuse
+// Larger buffers are left to the garbage collector, 0 disables reuse (default: 4MB)
+func EncodeBufferMax(n int) {
+	_encodeBuffers.setMax(n)
+}
+
+// bufferPool reuses buffers up to a maximum capacity
+type bufferPool struct {
+	pool sync.Pool
+	max  int64
+}
+
+func newBufferPool(max int) *bufferPool {
+	b := &bufferPool{max: int64(max)}
+	b.pool.New = func() interface{} {
+		return new(bytes.Buffer)
+	}
+	return b
+}
+
+// setMax changes the largest capacity kept for reuse
+func (b *bufferPool) setMax(max int) {
+	if max < 0 {
+		max = 0
+	}
+	atomic.StoreInt64(&b.max, int64(max))
+}
+
+// get returns an empty buffer
+func (b *bufferPool) get() *bytes.Buffer {
+	return b.pool.Get().(*bytes.Buffer)
+}
+
+// put returns buf to the pool if it is not too large
+func (b *bufferPool) put(buf *bytes.Buffer) {
+	if int64(buf.Cap()) > atomic.LoadInt64(&b.max) {
+		return
+	}
+	buf.Reset()
+	b.pool.Put(buf)
+}
@@ -0,0 +1,54 @@
+package upload
+
+import (
+	"bytes"
+	"image"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestBufferPoolMax(t *testing.T) {
+	pool := newBufferPool(16)
+
+	buf := pool.get()
+	buf.Write(make([]byte, 64))
+	pool.put(buf)
+	if pool.get() == buf {
+		t.Error("buffer above max should not be reused")
+	}
+
+	pool.setMax(-1)
+	if pool.max != 0 {
+		t.Errorf("expected max clamped to 0, got %d", pool.max)
+	}
+}
+
+func benchmarkEncodeImage() image.Image {
+	img := imaging.New(800, 600, image.White.C)
+	for i := range img.Pix {
+		img.Pix[i] = byte(i * 7919 % 251)
+	}
+	return img
+}
+
+func BenchmarkEncodeBuffer(b *testing.B) {
+	img := benchmarkEncodeImage()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var buf bytes.Buffer
+		imaging.Encode(&buf, img, imaging.JPEG)
+	}
+}
+
+func BenchmarkEncodeBufferPooled(b *testing.B) {
+	img := benchmarkEncodeImage()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf := _encodeBuffers.get()
+		imaging.Encode(buf, img, imaging.JPEG)
+		_encodeBuffers.put(buf)
+	}
+}
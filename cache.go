@@ -0,0 +1,86 @@
+package upload
+
+import (
+	"container/list"
+	"image"
+	"sync"
+	"time"
+)
+
+// sourceCache keeps decoded source images across jobs, least recently used first out
+// Entries are keyed by disk path and invalidated when the file modification time changes.
+type sourceCache struct {
+	mu      sync.Mutex
+	budget  int // Total number of pixels kept
+	pixels  int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+// sourceCacheEntry holds a decoded source image
+type sourceCacheEntry struct {
+	path    string
+	modTime time.Time
+	img     image.Image
+	pixels  int
+}
+
+func newSourceCache(budget int) *sourceCache {
+	return &sourceCache{
+		budget:  budget,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// get returns the image decoded from path if it was not modified since
+func (c *sourceCache) get(path string, modTime time.Time) (image.Image, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[path]
+	if !ok {
+		return nil, false
+	}
+
+	entry := elem.Value.(*sourceCacheEntry)
+	if !entry.modTime.Equal(modTime) {
+		c.removeElement(elem)
+		return nil, false
+	}
+
+	c.order.MoveToFront(elem)
+	return entry.img, true
+}
+
+// add keeps img decoded from path, evicting the least recently used images over budget
+// Images larger than the whole budget are not kept
+func (c *sourceCache) add(path string, modTime time.Time, img image.Image) {
+	bounds := img.Bounds()
+	pixels := bounds.Dx() * bounds.Dy()
+	if pixels > c.budget {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[path]; ok {
+		c.removeElement(elem)
+	}
+
+	entry := &sourceCacheEntry{path: path, modTime: modTime, img: img, pixels: pixels}
+	c.entries[path] = c.order.PushFront(entry)
+	c.pixels += pixels
+
+	for c.pixels > c.budget {
+		c.removeElement(c.order.Back())
+	}
+}
+
+// removeElement removes an entry, the lock being held
+func (c *sourceCache) removeElement(elem *list.Element) {
+	entry := c.order.Remove(elem).(*sourceCacheEntry)
+	delete(c.entries, entry.path)
+	c.pixels -= entry.pixels
+}
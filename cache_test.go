@@ -0,0 +1,40 @@
+package upload
+
+import (
+	"image"
+	"testing"
+	"time"
+)
+
+func TestSourceCache(t *testing.T) {
+	cache := newSourceCache(250)
+	modTime := time.Now()
+	img := func() image.Image { return image.NewGray(image.Rect(0, 0, 10, 10)) }
+
+	cache.add("a.jpg", modTime, img())
+	cache.add("b.jpg", modTime, img())
+	if _, ok := cache.get("a.jpg", modTime); !ok {
+		t.Fatal("expected a.jpg cached")
+	}
+
+	// b.jpg is the least recently used
+	cache.add("c.jpg", modTime, img())
+	if _, ok := cache.get("b.jpg", modTime); ok {
+		t.Error("expected b.jpg evicted")
+	}
+	if cache.pixels != 200 {
+		t.Errorf("expected 200 pixels kept, got %d", cache.pixels)
+	}
+
+	if _, ok := cache.get("a.jpg", modTime.Add(time.Second)); ok {
+		t.Error("expected a.jpg invalidated by mod time")
+	}
+	if _, ok := cache.get("a.jpg", modTime); ok {
+		t.Error("expected a.jpg removed once invalidated")
+	}
+
+	cache.add("huge.jpg", modTime, image.NewGray(image.Rect(0, 0, 100, 100)))
+	if _, ok := cache.get("huge.jpg", modTime); ok {
+		t.Error("expected image over budget not cached")
+	}
+}
@@ -0,0 +1,62 @@
+package upload
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"time"
+)
+
+// exifTimeLayout is the layout of EXIF date and time values
+const exifTimeLayout = "2006:01:02 15:04:05"
+
+// CaptureTime returns the EXIF DateTimeOriginal of the image at path
+// The boolean is false if the image carries no capture time.
+// EXIF timestamps have no timezone: they are returned in time.Local
+// and should be treated as the wall clock time of the camera.
+func CaptureTime(path string) (time.Time, bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	defer file.Close()
+
+	return captureTime(file)
+}
+
+// captureTime reads the EXIF DateTimeOriginal of a JPEG stream
+func captureTime(r io.Reader) (time.Time, bool, error) {
+	exif, err := readExif(r)
+	if err == errNoExif {
+		return time.Time{}, false, nil
+	} else if err != nil {
+		return time.Time{}, false, err
+	}
+
+	ifd0, _, err := exif.ifd(exif.ifd0Offset())
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	pointer, ok := exif.uint(ifd0[exifTagExifIFD])
+	if !ok {
+		return time.Time{}, false, nil
+	}
+
+	exifIFD, _, err := exif.ifd(pointer)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	entry, ok := exifIFD[exifTagDateTimeOriginal]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+
+	t, err := time.ParseInLocation(exifTimeLayout, string(bytes.TrimRight(entry.value, "\x00 ")), time.Local)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	return t, true, nil
+}
@@ -0,0 +1,125 @@
+package upload
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Chain runs image processors as stages, the variant of each stage being the source of the next one
+// e.g. a first stage normalizing images and a second one generating variants with its own options
+type Chain struct {
+	stages []*ImageProcessor
+}
+
+// ChainJob represents an image file going through the stages of a Chain
+type ChainJob struct {
+	Jobs []*Job // Jobs of the stages run, in order
+	Err  error  // Error of the first failed stage, if any
+	Done chan struct{}
+}
+
+// NewChain returns a Chain of stages
+// Every stage but the last must have a single format, whose variant (on disk) feeds the next stage
+func NewChain(stages ...*ImageProcessor) (*Chain, error) {
+	if len(stages) == 0 {
+		return nil, fmt.Errorf("chain has no stages")
+	}
+
+	for i, stage := range stages[:len(stages)-1] {
+		if n := len(stage.validFormats()); n != 1 {
+			return nil, fmt.Errorf("chain stage %d has %d formats, expected 1", i, n)
+		}
+	}
+
+	return &Chain{stages: stages}, nil
+}
+
+// Process processes file through every stage, the first failure stopping the chain
+func (c *Chain) Process(file Uploaded, validate bool) (*ChainJob, error) {
+	job, err := c.stages[0].Process(file, validate)
+	if err != nil {
+		return nil, err
+	}
+
+	chainJob := &ChainJob{
+		Jobs: []*Job{job},
+		Done: make(chan struct{}),
+	}
+	go c.run(chainJob, validate)
+
+	return chainJob, nil
+}
+
+// stageErr returns the error of stage i, if any
+func (c *ChainJob) stageErr(i int) error {
+	job := c.Jobs[i]
+	if job.Err != nil {
+		return fmt.Errorf("chain stage %d: %v", i, job.Err)
+	}
+	if len(job.Failed) > 0 {
+		return fmt.Errorf("chain stage %d: %v", i, job.Failed[0])
+	}
+	return nil
+}
+
+// run waits for each stage, starting the next one on the variant of the previous one
+func (c *Chain) run(chainJob *ChainJob, validate bool) {
+	for i := range c.stages {
+		job := chainJob.Jobs[i]
+		<-job.Done
+
+		if chainJob.Err = chainJob.stageErr(i); chainJob.Err != nil {
+			log.Printf("%v\n", chainJob.Err)
+			break
+		}
+		if i == len(c.stages)-1 {
+			break
+		}
+
+		next, err := c.stages[i].stageOutput(job.File, job.Type)
+		if err == nil {
+			job, err = c.stages[i+1].Process(next, validate)
+		}
+		if err != nil {
+			chainJob.Err = fmt.Errorf("chain stage %d: %v", i+1, err)
+			log.Printf("%v\n", chainJob.Err)
+			break
+		}
+		chainJob.Jobs = append(chainJob.Jobs, job)
+	}
+
+	chainJob.Done <- struct{}{}
+}
+
+// stageOutput returns the variant of the single format of p as an uploaded file
+// Variants named without extension (e.g. name.jpg:format) are renamed name_format.jpg
+// so that the next stage can tell their type
+func (p *ImageProcessor) stageOutput(file Uploaded, imgType string) (*UploadedFile, error) {
+	format := p.options.sourceFormats(imgType, p.validFormats())[0]
+	fileDiskPath := p.options.variantPath(file.DiskPath(), format)
+
+	if !p.options.converted(file.DiskPath(), format) {
+		ext := filepath.Ext(file.DiskPath())
+		stageDiskPath := strings.TrimSuffix(file.DiskPath(), ext) + "_" + format.name + ext
+		if err := os.Rename(fileDiskPath, stageDiskPath); err != nil {
+			return nil, err
+		}
+		fileDiskPath = stageDiskPath
+	}
+
+	content, err := ioutil.ReadFile(fileDiskPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &UploadedFile{
+		url:      path.Join(path.Dir(file.URLPath()), filepath.Base(fileDiskPath)),
+		diskPath: fileDiskPath,
+		content:  content,
+	}, nil
+}
@@ -0,0 +1,85 @@
+package upload
+
+import (
+	"image"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestChain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content, err := ioutil.ReadFile(filepath.Join("testdata", "normal.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := &UploadedFile{diskPath: filepath.Join(dir, "normal.jpg"), url: "/media/normal.jpg", content: content}
+	if err := ioutil.WriteFile(file.diskPath, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewChain(NewImageProcessor(Formats("a", 100, 0, false), Formats("b", 200, 0, false)), NewImageProcessor()); err == nil {
+		t.Error("expected error for a stage with several formats")
+	}
+
+	chain, err := NewChain(
+		NewImageProcessor(Formats("normalized", 300, 0, false)),
+		NewImageProcessor(Formats("thumb", 100, 100, false)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job, err := chain.Process(file, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-job.Done
+
+	if job.Err != nil {
+		t.Fatal(job.Err)
+	}
+	if len(job.Jobs) != 2 {
+		t.Fatalf("expected 2 stage jobs, got %d", len(job.Jobs))
+	}
+
+	normalized := filepath.Join(dir, "normal_normalized.jpg")
+	if job.Jobs[1].File.DiskPath() != normalized || job.Jobs[1].File.URLPath() != "/media/normal_normalized.jpg" {
+		t.Errorf("unexpected stage file %v %v", job.Jobs[1].File.DiskPath(), job.Jobs[1].File.URLPath())
+	}
+
+	for fileDiskPath, want := range map[string]int{normalized: 300, normalized + ":thumb": 100} {
+		f, err := os.Open(fileDiskPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config, _, err := image.DecodeConfig(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config.Width != want {
+			t.Errorf("%v: expected width %d, got %d", fileDiskPath, want, config.Width)
+		}
+	}
+
+	// Failures stop the chain
+	failing, _ := NewChain(
+		NewImageProcessor(Formats("normalized", 300, 0, false), WithStorage(failingStorage{})),
+		NewImageProcessor(Formats("thumb", 100, 100, false)),
+	)
+	job, err = failing.Process(file, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-job.Done
+	if job.Err == nil || len(job.Jobs) != 1 {
+		t.Errorf("expected chain to stop at first stage, got %v after %d stages", job.Err, len(job.Jobs))
+	}
+}
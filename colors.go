@@ -0,0 +1,51 @@
+package upload
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// isPaletted checks if images of model are paletted (indexed color)
+func isPaletted(model color.Model) bool {
+	_, ok := model.(color.Palette)
+	return ok
+}
+
+// fromPalette converts paletted images to NRGBA; images in other color models are returned as is
+func fromPalette(img image.Image) image.Image {
+	paletted, ok := img.(*image.Paletted)
+	if !ok {
+		return img
+	}
+
+	bounds := paletted.Bounds()
+	nrgba := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(nrgba, nrgba.Bounds(), paletted, bounds.Min, draw.Src)
+	return nrgba
+}
+
+// toRGB converts CMYK images to RGB so that resizing works on the right colors
+// Inverted Adobe (APP14) CMYK JPEGs are already un-inverted by the JPEG decoder
+// Images in other color models are returned as is
+func toRGB(img image.Image) image.Image {
+	cmyk, ok := img.(*image.CMYK)
+	if !ok {
+		return img
+	}
+
+	bounds := cmyk.Bounds()
+	rgb := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := cmyk.CMYKAt(x, y)
+			r, g, b := color.CMYKToRGB(c.C, c.M, c.Y, c.K)
+			i := rgb.PixOffset(x-bounds.Min.X, y-bounds.Min.Y)
+			rgb.Pix[i+0] = r
+			rgb.Pix[i+1] = g
+			rgb.Pix[i+2] = b
+			rgb.Pix[i+3] = 0xff
+		}
+	}
+	return rgb
+}
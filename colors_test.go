@@ -0,0 +1,66 @@
+package upload
+
+import (
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestToRGBFromCMYKJPEG(t *testing.T) {
+	file, err := os.Open(filepath.Join("testdata", "cmyk.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	// cmyk.jpg is a pure cyan Adobe (APP14) CMYK JPEG
+	img, _, err := image.Decode(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := img.(*image.CMYK); !ok {
+		t.Fatalf("expected CMYK image, got %T", img)
+	}
+
+	rgb := toRGB(img)
+	if _, ok := rgb.(*image.NRGBA); !ok {
+		t.Fatalf("expected NRGBA image, got %T", rgb)
+	}
+
+	expected := color.NRGBA{0, 255, 255, 255}
+	if c := rgb.(*image.NRGBA).NRGBAAt(3, 3); c != expected {
+		t.Errorf("expected %v, got %v", expected, c)
+	}
+}
+
+func TestFromPalette(t *testing.T) {
+	palette := color.Palette{color.NRGBA{255, 0, 0, 255}, color.NRGBA{0, 0, 255, 128}}
+	paletted := image.NewPaletted(image.Rect(2, 2, 6, 6), palette)
+	paletted.SetColorIndex(3, 3, 1)
+
+	if !isPaletted(paletted.ColorModel()) {
+		t.Error("expected paletted color model")
+	}
+
+	img := fromPalette(paletted)
+	nrgba, ok := img.(*image.NRGBA)
+	if !ok {
+		t.Fatalf("expected NRGBA image, got %T", img)
+	}
+	if nrgba.Bounds() != image.Rect(0, 0, 4, 4) {
+		t.Errorf("expected bounds at origin, got %v", nrgba.Bounds())
+	}
+	if c := nrgba.NRGBAAt(1, 1); c != (color.NRGBA{0, 0, 255, 128}) {
+		t.Errorf("expected translucent blue, got %v", c)
+	}
+	if c := nrgba.NRGBAAt(0, 0); c != (color.NRGBA{255, 0, 0, 255}) {
+		t.Errorf("expected red, got %v", c)
+	}
+
+	rgba := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	if fromPalette(rgba) != image.Image(rgba) {
+		t.Error("expected non paletted image as is")
+	}
+}
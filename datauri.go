@@ -0,0 +1,49 @@
+package upload
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/h2non/filetype"
+)
+
+// parseDataURI decodes a base64 data URI (data:<mime>;base64,<payload>) and returns its content
+// The declared MIME type must match the content and start with mimePrefix
+func parseDataURI(dataURI string, mimePrefix string) ([]byte, error) {
+	if !strings.HasPrefix(dataURI, "data:") {
+		return nil, fmt.Errorf("invalid data uri: missing data scheme")
+	}
+
+	comma := strings.IndexByte(dataURI, ',')
+	if comma < 0 {
+		return nil, fmt.Errorf("invalid data uri: missing payload")
+	}
+
+	params := strings.Split(dataURI[len("data:"):comma], ";")
+	if params[len(params)-1] != "base64" {
+		return nil, fmt.Errorf("invalid data uri: payload not base64")
+	}
+
+	mime := strings.ToLower(params[0])
+	if !strings.HasPrefix(mime, mimePrefix) {
+		return nil, fmt.Errorf("data uri mime type %q not allowed", mime)
+	}
+
+	// Padding is often dropped by clients
+	payload := strings.TrimRight(dataURI[comma+1:], "=")
+	content, err := base64.RawStdEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data uri payload: %v", err)
+	}
+
+	kind, err := filetype.Match(content)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving data uri type: %v", err)
+	}
+	if kind.MIME.Value != mime {
+		return nil, fmt.Errorf("data uri mime type %q does not match content %q", mime, kind.MIME.Value)
+	}
+
+	return content, nil
+}
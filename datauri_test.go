@@ -0,0 +1,49 @@
+package upload
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseDataURI(t *testing.T) {
+	content, err := ioutil.ReadFile(filepath.Join("testdata", "normal.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := base64.StdEncoding.EncodeToString(content)
+
+	tests := []struct {
+		name    string
+		dataURI string
+		wantErr bool
+	}{
+		{"Valid", "data:image/png;base64," + payload, false},
+		{"Unpadded", "data:image/png;base64," + base64.RawStdEncoding.EncodeToString(content), false},
+		{"Parameters", "data:image/png;name=normal.png;base64," + payload, false},
+		{"Missing Scheme", "image/png;base64," + payload, true},
+		{"Missing Payload", "data:image/png;base64", true},
+		{"Not Base64", "data:image/png," + payload, true},
+		{"Malformed Payload", "data:image/png;base64,!!!", true},
+		{"MIME Mismatch", "data:image/jpeg;base64," + payload, true},
+		{"MIME Not Allowed", "data:text/plain;base64," + payload, true},
+	}
+
+	for _, test := range tests {
+		decoded, err := parseDataURI(test.dataURI, "image/")
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if string(decoded) != string(content) {
+			t.Errorf("%s: decoded content differs", test.name)
+		}
+	}
+}
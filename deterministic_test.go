@@ -0,0 +1,32 @@
+package upload
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func TestDeterministic(t *testing.T) {
+	storage := newMemStorage()
+	p := NewImageProcessor(
+		Formats("thumb", 200, 150, false),
+		Deterministic(true),
+		TargetSSIM(0.95),
+		WithStorage(storage),
+	)
+	format, _ := p.Options().Format("thumb")
+	srcDiskPath := filepath.Join("testdata", "normal.jpg")
+
+	var outputs [][]byte
+	for i := 0; i < 2; i++ {
+		fileDiskPath, err := p.GetOrGenerate(srcDiskPath, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		outputs = append(outputs, append([]byte(nil), storage.files[fileDiskPath].Bytes()...))
+	}
+
+	if len(outputs[0]) == 0 || !bytes.Equal(outputs[0], outputs[1]) {
+		t.Error("expected identical output bytes")
+	}
+}
@@ -0,0 +1,92 @@
+package upload
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// densityWriter inserts a density segment into an encoded image stream at a fixed offset
+// e.g. right after the JPEG SOI marker or the PNG IHDR chunk
+type densityWriter struct {
+	w       io.Writer
+	offset  int    // Stream offset the segment is inserted at
+	segment []byte // Segment to insert, nil once written
+	written int    // Bytes of the stream written so far
+}
+
+// newDensityWriter returns a writer setting the density of an image encoded to w in format f
+// Formats without density metadata are written as is
+func newDensityWriter(w io.Writer, f imaging.Format, dpi int) io.Writer {
+	switch f {
+	case imaging.JPEG:
+		return &densityWriter{w: w, offset: 2, segment: jfifSegment(dpi)}
+	case imaging.PNG:
+		// Signature, then IHDR chunk: length, type, 13 bytes of data, CRC
+		return &densityWriter{w: w, offset: 8 + 4 + 4 + 13 + 4, segment: physChunk(dpi)}
+	}
+	return w
+}
+
+func (d *densityWriter) Write(b []byte) (int, error) {
+	if d.segment == nil {
+		return d.w.Write(b)
+	}
+
+	head := d.offset - d.written
+	if head > len(b) {
+		n, err := d.w.Write(b)
+		d.written += n
+		return n, err
+	}
+
+	if n, err := d.w.Write(b[:head]); err != nil {
+		return n, err
+	}
+	if _, err := d.w.Write(d.segment); err != nil {
+		return head, err
+	}
+	d.segment = nil
+
+	n, err := d.w.Write(b[head:])
+	return head + n, err
+}
+
+// jfifSegment returns a JFIF APP0 segment of dpi dots per inch
+func jfifSegment(dpi int) []byte {
+	segment := []byte{
+		0xff, 0xe0, // APP0 marker
+		0x00, 0x10, // Length
+		'J', 'F', 'I', 'F', 0x00,
+		0x01, 0x01, // Version 1.01
+		0x01,       // Units: dots per inch
+		0, 0, 0, 0, // X and Y density
+		0x00, 0x00, // No thumbnail
+	}
+	density := uint16(dpi)
+	if dpi > math.MaxUint16 {
+		density = math.MaxUint16
+	}
+	binary.BigEndian.PutUint16(segment[12:], density)
+	binary.BigEndian.PutUint16(segment[14:], density)
+	return segment
+}
+
+// physChunk returns a PNG pHYs chunk of dpi dots per inch
+func physChunk(dpi int) []byte {
+	chunk := make([]byte, 4+4+9+4)
+	binary.BigEndian.PutUint32(chunk[0:], 9)
+	copy(chunk[4:], "pHYs")
+
+	// Density in pixels per meter
+	ppm := uint32(math.Round(float64(dpi) / 0.0254))
+	binary.BigEndian.PutUint32(chunk[8:], ppm)
+	binary.BigEndian.PutUint32(chunk[12:], ppm)
+	chunk[16] = 1 // Unit: meter
+
+	binary.BigEndian.PutUint32(chunk[17:], crc32.ChecksumIEEE(chunk[4:17]))
+	return chunk
+}
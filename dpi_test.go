@@ -0,0 +1,61 @@
+package upload
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestDensityWriter(t *testing.T) {
+	img := imaging.New(40, 30, color.NRGBA{200, 100, 50, 255})
+
+	t.Run("JPEG", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := imaging.Encode(newDensityWriter(&buf, imaging.JPEG, 300), img, imaging.JPEG); err != nil {
+			t.Fatal(err)
+		}
+
+		b := buf.Bytes()
+		if !bytes.Equal(b[2:4], []byte{0xff, 0xe0}) || string(b[6:11]) != "JFIF\x00" {
+			t.Fatalf("expected JFIF segment after SOI, got % x", b[:16])
+		}
+		if units, x, y := b[13], binary.BigEndian.Uint16(b[14:]), binary.BigEndian.Uint16(b[16:]); units != 1 || x != 300 || y != 300 {
+			t.Errorf("expected 300 dpi, got units %d density %dx%d", units, x, y)
+		}
+		if _, err := jpeg.Decode(&buf); err != nil {
+			t.Errorf("output not decodable: %v", err)
+		}
+	})
+
+	t.Run("PNG", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := imaging.Encode(newDensityWriter(&buf, imaging.PNG, 300), img, imaging.PNG); err != nil {
+			t.Fatal(err)
+		}
+
+		b := buf.Bytes()
+		chunk := b[33:]
+		if string(chunk[4:8]) != "pHYs" {
+			t.Fatalf("expected pHYs chunk after IHDR, got %q", chunk[4:8])
+		}
+		// 300 dpi is 11811 pixels per meter
+		if x, y, unit := binary.BigEndian.Uint32(chunk[8:]), binary.BigEndian.Uint32(chunk[12:]), chunk[16]; x != 11811 || y != 11811 || unit != 1 {
+			t.Errorf("expected 11811 pixels per meter, got %dx%d unit %d", x, y, unit)
+		}
+		if _, err := png.Decode(&buf); err != nil {
+			t.Errorf("output not decodable: %v", err)
+		}
+	})
+
+	t.Run("GIF", func(t *testing.T) {
+		var buf bytes.Buffer
+		if w := newDensityWriter(&buf, imaging.GIF, 300); w != &buf {
+			t.Error("expected GIF output unchanged")
+		}
+	})
+}
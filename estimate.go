@@ -0,0 +1,102 @@
+package upload
+
+import (
+	"image"
+
+	"github.com/disintegration/imaging"
+)
+
+// Heuristic sizes of encoded photos, in bytes per pixel
+const (
+	estimatePNG       = 2.0  // Truecolor PNG
+	estimatePaletted  = 0.6  // Paletted PNG and GIF
+	estimateJPEGLow   = 0.15 // JPEG at quality 50 and below
+	estimateJPEGHigh  = 0.9  // JPEG at quality 100
+	estimateJPEGScale = 50.0 // Qualities the JPEG range spans
+)
+
+// EstimateOutputSize returns an estimate of the total bytes of the variants of an image
+// of config and type imgType (e.g. "jpeg"), from their dimensions, encoding and quality,
+// without decoding nor encoding anything. It is a rough guide for e.g. quota pre-checks, not
+// a guarantee: actual sizes depend on the content and may differ several fold
+func (p *ImageProcessor) EstimateOutputSize(config image.Config, imgType string) int64 {
+	var total float64
+	for _, format := range p.options.sourceFormats(imgType, p.validFormats()) {
+		format = p.options.capFormat(format)
+		if format.skipIfSmaller && format.smallerSource(config.Width, config.Height) {
+			continue
+		}
+
+		f, err := imaging.FormatFromExtension(imgType)
+		if format.output != "" {
+			f, err = imaging.FormatFromExtension(normalizeExt(format.output))
+		}
+		if err != nil {
+			continue
+		}
+
+		width, height := variantSize(format, config.Width, config.Height)
+		total += float64(width*height) * p.bytesPerPixel(f)
+	}
+	return int64(total)
+}
+
+// bytesPerPixel returns the estimated bytes per pixel of variants encoded in format f
+func (p *ImageProcessor) bytesPerPixel(f imaging.Format) float64 {
+	switch f {
+	case imaging.JPEG:
+		quality := 75 // Default of imaging
+		if p.options.deterministic {
+			quality = 95
+		}
+		if quality <= 50 {
+			return estimateJPEGLow
+		}
+		return estimateJPEGLow + (estimateJPEGHigh-estimateJPEGLow)*float64(quality-50)/estimateJPEGScale
+	case imaging.PNG:
+		if p.options.quantizeColors > 0 {
+			return estimatePaletted
+		}
+		return estimatePNG
+	case imaging.GIF:
+		return estimatePaletted
+	}
+	// Uncompressed
+	return 4
+}
+
+// variantSize returns the dimensions of the variant of format for a source of the given size,
+// following Resize and Backdrop
+func variantSize(format Format, srcW, srcH int) (int, int) {
+	if srcW <= 0 || srcH <= 0 {
+		return 0, 0
+	}
+	if format.maxLongSide > 0 {
+		return format.longSideSize(srcW, srcH)
+	}
+	if format.native() {
+		return srcW, srcH
+	}
+	if _diskPathBackdrop != "" && format.backdrop && srcH >= srcW {
+		return format.width, format.height
+	}
+
+	width, height := format.width, format.height
+	if width > srcW && !format.upscaleWidth {
+		width = srcW
+	}
+	if height > srcH && !format.upscaleHeight {
+		height = srcH
+	}
+
+	// Preserve the aspect ratio of free dimensions
+	switch {
+	case width <= 0 && height <= 0:
+		return 0, 0
+	case width <= 0:
+		width = srcW * height / srcH
+	case height <= 0:
+		height = srcH * width / srcW
+	}
+	return width, height
+}
@@ -0,0 +1,57 @@
+package upload
+
+import (
+	"image"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestVariantSize(t *testing.T) {
+	options := EvaluateImageOptions(
+		Formats("thumb", 200, 200, false),
+		Formats("hero", 1200, 0, false),
+		Formats("tall", 0, 300, false),
+		Formats("huge", 4000, 4000, false),
+		Formats("up", 1200, 0, false),
+		FormatOptions("up", AllowUpscale(true, true)),
+	)
+
+	tests := []struct {
+		format string
+		want   image.Point
+	}{
+		{"thumb", image.Pt(200, 200)},
+		{"hero", image.Pt(800, 600)},
+		{"tall", image.Pt(400, 300)},
+		{"huge", image.Pt(800, 600)},
+		{"up", image.Pt(1200, 900)},
+	}
+
+	for _, test := range tests {
+		format, _ := options.Format(test.format)
+		if width, height := variantSize(format, 800, 600); image.Pt(width, height) != test.want {
+			t.Errorf("%s: expected %v, got %vx%v", test.format, test.want, width, height)
+		}
+	}
+}
+
+func TestEstimateOutputSize(t *testing.T) {
+	config := image.Config{Width: 800, Height: 600}
+
+	jpeg := NewImageProcessor(Formats("thumb", 200, 200, false), Formats("hero", 800, 0, false))
+	estimate := jpeg.EstimateOutputSize(config, "jpeg")
+	if want := int64(float64(200*200+800*600) * jpeg.bytesPerPixel(imaging.JPEG)); estimate != want {
+		t.Errorf("expected %d, got %d", want, estimate)
+	}
+
+	png := NewImageProcessor(Formats("thumb", 200, 200, false), Formats("hero", 800, 0, false))
+	if pngEstimate := png.EstimateOutputSize(config, "png"); pngEstimate <= estimate {
+		t.Errorf("expected PNG estimate %d larger than JPEG estimate %d", pngEstimate, estimate)
+	}
+
+	skipped := NewImageProcessor(Formats("huge", 4000, 0, false), FormatOptions("huge", SkipIfSmaller(true)))
+	if skippedEstimate := skipped.EstimateOutputSize(config, "jpeg"); skippedEstimate != 0 {
+		t.Errorf("expected skipped formats not counted, got %d", skippedEstimate)
+	}
+}
@@ -0,0 +1,196 @@
+package upload
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+)
+
+// EXIF tags used by gocipe-upload
+const (
+	exifTagExifIFD          = 0x8769
+	exifTagOrientation      = 0x0112
+	exifTagDateTimeOriginal = 0x9003
+	exifTagThumbnailOffset  = 0x0201
+	exifTagThumbnailLength  = 0x0202
+)
+
+// EXIF value types
+const (
+	exifTypeShort = 3
+	exifTypeLong  = 4
+)
+
+// errNoExif is returned when an image carries no EXIF data
+var errNoExif = fmt.Errorf("no exif data")
+
+// exifData holds the TIFF structure of an EXIF segment
+type exifData struct {
+	tiff  []byte
+	order binary.ByteOrder
+}
+
+// exifEntry holds a raw IFD entry
+type exifEntry struct {
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+// readExif extracts the EXIF segment of a JPEG stream
+func readExif(r io.Reader) (*exifData, error) {
+	br := bufio.NewReader(r)
+
+	var soi [2]byte
+	if _, err := io.ReadFull(br, soi[:]); err != nil || soi[0] != 0xFF || soi[1] != 0xD8 {
+		return nil, errNoExif
+	}
+
+	for {
+		var marker [4]byte
+		if _, err := io.ReadFull(br, marker[:]); err != nil {
+			return nil, errNoExif
+		}
+		if marker[0] != 0xFF {
+			return nil, fmt.Errorf("invalid jpeg marker")
+		}
+		// Start of scan or end of image: no more metadata
+		if marker[1] == 0xDA || marker[1] == 0xD9 {
+			return nil, errNoExif
+		}
+
+		length := int(binary.BigEndian.Uint16(marker[2:])) - 2
+		if length < 0 {
+			return nil, fmt.Errorf("invalid jpeg segment length")
+		}
+		segment := make([]byte, length)
+		if _, err := io.ReadFull(br, segment); err != nil {
+			return nil, err
+		}
+
+		if marker[1] == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return parseTIFF(segment[6:])
+		}
+	}
+}
+
+// parseTIFF checks the TIFF header of EXIF data
+func parseTIFF(tiff []byte) (*exifData, error) {
+	if len(tiff) < 8 {
+		return nil, fmt.Errorf("exif data too short")
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("invalid exif byte order")
+	}
+
+	return &exifData{tiff: tiff, order: order}, nil
+}
+
+// ifd0Offset returns the offset of the first IFD
+func (e *exifData) ifd0Offset() uint32 {
+	return e.order.Uint32(e.tiff[4:8])
+}
+
+// ifd reads the entries of the IFD at offset and the offset of the next IFD
+func (e *exifData) ifd(offset uint32) (map[uint16]exifEntry, uint32, error) {
+	if int(offset)+2 > len(e.tiff) {
+		return nil, 0, fmt.Errorf("exif ifd out of range")
+	}
+
+	count := int(e.order.Uint16(e.tiff[offset:]))
+	start := int(offset) + 2
+	if start+count*12+4 > len(e.tiff) {
+		return nil, 0, fmt.Errorf("exif ifd out of range")
+	}
+
+	entries := make(map[uint16]exifEntry, count)
+	for i := 0; i < count; i++ {
+		raw := e.tiff[start+i*12 : start+i*12+12]
+		entry := exifEntry{
+			typ:   e.order.Uint16(raw[2:4]),
+			count: e.order.Uint32(raw[4:8]),
+		}
+
+		size := int(entry.count) * exifTypeSize(entry.typ)
+		if size <= 4 {
+			entry.value = raw[8 : 8+size]
+		} else {
+			valueOffset := int(e.order.Uint32(raw[8:12]))
+			if valueOffset+size > len(e.tiff) {
+				continue
+			}
+			entry.value = e.tiff[valueOffset : valueOffset+size]
+		}
+
+		entries[e.order.Uint16(raw[0:2])] = entry
+	}
+
+	next := e.order.Uint32(e.tiff[start+count*12:])
+	return entries, next, nil
+}
+
+// uint returns the first value of a SHORT or LONG entry
+func (e *exifData) uint(entry exifEntry) (uint32, bool) {
+	switch {
+	case entry.typ == exifTypeShort && len(entry.value) >= 2:
+		return uint32(e.order.Uint16(entry.value)), true
+	case entry.typ == exifTypeLong && len(entry.value) >= 4:
+		return e.order.Uint32(entry.value), true
+	}
+	return 0, false
+}
+
+// exifThumbnail decodes the JPEG thumbnail embedded in the EXIF IFD1 of a JPEG stream
+func exifThumbnail(r io.Reader) (image.Image, error) {
+	exif, err := readExif(r)
+	if err != nil {
+		return nil, err
+	}
+
+	_, next, err := exif.ifd(exif.ifd0Offset())
+	if err != nil {
+		return nil, err
+	}
+	if next == 0 {
+		return nil, errNoExif
+	}
+
+	ifd1, _, err := exif.ifd(next)
+	if err != nil {
+		return nil, err
+	}
+
+	offset, okOffset := exif.uint(ifd1[exifTagThumbnailOffset])
+	length, okLength := exif.uint(ifd1[exifTagThumbnailLength])
+	if !okOffset || !okLength || int(offset)+int(length) > len(exif.tiff) {
+		return nil, errNoExif
+	}
+
+	return jpeg.Decode(bytes.NewReader(exif.tiff[offset : offset+length]))
+}
+
+// exifTypeSize returns the size in bytes of one value of an EXIF type
+func exifTypeSize(typ uint16) int {
+	switch typ {
+	case 1, 2, 6, 7: // BYTE, ASCII, SBYTE, UNDEFINED
+		return 1
+	case 3, 8: // SHORT, SSHORT
+		return 2
+	case 4, 9, 11: // LONG, SLONG, FLOAT
+		return 4
+	case 5, 10, 12: // RATIONAL, SRATIONAL, DOUBLE
+		return 8
+	}
+	return 0
+}
@@ -0,0 +1,105 @@
+package upload
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"testing"
+	"time"
+)
+
+// exifIFDEntry describes an entry of a test IFD
+type exifIFDEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte // inline when <= 4 bytes, otherwise appended after the IFD
+}
+
+// buildIFD encodes a little endian IFD at offset with out of line values following it
+func buildIFD(offset uint32, entries []exifIFDEntry, next uint32) []byte {
+	le := binary.LittleEndian
+	ifd := make([]byte, 2+len(entries)*12+4)
+	le.PutUint16(ifd, uint16(len(entries)))
+
+	var extra []byte
+	extraOffset := offset + uint32(len(ifd))
+	for i, entry := range entries {
+		raw := ifd[2+i*12:]
+		le.PutUint16(raw[0:], entry.tag)
+		le.PutUint16(raw[2:], entry.typ)
+		le.PutUint32(raw[4:], entry.count)
+		if len(entry.value) <= 4 {
+			copy(raw[8:12], entry.value)
+		} else {
+			le.PutUint32(raw[8:], extraOffset+uint32(len(extra)))
+			extra = append(extra, entry.value...)
+		}
+	}
+	le.PutUint32(ifd[2+len(entries)*12:], next)
+
+	return append(ifd, extra...)
+}
+
+// buildExifJPEG returns the start of a JPEG stream with an APP1 EXIF segment holding tiff
+func buildExifJPEG(tiff []byte) []byte {
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	jpeg := []byte{0xFF, 0xD8, 0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(jpeg[4:], uint16(len(segment)+2))
+	jpeg = append(jpeg, segment...)
+	return append(jpeg, 0xFF, 0xD9)
+}
+
+func uint32LE(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	return b
+}
+
+func TestCaptureTime(t *testing.T) {
+	header := []byte{'I', 'I', 42, 0, 8, 0, 0, 0}
+	ifd0 := buildIFD(8, []exifIFDEntry{{exifTagExifIFD, exifTypeLong, 1, uint32LE(26)}}, 0)
+	exifIFD := buildIFD(26, []exifIFDEntry{{exifTagDateTimeOriginal, 2, 20, []byte("2019:02:14 10:30:00\x00")}}, 0)
+	tiff := append(append(header, ifd0...), exifIFD...)
+
+	captured, ok, err := captureTime(bytes.NewReader(buildExifJPEG(tiff)))
+	if err != nil || !ok {
+		t.Fatalf("capture time not found: %v", err)
+	}
+
+	expected := time.Date(2019, 2, 14, 10, 30, 0, 0, time.Local)
+	if !captured.Equal(expected) {
+		t.Errorf("expected %v, got %v", expected, captured)
+	}
+}
+
+func TestCaptureTimeMissing(t *testing.T) {
+	_, ok, err := CaptureTime("testdata/normal.png")
+	if err != nil || ok {
+		t.Errorf("expected no capture time, got %v, %v", ok, err)
+	}
+}
+
+func TestExifThumbnail(t *testing.T) {
+	var thumb bytes.Buffer
+	if err := jpeg.Encode(&thumb, image.NewGray(image.Rect(0, 0, 16, 12)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	header := []byte{'I', 'I', 42, 0, 8, 0, 0, 0}
+	ifd0 := buildIFD(8, nil, 14)
+	ifd1 := buildIFD(14, []exifIFDEntry{
+		{exifTagThumbnailOffset, exifTypeLong, 1, uint32LE(44)},
+		{exifTagThumbnailLength, exifTypeLong, 1, uint32LE(uint32(thumb.Len()))},
+	}, 0)
+	tiff := append(append(append(header, ifd0...), ifd1...), thumb.Bytes()...)
+
+	img, err := exifThumbnail(bytes.NewReader(buildExifJPEG(tiff)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds() != image.Rect(0, 0, 16, 12) {
+		t.Errorf("unexpected thumbnail bounds: %v", img.Bounds())
+	}
+}
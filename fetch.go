@@ -0,0 +1,97 @@
+package upload
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/lsldigital/gocipe-upload/core"
+)
+
+// fetchURL retrieves a remote file and returns its name and content
+func fetchURL(rawURL string, opts Options, contentTypePrefix string) (string, []byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid url: %v", err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", nil, fmt.Errorf("unsupported url scheme %q", u.Scheme)
+	}
+
+	if !opts.HostAllowed(u.Hostname()) {
+		log.Printf("url %v host not allowed\n", rawURL)
+		return "", nil, fmt.Errorf("host %v not allowed", u.Hostname())
+	}
+
+	resp, err := fetchClient(opts).Get(u.String())
+	if err != nil {
+		log.Printf("error fetching %v: %v\n", rawURL, err)
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", nil, fmt.Errorf("fetch %v failed: %v", rawURL, resp.Status)
+	}
+
+	if contentType := resp.Header.Get("Content-Type"); !strings.HasPrefix(contentType, contentTypePrefix) {
+		return "", nil, fmt.Errorf("content type %q not allowed", contentType)
+	}
+
+	var body io.Reader = resp.Body
+	if opts.maxSize != core.NoLimit {
+		if resp.ContentLength > int64(opts.maxSize) {
+			log.Printf("file %v greater than max file size: %v\n", rawURL, opts.maxSize)
+			return "", nil, fmt.Errorf("file max size error")
+		}
+		// Read one extra byte so that oversized bodies are caught by Save
+		body = io.LimitReader(resp.Body, int64(opts.maxSize)+1)
+	}
+
+	content, err := ioutil.ReadAll(body)
+	if err != nil {
+		log.Printf("error reading %v: %v\n", rawURL, err)
+		return "", nil, err
+	}
+
+	name := path.Base(u.Path)
+	if name == "/" || name == "." {
+		name = u.Hostname()
+	}
+
+	return name, content, nil
+}
+
+// fetchClient returns the HTTPClient of opts following only redirects to allowed hosts
+func fetchClient(opts Options) *http.Client {
+	var client http.Client
+	if opts.HTTPClient() != nil {
+		client = *opts.HTTPClient()
+	}
+
+	checkRedirect := client.CheckRedirect
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+			return fmt.Errorf("unsupported url scheme %q", req.URL.Scheme)
+		}
+		if !opts.HostAllowed(req.URL.Hostname()) {
+			log.Printf("redirect to %v host not allowed\n", req.URL)
+			return fmt.Errorf("host %v not allowed", req.URL.Hostname())
+		}
+		if checkRedirect != nil {
+			return checkRedirect(req, via)
+		}
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return nil
+	}
+	return &client
+}
@@ -30,20 +30,28 @@ type UploadedFile struct {
 	diskPath string
 	content  []byte
 	options  Options
+
+	iccProfileDropped bool
+	framesTruncated   bool
+	unique            bool // If true, the name is reserved on Save, suffixed if taken
 }
 
 // NewUploadedFile returns a new UploadedFile struct
+// name is normalized by the Slugifier and timestamped; a suffix (-2, -3, ...) is appended
+// on Save if a file of that name exists already
 func NewUploadedFile(name string, opts Options) *UploadedFile {
 	dirPath := path.Join(opts.Dir(), opts.Destination())
-	name = AddTimestamp(name)
+	currentTime := time.Now()
+	dirPath = filepath.Join(dirPath, fmt.Sprintf("%d", currentTime.Year()), fmt.Sprintf("%v", currentTime.Month()))
+	name = slugName(name, opts.Slugifier())
 	urlPath := path.Join(opts.MediaPrefixURL(), opts.Destination(), name)
-	currentTime := time.Now() 
-	diskPath := filepath.Join(dirPath, fmt.Sprintf("%d", currentTime.Year()), fmt.Sprintf("%v", currentTime.Month()), name)
+	diskPath := filepath.Join(dirPath, name)
 
 	return &UploadedFile{
 		url:      urlPath,
 		diskPath: diskPath,
 		options:  opts,
+		unique:   true,
 	}
 }
 
@@ -62,6 +70,21 @@ func (u *UploadedFile) Content() []byte {
 	return u.content
 }
 
+// Name returns the final name of file, as normalized and timestamped
+func (u *UploadedFile) Name() string {
+	return filepath.Base(u.diskPath)
+}
+
+// ICCProfileDropped checks if an oversized ICC profile was removed from the file
+func (u *UploadedFile) ICCProfileDropped() bool {
+	return u.iccProfileDropped
+}
+
+// FramesTruncated checks if frames of an animated upload were dropped
+func (u *UploadedFile) FramesTruncated() bool {
+	return u.framesTruncated
+}
+
 // Save saves file on disk if it does not exist
 func (u *UploadedFile) Save(content []byte, overwrite bool) error {
 	if !overwrite {
@@ -82,7 +105,26 @@ func (u *UploadedFile) Save(content []byte, overwrite bool) error {
 		return err
 	}
 
-	if err := ioutil.WriteFile(u.DiskPath(), content, os.FileMode(0644)); err != nil {
+	if u.unique {
+		// Concurrent uploads of the same name each get their own file
+		file, name, err := reserveName(dir, filepath.Base(u.diskPath))
+		if err != nil {
+			log.Printf("error creating %v: %v\n", u.DiskPath(), err)
+			return err
+		}
+		u.rename(name)
+		u.unique = false
+
+		_, err = file.Write(content)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			log.Printf("error writing %v: %v\n", u.DiskPath(), err)
+			os.Remove(u.DiskPath())
+			return err
+		}
+	} else if err := ioutil.WriteFile(u.DiskPath(), content, os.FileMode(0644)); err != nil {
 		log.Printf("error writing %v: %v\n", u.DiskPath(), err)
 		return err
 	}
@@ -107,20 +149,73 @@ func (u *UploadedFile) ChangeExt(newExt string) error {
 	}
 
 	oldExt := path.Ext(u.DiskPath())
-	newFileDiskPath := strings.TrimSuffix(u.DiskPath(), oldExt) + "." + newExt
-	newFileURLPath := strings.TrimSuffix(u.URLPath(), oldExt) + "." + newExt
+	newName := strings.TrimSuffix(filepath.Base(u.DiskPath()), oldExt) + "." + newExt
+	if newName == filepath.Base(u.DiskPath()) {
+		return nil
+	}
+
+	// Reserve the new name rather than replacing a file of that name
+	dir := filepath.Dir(u.DiskPath())
+	file, newName, err := reserveName(dir, newName)
+	if err != nil {
+		return fmt.Errorf("image ext change to %v failed", newExt)
+	}
+	file.Close()
 
-	if err := os.Rename(u.DiskPath(), newFileDiskPath); err != nil {
+	if err := os.Rename(u.DiskPath(), filepath.Join(dir, newName)); err != nil {
+		os.Remove(filepath.Join(dir, newName))
 		return fmt.Errorf("image ext change to %v failed", newExt)
 	}
 
 	// if everything ok, update paths
-	u.diskPath = newFileDiskPath
-	u.url = newFileURLPath
+	u.rename(newName)
 
 	return nil
 }
 
+// rename updates the disk and url paths of file to name, in the same directory
+func (u *UploadedFile) rename(name string) {
+	u.diskPath = filepath.Join(filepath.Dir(u.diskPath), name)
+	u.url = path.Join(path.Dir(u.url), name)
+}
+
+// slugName returns name normalized by slugify and timestamped like AddTimestamp,
+// its extension lowercased and stripped of anything but letters and digits
+func slugName(name string, slugify func(string) string) string {
+	if slugify == nil {
+		slugify = slug.Make
+	}
+
+	name = filepath.Base(name)
+	oldExt := filepath.Ext(name)
+	ext := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return -1
+	}, strings.ToLower(oldExt))
+	if ext != "" {
+		ext = "." + ext
+	}
+
+	return slugify(strings.TrimSuffix(name, oldExt)) + "_" + time.Now().Format("20060102150405") + ext
+}
+
+// reserveName creates a file in dir exclusively, named name suffixed by -2, -3, ... while
+// a file of that name exists, and returns it open for writing with its name
+func reserveName(dir, name string) (*os.File, string, error) {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	unique := name
+	for n := 2; ; n++ {
+		file, err := os.OpenFile(filepath.Join(dir, unique), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if !os.IsExist(err) {
+			return file, unique, err
+		}
+		unique = fmt.Sprintf("%s-%d%s", base, n, ext)
+	}
+}
+
 // AddTimestamp add timestamp information to a filename
 func AddTimestamp(oldFilename string) string {
 	oldExt := filepath.Ext(oldFilename)
@@ -2,8 +2,14 @@ package upload
 
 import (
 	"io/ioutil"
+	"os"
 	"path"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
 )
 
 type mockUploadedFile struct {
@@ -31,29 +37,120 @@ func NewMockUploadedFile(name string, opts Options) *mockUploadedFile {
 	}
 }
 
-func(m *mockUploadedFile) URLPath() string {
+func (m *mockUploadedFile) URLPath() string {
 	return m.url
 }
 
-func(m *mockUploadedFile) DiskPath() string {
+func (m *mockUploadedFile) DiskPath() string {
 	return m.diskPath
 }
 
-func(m *mockUploadedFile) Content() []byte {
+func (m *mockUploadedFile) Content() []byte {
 	return m.content
 }
 
-func(m *mockUploadedFile) Save(content []byte, overwrite bool) error {
+func (m *mockUploadedFile) Save(content []byte, overwrite bool) error {
 	// Don't need an actual implementation
 	return nil
 }
 
-func(m *mockUploadedFile) Delete() error {
+func (m *mockUploadedFile) Delete() error {
 	// Don't need an actual implementation
 	return nil
 }
 
-func(m *mockUploadedFile) ChangeExt(string) error {
+func (m *mockUploadedFile) ChangeExt(string) error {
 	// Don't need an actual implementation
 	return nil
-}
\ No newline at end of file
+}
+func TestUploadedFileName(t *testing.T) {
+	dir, err := ioutil.TempDir("", "name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name     string
+		opts     []Option
+		expected string
+	}{
+		{"Été à Paris (1).JPG", nil, `^ete-a-paris-1_\d{14}\.jpg$`},
+		{"../../etc/passwd", nil, `^passwd_\d{14}$`},
+		{"photo.j p?g", nil, `^photo_\d{14}\.jpg$`},
+		{"Photo.png", []Option{Slugifier(strings.ToUpper)}, `^PHOTO_\d{14}\.png$`},
+	}
+
+	for _, tt := range tests {
+		file := NewUploadedFile(tt.name, *EvaluateOptions(append(tt.opts, Dir(dir))...))
+		if !regexp.MustCompile(tt.expected).MatchString(file.Name()) {
+			t.Errorf("%v: expected name matching %v, got %v", tt.name, tt.expected, file.Name())
+		}
+		if path.Base(file.URLPath()) != file.Name() {
+			t.Errorf("%v: expected url %v ending with %v", tt.name, file.URLPath(), file.Name())
+		}
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "same.jpg"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]string{"same.jpg": "same-2.jpg", "other.jpg": "other.jpg"} {
+		file, unique, err := reserveName(dir, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+		if unique != expected {
+			t.Errorf("expected %v, got %v", expected, unique)
+		}
+	}
+}
+
+func TestUploadedFileConcurrentNames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := make([]*UploadedFile, 8)
+	var wg sync.WaitGroup
+	for i := range files {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			files[i] = NewUploadedFile("same.txt", *EvaluateOptions(Dir(dir)))
+			if err := files[i].Save([]byte(strconv.Itoa(i)), true); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool)
+	for i, file := range files {
+		if seen[file.DiskPath()] {
+			t.Errorf("%v saved twice", file.DiskPath())
+		}
+		seen[file.DiskPath()] = true
+		if content, _ := ioutil.ReadFile(file.DiskPath()); string(content) != strconv.Itoa(i) {
+			t.Errorf("%v: expected content %d, got %q", file.DiskPath(), i, content)
+		}
+	}
+
+	// Changing the extension does not replace a file of the new name
+	taken := strings.TrimSuffix(files[0].DiskPath(), ".txt") + ".md"
+	if err := ioutil.WriteFile(taken, []byte("taken"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := files[0].ChangeExt("md"); err != nil {
+		t.Fatal(err)
+	}
+	if files[0].DiskPath() == taken || path.Base(files[0].URLPath()) != files[0].Name() {
+		t.Errorf("expected a free name, got %v at %v", files[0].DiskPath(), files[0].URLPath())
+	}
+	if content, _ := ioutil.ReadFile(taken); string(content) != "taken" {
+		t.Errorf("expected %v kept, got %q", taken, content)
+	}
+}
@@ -0,0 +1,53 @@
+package upload
+
+import (
+	"errors"
+	"sync"
+)
+
+// errFlightPanicked is received by the callers waiting on a call that panicked
+var errFlightPanicked = errors.New("call panicked")
+
+// flightGroup deduplicates concurrent calls sharing the same key
+// Callers arriving while a call is in flight wait for and receive its result
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	wg  sync.WaitGroup
+	val string
+	err error
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{calls: make(map[string]*flightCall)}
+}
+
+// do executes fn once per key for all concurrent callers
+// If fn panics, the panic propagates to its caller and the waiting ones get errFlightPanicked
+func (g *flightGroup) do(key string, fn func() (string, error)) (string, error) {
+	g.mu.Lock()
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.val, c.err
+	}
+
+	c := &flightCall{err: errFlightPanicked}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	// Release the key and the waiters even if fn panics
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		c.wg.Done()
+	}()
+
+	c.val, c.err = fn()
+	return c.val, c.err
+}
@@ -0,0 +1,80 @@
+package upload
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFlightGroupDo(t *testing.T) {
+	g := newFlightGroup()
+
+	var (
+		calls int32
+		wg    sync.WaitGroup
+	)
+	release := make(chan struct{})
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			val, err := g.do("key", func() (string, error) {
+				atomic.AddInt32(&calls, 1)
+				<-release
+				return "value", nil
+			})
+			if err != nil || val != "value" {
+				t.Errorf("unexpected result: %v, %v", val, err)
+			}
+		}()
+	}
+
+	// Give goroutines time to join the flight before releasing it
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("expected 1 call, got %d", calls)
+	}
+}
+
+func TestFlightGroupDoPanic(t *testing.T) {
+	g := newFlightGroup()
+	started := make(chan struct{})
+	waited := make(chan error, 1)
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected panic propagated to the caller")
+			}
+		}()
+		g.do("key", func() (string, error) {
+			go func() {
+				close(started)
+				_, err := g.do("key", func() (string, error) { return "other", nil })
+				waited <- err
+			}()
+			<-started
+			// Let the waiter join the call in flight
+			time.Sleep(50 * time.Millisecond)
+			panic("decode")
+		})
+	}()
+
+	select {
+	case err := <-waited:
+		if err != errFlightPanicked {
+			t.Errorf("expected errFlightPanicked, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiter blocked after panic")
+	}
+
+	if val, err := g.do("key", func() (string, error) { return "value", nil }); err != nil || val != "value" {
+		t.Errorf("expected key released after panic, got %v, %v", val, err)
+	}
+}
@@ -0,0 +1,39 @@
+package upload
+
+import (
+	"bytes"
+	"image"
+	"io/fs"
+	"log"
+
+	"github.com/disintegration/imaging"
+)
+
+// ProcessFS adds a job like Process for the image name of fsys, e.g. embedded with go:embed,
+// as if stored at fileDiskPath: the source is decoded from memory and never written, while
+// variants are written through the storage at their paths for fileDiskPath
+func (p *ImageProcessor) ProcessFS(fsys fs.FS, name, fileDiskPath string, validate bool, opts ...OptionJob) (*Job, error) {
+	content, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		log.Printf("error reading %v: %v\n", name, err)
+		return nil, err
+	}
+
+	file := &UploadedFile{diskPath: fileDiskPath, content: content}
+	return p.add(file, validate, -1, append(opts, inMemory)...)
+}
+
+// inMemory decodes the source of a job from its content rather than from disk
+func inMemory(j *Job) {
+	j.inMemory = true
+}
+
+// openSource decodes the source of job, within reserved if not nil
+func (p *ImageProcessor) openSource(job *Job, reserved *reservation) (image.Image, error) {
+	if !job.inMemory {
+		return p.open(job.File.DiskPath(), reserved)
+	}
+	return decodeTimeout(p.options.decodeTimeout, reserved, func() (image.Image, error) {
+		return imaging.Decode(bytes.NewReader(job.File.Content()))
+	})
+}
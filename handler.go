@@ -1,7 +1,10 @@
 package upload
 
 import (
+	"fmt"
+	"log"
 	"net/http"
+	"os"
 	"path"
 	"strings"
 )
@@ -54,6 +57,44 @@ func (s httpImageDirHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, p, http.StatusTemporaryRedirect)
 }
 
+// ServeVariant serves the variant of an image for a specific format, generating it if missing
+// Responses carry the content type of the variant, an ETag and Last-Modified for conditional
+// requests, and honor range requests; callers may set Cache-Control beforehand
+func (p *ImageProcessor) ServeVariant(w http.ResponseWriter, r *http.Request, baseDiskPath string, format Format) {
+	fileDiskPath, err := p.GetOrGenerate(baseDiskPath, format)
+	if err != nil {
+		if os.IsNotExist(err) || err == ErrFormatSkipped {
+			http.NotFound(w, r)
+			return
+		}
+		log.Printf("error serving %v variant %v: %v\n", baseDiskPath, format.name, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	file, err := os.Open(fileDiskPath)
+	if err != nil {
+		log.Printf("error opening %v: %v\n", fileDiskPath, err)
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		log.Printf("error opening %v: %v\n", fileDiskPath, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if contentType := variantMIME(baseDiskPath, format, p.options); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()))
+
+	http.ServeContent(w, r, "", info.ModTime(), file)
+}
+
 //HTTPImageDirHandler serves images from a directory with imagist fallback
 // func HTTPImageDirHandler(router *mux.Router, root http.FileSystem, prefix string, paths map[string]*Options) {
 // 	for path, opts := range paths {
@@ -0,0 +1,71 @@
+package upload
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestServeVariant(t *testing.T) {
+	dir, err := ioutil.TempDir("", "serve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content, err := ioutil.ReadFile(filepath.Join("testdata", "normal.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	baseDiskPath := filepath.Join(dir, "normal.jpg")
+	if err := ioutil.WriteFile(baseDiskPath, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p := NewImageProcessor(Formats("thumb", 100, 100, false))
+	format, _ := p.options.Format("thumb")
+
+	serve := func(baseDiskPath string, header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/normal.jpg:thumb", nil)
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		p.ServeVariant(w, r, baseDiskPath, format)
+		return w
+	}
+
+	w := serve(baseDiskPath, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if contentType := w.Header().Get("Content-Type"); contentType != "image/jpeg" {
+		t.Errorf("expected image/jpeg, got %q", contentType)
+	}
+	if w.Header().Get("Accept-Ranges") != "bytes" {
+		t.Error("expected range support")
+	}
+	variant, err := ioutil.ReadFile(baseDiskPath + ":thumb")
+	if err != nil {
+		t.Fatalf("expected variant generated: %v", err)
+	}
+	etag := w.Header().Get("ETag")
+
+	w = serve(baseDiskPath, http.Header{"Range": {"bytes=0-9"}})
+	if w.Code != http.StatusPartialContent || w.Body.String() != string(variant[:10]) {
+		t.Errorf("expected first 10 bytes, got %d %q", w.Code, w.Body.String())
+	}
+
+	w = serve(baseDiskPath, http.Header{"If-None-Match": {etag}})
+	if w.Code != http.StatusNotModified {
+		t.Errorf("expected 304, got %d", w.Code)
+	}
+
+	w = serve(filepath.Join(dir, "missing.jpg"), nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", w.Code)
+	}
+}
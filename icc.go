@@ -0,0 +1,129 @@
+package upload
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/jpeg"
+	"log"
+)
+
+// iccMarker prefixes APP2 segments holding an ICC profile chunk
+var iccMarker = []byte("ICC_PROFILE\x00")
+
+// jpegSegments calls fn for every marker segment of a JPEG before its image data
+// fn receives the marker and the segment including its header
+// It returns the offset at which the image data starts, or -1 if content is not a valid JPEG
+func jpegSegments(content []byte, fn func(marker byte, segment []byte)) int {
+	if len(content) < 2 || content[0] != 0xFF || content[1] != 0xD8 {
+		return -1
+	}
+
+	offset := 2
+	for offset+4 <= len(content) {
+		if content[offset] != 0xFF {
+			return -1
+		}
+		marker := content[offset+1]
+		// Start of scan or end of image: no more metadata
+		if marker == 0xDA || marker == 0xD9 {
+			return offset
+		}
+
+		length := int(binary.BigEndian.Uint16(content[offset+2:]))
+		end := offset + 2 + length
+		if length < 2 || end > len(content) {
+			return -1
+		}
+
+		fn(marker, content[offset:end])
+		offset = end
+	}
+
+	return -1
+}
+
+// iccProfileSize returns the size of the ICC profile embedded in a JPEG
+func iccProfileSize(content []byte) int {
+	size := 0
+	jpegSegments(content, func(marker byte, segment []byte) {
+		if marker == 0xE2 && bytes.HasPrefix(segment[4:], iccMarker) {
+			// Skip header, marker and chunk sequence number and count
+			size += len(segment) - 4 - len(iccMarker) - 2
+		}
+	})
+	return size
+}
+
+// stripICCProfile returns a JPEG without its embedded ICC profile
+func stripICCProfile(content []byte) []byte {
+	stripped := make([]byte, 0, len(content))
+	stripped = append(stripped, content[:2]...)
+
+	start := jpegSegments(content, func(marker byte, segment []byte) {
+		if marker == 0xE2 && bytes.HasPrefix(segment[4:], iccMarker) {
+			return
+		}
+		stripped = append(stripped, segment...)
+	})
+	if start < 0 {
+		return content
+	}
+
+	return append(stripped, content[start:]...)
+}
+
+// dropICCProfile returns a JPEG without its embedded ICC profile
+// Pixels of wide-gamut RGB profiles are converted to sRGB and re-encoded, keeping the
+// APPn and comment segments (e.g. EXIF orientation); other profiles are stripped as is
+func dropICCProfile(content []byte) ([]byte, error) {
+	stripped := stripICCProfile(content)
+
+	t, err := newICCTransform(iccProfile(content))
+	if err != nil {
+		log.Printf("Image icc profile error: %v\n", err)
+		return stripped, nil
+	}
+	if t == nil {
+		return stripped, nil
+	}
+
+	img, err := jpeg.Decode(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, t.apply(img), &jpeg.Options{Quality: 95}); err != nil {
+		return nil, err
+	}
+
+	converted := append([]byte{}, content[:2]...)
+	jpegSegments(stripped, func(marker byte, segment []byte) {
+		if (marker >= 0xE0 && marker <= 0xEF) || marker == 0xFE {
+			converted = append(converted, segment...)
+		}
+	})
+	return append(converted, encoded.Bytes()[2:]...), nil
+}
+
+// iccProfile returns the ICC profile embedded in a JPEG, reassembled from its chunks
+func iccProfile(content []byte) []byte {
+	chunks := map[byte][]byte{}
+	jpegSegments(content, func(marker byte, segment []byte) {
+		if marker != 0xE2 || !bytes.HasPrefix(segment[4:], iccMarker) || len(segment) < 4+len(iccMarker)+2 {
+			return
+		}
+		// Chunks are numbered from 1
+		seq := segment[4+len(iccMarker)]
+		chunks[seq] = segment[4+len(iccMarker)+2:]
+	})
+
+	var profile []byte
+	for seq := byte(1); ; seq++ {
+		chunk, ok := chunks[seq]
+		if !ok {
+			break
+		}
+		profile = append(profile, chunk...)
+	}
+	return profile
+}
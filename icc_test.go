@@ -0,0 +1,139 @@
+package upload
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestStripICCProfile(t *testing.T) {
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+	plain := encoded.Bytes()
+
+	// Insert a 100 bytes ICC profile chunk after SOI
+	profile := make([]byte, 100)
+	segment := append(append([]byte{0xFF, 0xE2, 0, 0}, iccMarker...), 1, 1)
+	segment = append(segment, profile...)
+	segment[2], segment[3] = byte((len(segment)-2)>>8), byte(len(segment)-2)
+	content := append(append(append([]byte{}, plain[:2]...), segment...), plain[2:]...)
+
+	if size := iccProfileSize(content); size != 100 {
+		t.Errorf("expected profile size 100, got %d", size)
+	}
+
+	stripped := stripICCProfile(content)
+	if !bytes.Equal(stripped, plain) {
+		t.Errorf("stripped content differs from content without profile")
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(stripped)); err != nil {
+		t.Errorf("stripped content not decodable: %v", err)
+	}
+}
+
+// withSegments returns the JPEG plain with segments inserted after SOI
+func withSegments(plain []byte, segments ...[]byte) []byte {
+	content := append([]byte{}, plain[:2]...)
+	for _, segment := range segments {
+		content = append(content, segment...)
+	}
+	return append(content, plain[2:]...)
+}
+
+// iccSegment returns the APP2 segment embedding profile as a single chunk
+func iccSegment(profile []byte) []byte {
+	segment := append(append([]byte{0xFF, 0xE2, 0, 0}, iccMarker...), 1, 1)
+	segment = append(segment, profile...)
+	segment[2], segment[3] = byte((len(segment)-2)>>8), byte(len(segment)-2)
+	return segment
+}
+
+// greenJPEG returns an 8x8 JPEG of a mid green
+func greenJPEG(t *testing.T) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.NRGBA{100, 200, 100, 255}), image.Point{}, draw.Src)
+
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+	return encoded.Bytes()
+}
+
+var adobeRGBColorants = [3][3]float64{
+	{0.6097559, 0.3111242, 0.0194811},
+	{0.2052401, 0.6256560, 0.0608902},
+	{0.1492240, 0.0632197, 0.7448387},
+}
+
+func TestDropICCProfile(t *testing.T) {
+	plain := greenJPEG(t)
+	exif := append([]byte{0xFF, 0xE1, 0, 10}, "Exif\x00\x00\x00\x00"...)
+	content := withSegments(plain, exif, iccSegment(buildICCProfile(adobeRGBColorants, 2.2)))
+
+	dropped, err := dropICCProfile(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := iccProfileSize(dropped); size != 0 {
+		t.Errorf("expected profile dropped, got %d bytes", size)
+	}
+	if !bytes.Contains(dropped, exif) {
+		t.Error("expected EXIF segment kept")
+	}
+
+	img, err := jpeg.Decode(bytes.NewReader(dropped))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Adobe RGB greens are more saturated than sRGB ones
+	r, g, _, _ := img.At(4, 4).RGBA()
+	if r>>8 >= 90 || g>>8 < 200 {
+		t.Errorf("expected colors converted to sRGB, got %v", img.At(4, 4))
+	}
+
+	// Profiles that cannot be converted are only stripped
+	content = withSegments(plain, iccSegment(make([]byte, 100)))
+	if dropped, err := dropICCProfile(content); err != nil || !bytes.Equal(dropped, plain) {
+		t.Errorf("expected unsupported profile stripped: %v", err)
+	}
+}
+
+func TestUploadICCProfileDropped(t *testing.T) {
+	dir, err := ioutil.TempDir("", "icc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	profile := buildICCProfile(adobeRGBColorants, 2.2)
+	var (
+		reported *UploadedFile
+		size     int
+	)
+	uploader := NewImageUploader(EvaluateOptions(
+		Dir(dir),
+		MaxICCSize(len(profile)-1),
+		OnICCProfileDropped(func(file *UploadedFile, n int) {
+			reported, size = file, n
+		}),
+	))
+
+	uploaded, err := uploader.Upload("wide.jpg", withSegments(greenJPEG(t), iccSegment(profile)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !uploaded.ICCProfileDropped() || reported != uploaded || size != len(profile) {
+		t.Errorf("expected drop of %d bytes reported, got %v and %d", len(profile), reported, size)
+	}
+	if n := iccProfileSize(uploaded.Content()); n != 0 {
+		t.Errorf("expected profile dropped, got %d bytes", n)
+	}
+}
@@ -0,0 +1,103 @@
+package upload
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	// icoMaxSize is the largest image size an ICO file can hold
+	icoMaxSize = 256
+)
+
+var (
+	// defaultICOSizes are the sizes packed in a favicon
+	defaultICOSizes = []int{16, 32, 48}
+)
+
+// EncodeICO writes an ICO file packing img resized to every size, as PNG entries
+// Images are cropped square around their center; sizes range from 1 to 256
+func EncodeICO(w io.Writer, img image.Image, filter imaging.ResampleFilter, sizes ...int) error {
+	if len(sizes) == 0 {
+		sizes = defaultICOSizes
+	}
+
+	entries := make([][]byte, len(sizes))
+	for i, size := range sizes {
+		if size < 1 || size > icoMaxSize {
+			return fmt.Errorf("ico size %d out of range 1-%d", size, icoMaxSize)
+		}
+
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, imaging.Fill(img, size, size, imaging.Center, filter)); err != nil {
+			return err
+		}
+		entries[i] = buf.Bytes()
+	}
+
+	// ICONDIR header: reserved, type 1 (icon), count
+	header := make([]byte, 6+16*len(sizes))
+	binary.LittleEndian.PutUint16(header[2:], 1)
+	binary.LittleEndian.PutUint16(header[4:], uint16(len(sizes)))
+
+	offset := len(header)
+	for i, size := range sizes {
+		entry := header[6+16*i:]
+		// 256 is stored as 0
+		entry[0] = byte(size % icoMaxSize)
+		entry[1] = byte(size % icoMaxSize)
+		binary.LittleEndian.PutUint16(entry[4:], 1)  // Color planes
+		binary.LittleEndian.PutUint16(entry[6:], 32) // Bits per pixel
+		binary.LittleEndian.PutUint32(entry[8:], uint32(len(entries[i])))
+		binary.LittleEndian.PutUint32(entry[12:], uint32(offset))
+		offset += len(entries[i])
+	}
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if _, err := w.Write(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GenerateICO writes an ICO file of the image at baseDiskPath packing several sizes
+// (default: 16, 32 and 48) and returns its key, the image path with an .ico extension
+func (p *ImageProcessor) GenerateICO(baseDiskPath string, sizes ...int) (string, error) {
+	src, err := p.open(baseDiskPath, nil)
+	if err != nil {
+		log.Printf("Image error: %v\n", err)
+		return "", err
+	}
+
+	icoDiskPath := strings.TrimSuffix(baseDiskPath, filepath.Ext(baseDiskPath)) + ".ico"
+	w, err := p.options.storage.Create(icoDiskPath)
+	if err != nil {
+		log.Printf("Image ico error: %v\n", err)
+		return "", err
+	}
+
+	if err := EncodeICO(w, toRGB(src), p.options.filter, sizes...); err != nil {
+		log.Printf("Image ico error: %v\n", err)
+		w.Abort()
+		return "", err
+	}
+
+	if err := w.Close(); err != nil {
+		log.Printf("Image ico error: %v\n", err)
+		return "", err
+	}
+	return icoDiskPath, nil
+}
@@ -0,0 +1,48 @@
+package upload
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/png"
+	"path/filepath"
+	"testing"
+)
+
+func TestGenerateICO(t *testing.T) {
+	storage := newMemStorage()
+	p := NewImageProcessor(WithStorage(storage))
+
+	icoDiskPath, err := p.GenerateICO(filepath.Join("testdata", "normal.jpg"), 16, 48, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if icoDiskPath != filepath.Join("testdata", "normal.ico") {
+		t.Errorf("unexpected ico path %v", icoDiskPath)
+	}
+
+	ico := storage.files[icoDiskPath].Bytes()
+	if count := binary.LittleEndian.Uint16(ico[4:]); count != 3 {
+		t.Fatalf("expected 3 entries, got %d", count)
+	}
+
+	for i, size := range []int{16, 48, 256} {
+		entry := ico[6+16*i:]
+		if int(entry[0]) != size%256 {
+			t.Errorf("entry %d: expected size byte %d, got %d", i, size%256, entry[0])
+		}
+
+		length := binary.LittleEndian.Uint32(entry[8:])
+		offset := binary.LittleEndian.Uint32(entry[12:])
+		img, err := png.Decode(bytes.NewReader(ico[offset : offset+length]))
+		if err != nil {
+			t.Fatalf("entry %d: %v", i, err)
+		}
+		if img.Bounds().Dx() != size || img.Bounds().Dy() != size {
+			t.Errorf("entry %d: expected %dx%d, got %v", i, size, size, img.Bounds())
+		}
+	}
+
+	if _, err := p.GenerateICO(filepath.Join("testdata", "normal.jpg"), 512); err == nil {
+		t.Error("expected error for size over 256")
+	}
+}
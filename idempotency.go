@@ -0,0 +1,45 @@
+package upload
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrAlreadyProcessed is returned when a job of the same idempotency key completed before
+var ErrAlreadyProcessed = errors.New("already processed")
+
+// IdempotencyStore records the idempotency keys of completed jobs
+// Back it with a persistent store (e.g. Redis, a database) to survive restarts
+type IdempotencyStore interface {
+	// Has checks if key was recorded
+	Has(key string) (bool, error)
+	// Set records key
+	Set(key string) error
+}
+
+// MemoryIdempotencyStore implements the IdempotencyStore interface in memory
+type MemoryIdempotencyStore struct {
+	mu   sync.Mutex
+	keys map[string]struct{}
+}
+
+// NewMemoryIdempotencyStore returns a new MemoryIdempotencyStore
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{keys: make(map[string]struct{})}
+}
+
+// Has checks if key was recorded
+func (s *MemoryIdempotencyStore) Has(key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.keys[key]
+	return ok, nil
+}
+
+// Set records key
+func (s *MemoryIdempotencyStore) Set(key string) error {
+	s.mu.Lock()
+	s.keys[key] = struct{}{}
+	s.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,96 @@
+package upload
+
+import (
+	"sync"
+	"time"
+)
+
+// Lanes of waiters, served in order
+const (
+	laneHigh = iota
+	laneNormal
+	laneCount
+)
+
+// laneSemaphore bounds holders, waiters of the high lane being served before the normal one
+type laneSemaphore struct {
+	mu      sync.Mutex
+	size    int
+	held    int
+	waiting [laneCount][]chan struct{}
+}
+
+func newLaneSemaphore(n int) *laneSemaphore {
+	if n < 1 {
+		n = 1
+	}
+	return &laneSemaphore{size: n}
+}
+
+// acquire waits for a free place in lane, at most timeout if not negative
+func (s *laneSemaphore) acquire(lane int, timeout time.Duration) bool {
+	s.mu.Lock()
+	if s.held < s.size {
+		s.held++
+		s.mu.Unlock()
+		return true
+	}
+
+	ready := make(chan struct{})
+	s.waiting[lane] = append(s.waiting[lane], ready)
+	s.mu.Unlock()
+
+	if timeout < 0 {
+		<-ready
+		return true
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-ready:
+		return true
+	case <-timer.C:
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, waiter := range s.waiting[lane] {
+		if waiter == ready {
+			s.waiting[lane] = append(s.waiting[lane][:i], s.waiting[lane][i+1:]...)
+			return false
+		}
+	}
+	// Handed a place while timing out
+	return true
+}
+
+// release frees a place, handing it to the next waiter if any
+func (s *laneSemaphore) release() {
+	s.mu.Lock()
+	s.held--
+	s.wake()
+	s.mu.Unlock()
+}
+
+// resize changes the number of places, taking effect as places free up
+func (s *laneSemaphore) resize(n int) {
+	if n < 1 {
+		n = 1
+	}
+	s.mu.Lock()
+	s.size = n
+	s.wake()
+	s.mu.Unlock()
+}
+
+// wake hands free places to waiters, high lane first
+func (s *laneSemaphore) wake() {
+	for lane := range s.waiting {
+		for s.held < s.size && len(s.waiting[lane]) > 0 {
+			close(s.waiting[lane][0])
+			s.waiting[lane] = s.waiting[lane][1:]
+			s.held++
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package upload
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLaneSemaphore(t *testing.T) {
+	sem := newLaneSemaphore(1)
+	if !sem.acquire(laneNormal, 0) {
+		t.Fatal("expected a free place")
+	}
+
+	// Timed out waiters leave the queue
+	if sem.acquire(laneNormal, 10*time.Millisecond) {
+		t.Fatal("expected timeout while held")
+	}
+
+	order := make(chan int, 2)
+	go func() {
+		sem.acquire(laneNormal, -1)
+		order <- laneNormal
+		sem.release()
+	}()
+	time.Sleep(10 * time.Millisecond)
+	go func() {
+		sem.acquire(laneHigh, -1)
+		order <- laneHigh
+		sem.release()
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	// The high lane is served first though it waited less
+	sem.release()
+	if first, second := <-order, <-order; first != laneHigh || second != laneNormal {
+		t.Errorf("expected high lane first, got %d then %d", first, second)
+	}
+
+	if !sem.acquire(laneNormal, time.Second) {
+		t.Error("expected places released")
+	}
+}
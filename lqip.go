@@ -0,0 +1,45 @@
+package upload
+
+import (
+	"encoding/base64"
+	"fmt"
+	"image"
+	"log"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	// lqipQuality is the JPEG quality of low-quality image placeholders
+	lqipQuality = 30
+)
+
+// GenerateLQIP returns a low-quality image placeholder of the image at path
+// as a base64 JPEG data URI, downscaled to fit within maxDim x maxDim
+func GenerateLQIP(path string, maxDim int) (string, error) {
+	if maxDim <= 0 {
+		return "", fmt.Errorf("lqip dimension must be positive")
+	}
+
+	img, err := imaging.Open(path)
+	if err != nil {
+		log.Printf("Image error: %v\n", err)
+		return "", err
+	}
+
+	return lqipFromImage(img, maxDim)
+}
+
+// lqipFromImage returns a low-quality image placeholder of an already decoded image
+func lqipFromImage(img image.Image, maxDim int) (string, error) {
+	img = imaging.Fit(img, maxDim, maxDim, imaging.Lanczos)
+
+	buf := _encodeBuffers.get()
+	defer _encodeBuffers.put(buf)
+	if err := imaging.Encode(buf, img, imaging.JPEG, imaging.JPEGQuality(lqipQuality)); err != nil {
+		log.Printf("Image encode lqip error: %v", err)
+		return "", err
+	}
+
+	return "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
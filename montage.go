@@ -0,0 +1,100 @@
+package upload
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"github.com/gosimple/slug"
+)
+
+// Montage lays out thumbnails of the images at paths in a grid of cols columns
+// of cellW x cellH cells. Cells left over on the last row are blank and a
+// grid with fewer images than columns is narrowed to the number of images.
+func Montage(paths []string, cols int, cellW, cellH int) (image.Image, error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no image to montage")
+	}
+	if cols <= 0 || cellW <= 0 || cellH <= 0 {
+		return nil, fmt.Errorf("montage columns and cell size must be positive")
+	}
+
+	if len(paths) < cols {
+		cols = len(paths)
+	}
+	rows := (len(paths) + cols - 1) / cols
+
+	montage := imaging.New(cols*cellW, rows*cellH, color.NRGBA{255, 255, 255, 255})
+	for i, path := range paths {
+		img, err := imaging.Open(path)
+		if err != nil {
+			log.Printf("Image error: %v\n", err)
+			return nil, err
+		}
+
+		thumb := fill(toRGB(img), cellW, cellH, imaging.Lanczos, true)
+		pos := image.Pt((i%cols)*cellW, (i/cols)*cellH)
+		montage = imaging.Paste(montage, thumb, pos)
+	}
+
+	return montage, nil
+}
+
+// Spritesheet packs the images at paths, scaled down to fit cellW x cellH cells, in a
+// transparent grid as square as possible, and returns the sheet (its bounds being the sheet
+// dimensions) with the region of each image by path, e.g. for CSS sprites.
+// Images keep their aspect ratio and sit at the top left of their cell; regions are exact.
+func Spritesheet(paths []string, cellW, cellH int) (image.Image, map[string]image.Rectangle, error) {
+	if len(paths) == 0 {
+		return nil, nil, fmt.Errorf("no image to pack")
+	}
+	if cellW <= 0 || cellH <= 0 {
+		return nil, nil, fmt.Errorf("sprite cell size must be positive")
+	}
+
+	// The squarest grid wastes at most one row of cells
+	cols := int(math.Ceil(math.Sqrt(float64(len(paths)))))
+	rows := (len(paths) + cols - 1) / cols
+
+	sheet := imaging.New(cols*cellW, rows*cellH, color.NRGBA{0, 0, 0, 0})
+	regions := make(map[string]image.Rectangle, len(paths))
+	for i, path := range paths {
+		img, err := imaging.Open(path)
+		if err != nil {
+			log.Printf("Image error: %v\n", err)
+			return nil, nil, err
+		}
+
+		sprite := imaging.Fit(img, cellW, cellH, imaging.Lanczos)
+		pos := image.Pt((i%cols)*cellW, (i/cols)*cellH)
+		sheet = imaging.Paste(sheet, sprite, pos)
+		regions[path] = image.Rectangle{Min: pos, Max: pos.Add(sprite.Bounds().Size())}
+	}
+
+	return sheet, regions, nil
+}
+
+// SpritesheetCSS returns CSS rules showing each region of a spritesheet served at url,
+// one class per image named prefix followed by the slug of its file name
+func SpritesheetCSS(regions map[string]image.Rectangle, url, prefix string) string {
+	paths := make([]string, 0, len(regions))
+	for path := range regions {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var b strings.Builder
+	for _, path := range paths {
+		region := regions[path]
+		name := slug.Make(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))
+		fmt.Fprintf(&b, ".%s%s{background:url(%q) %dpx %dpx;width:%dpx;height:%dpx}\n",
+			prefix, name, url, -region.Min.X, -region.Min.Y, region.Dx(), region.Dy())
+	}
+	return b.String()
+}
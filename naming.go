@@ -0,0 +1,92 @@
+package upload
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"path/filepath"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	// defaultConvertedNaming names variants encoded in another format than their source
+	defaultConvertedNaming = "{base}_{format}.{ext}"
+)
+
+// converted checks if the variant of format is encoded in another format than the image at imgDiskPath
+func (o OptionsImage) converted(imgDiskPath string, format Format) bool {
+	if format.output == "" {
+		return false
+	}
+	return normalizeExt(format.output) != normalizeExt(filepath.Ext(imgDiskPath))
+}
+
+// variantPath returns the disk path of the variant of an image for a specific format
+// Converted variants are named after ConvertedNaming, e.g. name_card.png, and variants
+// are moved down the ShardDir of the image with ShardLevels, e.g. ab/cd/name.jpg:thumb
+func (o OptionsImage) variantPath(imgDiskPath string, format Format) string {
+	fileDiskPath := o.variantName(imgDiskPath, format)
+	if o.shardLevels <= 0 {
+		return fileDiskPath
+	}
+	dir, name := filepath.Split(fileDiskPath)
+	return filepath.Join(dir, ShardDir(filepath.Base(imgDiskPath), o.shardLevels), name)
+}
+
+// variantName returns the disk path of the variant of an image for a specific format, unsharded
+func (o OptionsImage) variantName(imgDiskPath string, format Format) string {
+	if !o.converted(imgDiskPath, format) {
+		return variantPath(imgDiskPath, format)
+	}
+
+	ext := filepath.Ext(imgDiskPath)
+	base := strings.TrimSuffix(imgDiskPath, ext)
+	dir, name := filepath.Split(base)
+	replacer := strings.NewReplacer(
+		"{base}", name,
+		"{format}", format.name,
+		"{ext}", strings.TrimPrefix(format.output, "."),
+		"{srcext}", strings.TrimPrefix(ext, "."),
+	)
+	return dir + replacer.Replace(o.convertedNaming)
+}
+
+// previewPath returns the disk path of the preview of the variant at fileDiskPath
+func previewPath(fileDiskPath string) string {
+	return fileDiskPath + ":preview"
+}
+
+// ShardDir returns the subdirectory variants of the image file name are written to with
+// ShardLevels: one directory per level named after the next two hex digits of the SHA-1
+// of name, e.g. ab/cd for 2 levels. All variants of an image share its directory
+func ShardDir(name string, levels int) string {
+	sum := sha1.Sum([]byte(name))
+	digest := hex.EncodeToString(sum[:])
+	if levels > len(sum) {
+		levels = len(sum)
+	}
+
+	parts := make([]string, 0, levels)
+	for i := 0; i < levels; i++ {
+		parts = append(parts, digest[2*i:2*i+2])
+	}
+	return filepath.Join(parts...)
+}
+
+// outputFormat returns the format the variant of format is encoded in
+func (o OptionsImage) outputFormat(imgDiskPath string, format Format) (imaging.Format, error) {
+	if format.output != "" {
+		return imaging.FormatFromExtension(strings.TrimPrefix(format.output, "."))
+	}
+	return imaging.FormatFromFilename(imgDiskPath)
+}
+
+// normalizeExt returns ext lowercased without its dot, jpeg being jpg
+func normalizeExt(ext string) string {
+	ext = strings.ToLower(strings.TrimPrefix(ext, "."))
+	if ext == "jpeg" {
+		return "jpg"
+	}
+	return ext
+}
@@ -0,0 +1,63 @@
+package upload
+
+import (
+	"testing"
+)
+
+func TestVariantPathConverted(t *testing.T) {
+	options := EvaluateImageOptions(
+		Formats("thumb", 200, 200, false),
+		Formats("card", 400, 300, false),
+		Formats("same", 400, 300, false),
+		FormatOptions("card", OutputFormat("png")),
+		FormatOptions("same", OutputFormat("jpeg")),
+	)
+
+	tests := []struct {
+		format   string
+		naming   string
+		expected string
+	}{
+		{"thumb", defaultConvertedNaming, "/media/name.jpg:thumb"},
+		{"card", defaultConvertedNaming, "/media/name_card.png"},
+		{"card", "{base}.{srcext}.{format}.{ext}", "/media/name.jpg.card.png"},
+		{"same", defaultConvertedNaming, "/media/name.jpg:same"},
+	}
+
+	for _, tt := range tests {
+		options.convertedNaming = tt.naming
+		format, _ := options.Format(tt.format)
+		if got := options.variantPath("/media/name.jpg", format); got != tt.expected {
+			t.Errorf("%v: expected %v, got %v", tt.format, tt.expected, got)
+		}
+	}
+}
+
+func TestVariantPathSharded(t *testing.T) {
+	options := EvaluateImageOptions(
+		Formats("thumb", 200, 200, false),
+		Formats("card", 400, 300, false),
+		FormatOptions("card", OutputFormat("png")),
+		ShardLevels(2),
+	)
+
+	// sha1("name.jpg") starts with dea3
+	if got := ShardDir("name.jpg", 2); got != "de/a3" {
+		t.Fatalf("expected shard dir de/a3, got %v", got)
+	}
+
+	tests := []struct {
+		format   string
+		expected string
+	}{
+		{"thumb", "/media/de/a3/name.jpg:thumb"},
+		{"card", "/media/de/a3/name_card.png"},
+	}
+
+	for _, tt := range tests {
+		format, _ := options.Format(tt.format)
+		if got := options.variantPath("/media/name.jpg", format); got != tt.expected {
+			t.Errorf("%v: expected %v, got %v", tt.format, tt.expected, got)
+		}
+	}
+}
@@ -1,8 +1,12 @@
 package upload
 
 import (
-	"github.com/lsldigital/gocipe-upload/core"
+	"net/http"
+	"time"
+
+	"github.com/gosimple/slug"
 	"github.com/h2non/filetype/types"
+	"github.com/lsldigital/gocipe-upload/core"
 )
 
 var (
@@ -10,7 +14,11 @@ var (
 		dir:            "media",
 		mediaPrefixURL: "/media/",
 		maxSize:        core.NoLimit,
-		convertTo: 		make(map[types.Type]types.Type),
+		convertTo:      make(map[types.Type]types.Type),
+		httpClient:     &http.Client{Timeout: 30 * time.Second},
+		maxICCSize:     core.NoLimit,
+		maxFrames:      core.NoLimit,
+		slugify:        slug.Make,
 	}
 )
 
@@ -22,40 +30,100 @@ type Options struct {
 	fileType       []types.Type
 	maxSize        int
 	convertTo      map[types.Type]types.Type
+	httpClient     *http.Client
+	allowedHosts   []string
+	maxICCSize     int
+	onICCDropped   func(file *UploadedFile, size int)
+	maxFrames      int
+	maxDuration    time.Duration
+	loopCount      *int
+	slugify        func(string) string
 }
 
 // Dir returns Dir
-func(o Options) Dir() string {
+func (o Options) Dir() string {
 	return o.dir
 }
 
 // Destination returns Destination
-func(o Options) Destination() string {
+func (o Options) Destination() string {
 	return o.destination
 }
 
 // MediaPrefixURL returns MediaPrefixURL
-func(o Options) MediaPrefixURL() string {
+func (o Options) MediaPrefixURL() string {
 	return o.mediaPrefixURL
 }
 
 // FileType returns FileType
-func(o Options) FileType() []types.Type {
+func (o Options) FileType() []types.Type {
 	return o.fileType
 }
 
 // MaxSize returns MaxSize
-func(o Options) MaxSize() int {
+func (o Options) MaxSize() int {
 	return o.maxSize
 }
 
 // ConvertTo returns ConvertTo
-func(o Options) ConvertTo(t types.Type) types.Type {
+func (o Options) ConvertTo(t types.Type) types.Type {
 	return o.convertTo[t]
 }
 
+// HTTPClient returns HTTPClient
+func (o Options) HTTPClient() *http.Client {
+	return o.httpClient
+}
+
+// AllowedHosts returns AllowedHosts
+func (o Options) AllowedHosts() []string {
+	return o.allowedHosts
+}
+
+// HostAllowed checks if host may be fetched from
+// An empty allowlist allows no host
+func (o Options) HostAllowed(host string) bool {
+	for _, allowed := range o.allowedHosts {
+		if allowed == host {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MaxICCSize returns MaxICCSize
+func (o Options) MaxICCSize() int {
+	return o.maxICCSize
+}
+
+// OnICCProfileDropped returns OnICCProfileDropped
+func (o Options) OnICCProfileDropped() func(file *UploadedFile, size int) {
+	return o.onICCDropped
+}
+
+// MaxFrames returns MaxFrames
+func (o Options) MaxFrames() int {
+	return o.maxFrames
+}
+
+// MaxTotalDuration returns MaxTotalDuration
+func (o Options) MaxTotalDuration() time.Duration {
+	return o.maxDuration
+}
+
+// LoopCount returns LoopCount, nil if the source loop count is preserved
+func (o Options) LoopCount() *int {
+	return o.loopCount
+}
+
+// Slugifier returns Slugifier
+func (o Options) Slugifier() func(string) string {
+	return o.slugify
+}
+
 // FileTypeExist checks if filetype exists
-func(o Options) FileTypeExist(t types.Type) bool {
+func (o Options) FileTypeExist(t types.Type) bool {
 	for _, fileType := range o.fileType {
 		if fileType == t {
 			return true
@@ -120,4 +188,84 @@ func ConvertTo(oldType, newType types.Type) Option {
 	return func(o *Options) {
 		o.convertTo[oldType] = newType
 	}
-}
\ No newline at end of file
+}
+
+// HTTPClient returns a function to change HTTPClient
+// A nil client fetches with the defaults of http.Client, without timeout
+func HTTPClient(c *http.Client) Option {
+	return func(o *Options) {
+		o.httpClient = c
+	}
+}
+
+// HTTPTimeout returns a function to change the timeout of HTTPClient
+func HTTPTimeout(d time.Duration) Option {
+	return func(o *Options) {
+		var client http.Client
+		if o.httpClient != nil {
+			client = *o.httpClient
+		}
+		client.Timeout = d
+		o.httpClient = &client
+	}
+}
+
+// AllowedHosts returns a function to add AllowedHosts
+// Remote files are only fetched from, and redirected to, these hosts: with none set,
+// every URL is rejected (default: none)
+func AllowedHosts(hosts ...string) Option {
+	return func(o *Options) {
+		o.allowedHosts = append(o.allowedHosts, hosts...)
+	}
+}
+
+// MaxICCSize returns a function to change MaxICCSize
+// JPEG uploads embedding an ICC profile larger than s bytes have it removed, their pixels
+// converted to sRGB first if the profile is a wide-gamut RGB one
+func MaxICCSize(s int) Option {
+	return func(o *Options) {
+		o.maxICCSize = s
+	}
+}
+
+// OnICCProfileDropped returns a function to change OnICCProfileDropped
+// f is called with the uploaded file and the size of its profile once saved without it
+func OnICCProfileDropped(f func(file *UploadedFile, size int)) Option {
+	return func(o *Options) {
+		o.onICCDropped = f
+	}
+}
+
+// MaxFrames returns a function to change MaxFrames
+// Frames of animated GIF uploads beyond n are dropped
+func MaxFrames(n int) Option {
+	return func(o *Options) {
+		o.maxFrames = n
+	}
+}
+
+// MaxTotalDuration returns a function to change MaxTotalDuration
+// Frames of animated GIF uploads starting after d are dropped (default: 0, no limit)
+func MaxTotalDuration(d time.Duration) Option {
+	return func(o *Options) {
+		o.maxDuration = d
+	}
+}
+
+// Slugifier returns a function to change Slugifier
+// Names of uploaded files, without extension, are normalized by f before saving
+// (default: slug.Make, lowercase ASCII with unsafe characters replaced by -)
+func Slugifier(f func(name string) string) Option {
+	return func(o *Options) {
+		o.slugify = f
+	}
+}
+
+// LoopCount returns a function to change LoopCount
+// Animated GIF uploads loop n times after playing once, 0 looping forever and -1 playing
+// once, e.g. for banner ads limited in loops (default: source loop count preserved)
+func LoopCount(n int) Option {
+	return func(o *Options) {
+		o.loopCount = &n
+	}
+}
@@ -0,0 +1,53 @@
+package upload
+
+var (
+	defaultBatchOptions = &OptionsBatch{
+		concurrency: 1,
+	}
+)
+
+// OptionsBatch holds options of batch processing
+type OptionsBatch struct {
+	concurrency int  // (default: 1) Number of files processed concurrently
+	failFast    bool // (default: false) If true, the first error aborts the files not started yet
+}
+
+// EvaluateBatchOptions returns OptionsBatch
+func EvaluateBatchOptions(opts ...OptionBatch) *OptionsBatch {
+	optCopy := &OptionsBatch{}
+	*optCopy = *defaultBatchOptions
+	for _, o := range opts {
+		o(optCopy)
+	}
+	return optCopy
+}
+
+// Concurrency returns Concurrency option batch
+func (o OptionsBatch) Concurrency() int {
+	return o.concurrency
+}
+
+// FailFast returns FailFast option batch
+func (o OptionsBatch) FailFast() bool {
+	return o.failFast
+}
+
+// OptionBatch is a function to modify batch options
+type OptionBatch func(*OptionsBatch)
+
+// BatchConcurrency returns OptionBatch to modify Concurrency
+func BatchConcurrency(n int) OptionBatch {
+	return func(o *OptionsBatch) {
+		if n < 1 {
+			n = 1
+		}
+		o.concurrency = n
+	}
+}
+
+// BatchFailFast returns OptionBatch to modify FailFast
+func BatchFailFast(b bool) OptionBatch {
+	return func(o *OptionsBatch) {
+		o.failFast = b
+	}
+}
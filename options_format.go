@@ -0,0 +1,184 @@
+package upload
+
+import (
+	"image/color"
+)
+
+// OptionFormat is a function to modify a Format
+type OptionFormat func(*Format)
+
+// namedFormatOptions holds format options to apply to formats of a given name
+type namedFormatOptions struct {
+	name string
+	opts []OptionFormat
+}
+
+// FormatOptions returns a function to modify the formats named name
+// Options are applied once all image options are evaluated, regardless of order
+func FormatOptions(name string, opts ...OptionFormat) OptionImage {
+	return func(o *OptionsImage) {
+		o.formatOpts = append(o.formatOpts, namedFormatOptions{name: name, opts: opts})
+	}
+}
+
+// applyFormatOptions applies pending format options to matching formats
+func (o *OptionsImage) applyFormatOptions() {
+	if len(o.formatOpts) == 0 {
+		return
+	}
+
+	formats := make([]Format, len(o.formats))
+	copy(formats, o.formats)
+	for _, named := range o.formatOpts {
+		for i := range formats {
+			if formats[i].name != named.name {
+				continue
+			}
+			for _, opt := range named.opts {
+				opt(&formats[i])
+			}
+		}
+	}
+	o.formats = formats
+	o.formatOpts = nil
+}
+
+// applyWatermarkFormats gives the WatermarkFormats watermark to the named formats without one
+func (o *OptionsImage) applyWatermarkFormats() {
+	if len(o.watermarkFormats) == 0 {
+		return
+	}
+
+	formats := make([]Format, len(o.formats))
+	copy(formats, o.formats)
+	for i := range formats {
+		if formats[i].watermark != nil {
+			continue
+		}
+		for _, name := range o.watermarkFormats {
+			if formats[i].name == name {
+				// Copied, pipelines normalizing the watermark of their format
+				watermark := *o.watermark
+				formats[i].watermark = &watermark
+				break
+			}
+		}
+	}
+	o.formats = formats
+}
+
+// SkipIfSmaller returns OptionFormat to modify SkipIfSmaller
+// If true, no variant is generated when the source is smaller than the format
+func SkipIfSmaller(b bool) OptionFormat {
+	return func(f *Format) {
+		f.skipIfSmaller = b
+	}
+}
+
+// AllowUpscale returns OptionFormat to modify UpscaleWidth and UpscaleHeight
+// By default a variant dimension never exceeds the same source dimension. The guard bounds
+// the variant size only: when both dimensions are set the source is filled (scaled then
+// cropped) to the bounded size, so allowing one axis may still scale the source up on both
+// before the crop. With a backdrop, the source is fit inside the bounded size.
+func AllowUpscale(width, height bool) OptionFormat {
+	return func(f *Format) {
+		f.upscaleWidth = width
+		f.upscaleHeight = height
+	}
+}
+
+// Priority returns OptionFormat to modify Priority
+// Formats of higher priority are scheduled first, e.g. user-facing variants before background ones
+func Priority(n int) OptionFormat {
+	return func(f *Format) {
+		f.priority = n
+	}
+}
+
+// OutputFormat returns OptionFormat to modify Output
+// Variants are encoded in the format of extension ext (e.g. "png") and named after ConvertedNaming
+func OutputFormat(ext string) OptionFormat {
+	return func(f *Format) {
+		f.output = ext
+	}
+}
+
+// BackdropSize returns OptionFormat to modify the size of the fallback backdrop
+// The backdrop falling back to BackdropColor spans width x height rather than the format,
+// e.g. to match a branded canvas larger than the image
+func BackdropSize(width, height int) OptionFormat {
+	return func(f *Format) {
+		f.backdropWidth = width
+		f.backdropHeight = height
+	}
+}
+
+// DPI returns OptionFormat to modify DPI
+// JPEG variants get a JFIF density and PNG variants a pHYs chunk of dpi dots per inch,
+// sizing their physical output in print workflows; pixel dimensions are unchanged
+func DPI(dpi int) OptionFormat {
+	return func(f *Format) {
+		f.dpi = dpi
+	}
+}
+
+// MaxLongSide returns OptionFormat to modify MaxLongSide
+// Variants are scaled down, aspect preserved and uncropped, so that their longer side is at
+// most n pixels whatever the orientation, e.g. 2000 for web-sized images. The width and height
+// of the format are then ignored and sources already within n pixels keep their size
+func MaxLongSide(n int) OptionFormat {
+	return func(f *Format) {
+		f.maxLongSide = n
+	}
+}
+
+// Grayscale returns OptionFormat to modify Grayscale
+// If true, variants are converted to luminance and encoded with a single channel: JPEG
+// variants have one (Y) component and opaque PNG variants are grayscale, e.g. for e-ink or
+// print previews. PNG variants with transparency keep their alpha channel in RGBA
+func Grayscale(b bool) OptionFormat {
+	return func(f *Format) {
+		f.grayscale = b
+	}
+}
+
+// PreviewSize returns OptionFormat to modify PreviewSize
+// Each variant is accompanied by a preview scaled down from it to fit n x n pixels (e.g. 32),
+// encoded alike at the variant path followed by :preview, for front-ends to show while the
+// variant loads. Previews that fail are logged and the variant kept
+func PreviewSize(n int) OptionFormat {
+	return func(f *Format) {
+		f.previewSize = n
+	}
+}
+
+// Scrim returns OptionFormat to overlay a gradient on a side of variants, e.g. for caption legibility
+// side is Left, Right, Top or Bottom; the opacity of c goes from from at the side to to at the
+// opposite side (e.g. Bottom, black, 0.6, 0). The scrim is applied after resize, below the watermark
+func Scrim(side int, c color.NRGBA, from, to float64) OptionFormat {
+	return func(f *Format) {
+		f.scrim = &scrim{side: side, color: c, from: from, to: to}
+	}
+}
+
+// Focal returns OptionFormat to modify the focal point
+// x and y are fractions of the source width and height (e.g. 0.3, 0.7). When filling a
+// format, the source is cropped so that the focal point is as centered as its edges allow
+func Focal(x, y float64) OptionFormat {
+	return func(f *Format) {
+		f.focal = true
+		f.focalX = clampFraction(x)
+		f.focalY = clampFraction(y)
+	}
+}
+
+// clampFraction clamps v between 0 and 1
+func clampFraction(v float64) float64 {
+	if v < 0 {
+		return 0
+	}
+	if v > 1 {
+		return 1
+	}
+	return v
+}
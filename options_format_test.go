@@ -0,0 +1,99 @@
+package upload
+
+import (
+	"image/color"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestPriority(t *testing.T) {
+	p := NewImageProcessor(
+		Formats("email", 100, 100, false),
+		Formats("hero", 1200, 0, false),
+		Formats("card", 400, 300, false),
+		FormatOptions("hero", Priority(10)),
+		FormatOptions("card", Priority(5)),
+	)
+
+	var names []string
+	for _, format := range p.validFormats() {
+		names = append(names, format.name)
+	}
+
+	expected := []string{"hero", "card", "email"}
+	for i := range expected {
+		if i >= len(names) || names[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, names)
+		}
+	}
+}
+
+func TestMaxOutputDimension(t *testing.T) {
+	options := EvaluateImageOptions(
+		Formats("hero", 20000, 0, false),
+		Formats("card", 400, 300, false),
+		MaxOutputDimension(4000),
+	)
+
+	hero, _ := options.Format("hero")
+	if capped := options.capFormat(hero); capped.width != 4000 || capped.height != 0 {
+		t.Errorf("expected hero capped to 4000x0, got %vx%v", capped.width, capped.height)
+	}
+
+	card, _ := options.Format("card")
+	if capped := options.capFormat(card); capped.width != 400 || capped.height != 300 {
+		t.Errorf("expected card untouched, got %vx%v", capped.width, capped.height)
+	}
+}
+
+func TestMaxLongSide(t *testing.T) {
+	options := EvaluateImageOptions(
+		Formats("web", 0, 0, false),
+		FormatOptions("web", MaxLongSide(2000)),
+	)
+	web, _ := options.Format("web")
+
+	tests := []struct {
+		srcW, srcH    int
+		width, height int
+	}{
+		{4000, 3000, 2000, 1500},
+		{3000, 4000, 1500, 2000},
+		{1000, 800, 1000, 800},
+	}
+
+	for _, tt := range tests {
+		img := imaging.New(tt.srcW, tt.srcH, color.NRGBA{255, 0, 0, 255})
+		resized := newPipeline(img, options).Resize(web).Image()
+		if w, h := resized.Bounds().Dx(), resized.Bounds().Dy(); w != tt.width || h != tt.height {
+			t.Errorf("%vx%v: expected %vx%v, got %vx%v", tt.srcW, tt.srcH, tt.width, tt.height, w, h)
+		}
+		if w, h := variantSize(web, tt.srcW, tt.srcH); w != tt.width || h != tt.height {
+			t.Errorf("%vx%v: expected estimated %vx%v, got %vx%v", tt.srcW, tt.srcH, tt.width, tt.height, w, h)
+		}
+	}
+}
+
+func TestWatermarkFormats(t *testing.T) {
+	own := EvaluateWatermarkOptions(WatermarkHorizontal(Right))
+	options := EvaluateImageOptions(
+		Formats("thumb", 100, 100, false),
+		Formats("card", 400, 300, false),
+		Formats("hero", 1200, 0, false, WatermarkHorizontal(Right)),
+		WatermarkFormats([]string{"card", "hero"}, WatermarkHorizontal(Center)),
+	)
+
+	thumb, _ := options.Format("thumb")
+	if thumb.watermark != nil {
+		t.Error("expected thumb not watermarked")
+	}
+	card, _ := options.Format("card")
+	if card.watermark == nil || card.watermark.horizontal != Center {
+		t.Errorf("expected card given the shared watermark, got %+v", card.watermark)
+	}
+	hero, _ := options.Format("hero")
+	if hero.watermark == nil || *hero.watermark != *own {
+		t.Errorf("expected hero to keep its own watermark, got %+v", hero.watermark)
+	}
+}
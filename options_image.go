@@ -1,13 +1,69 @@
 package upload
 
 import (
+	"fmt"
+	"image/color"
+	"image/draw"
+	"math"
+	"time"
+
+	"github.com/disintegration/imaging"
 	"github.com/lsldigital/gocipe-upload/core"
 )
 
+// Policies applied to invalid formats
+const (
+	// InvalidFormatIgnore skips unnamed formats silently and processes others as is
+	InvalidFormatIgnore = iota
+	// InvalidFormatAbort rejects the whole job
+	InvalidFormatAbort
+	// InvalidFormatSkip skips invalid formats with a logged warning
+	InvalidFormatSkip
+	// InvalidFormatClamp clamps negative dimensions to 0 and skips formats it cannot fix
+	InvalidFormatClamp
+)
+
+// Placeholders written at variant paths while a job is processed
+const (
+	// PlaceholderNone writes no placeholder
+	PlaceholderNone = iota
+	// PlaceholderSolid writes a placeholder of PlaceholderColor
+	PlaceholderSolid
+	// PlaceholderDownsample writes a box downsampled placeholder of the source
+	PlaceholderDownsample
+)
+
+// Policies applied when writing a variant over an existing file
+const (
+	// WriteOverwrite replaces the existing file
+	WriteOverwrite = iota
+	// WriteErrorIfExists fails the format with ErrVariantExists
+	WriteErrorIfExists
+	// WriteVersion keeps the existing file as <path>.1, <path>.2, ... once the new one is written
+	WriteVersion
+)
+
+// Policies applied to paletted (indexed color) sources, e.g. GIF and 8-bit PNG
+const (
+	// PalettedConvert converts paletted sources to NRGBA before processing
+	PalettedConvert = iota
+	// PalettedReject rejects paletted sources with ErrPalettedSource
+	PalettedReject
+)
+
 var (
 	defaultImageOptions = &OptionsImage{
-		minWidth:  core.NoLimit,
-		minHeight: core.NoLimit,
+		minWidth:     core.NoLimit,
+		minHeight:    core.NoLimit,
+		maxFormats:   32,
+		storage:      NewDiskStorage(),
+		filter:       imaging.Lanczos,
+		flattenColor: color.NRGBA{255, 255, 255, 255},
+
+		placeholderColor: color.NRGBA{230, 230, 230, 255},
+		backdropColor:    color.NRGBA{0, 29, 56, 255},
+		convertSRGB:      true,
+		convertedNaming:  defaultConvertedNaming,
 	}
 )
 
@@ -18,37 +74,239 @@ type Format struct {
 	height    int
 	backdrop  bool              // (default: false) If true, will add a backdrop
 	watermark *OptionsWatermark // (default: nil) If not nil, will overlay an image as watermark at X,Y pos +-OffsetX,OffsetY
+
+	skipIfSmaller bool   // (default: false) If true, will not generate a variant larger than the source
+	upscaleWidth  bool   // (default: false) If true, the variant width may exceed the source width
+	upscaleHeight bool   // (default: false) If true, the variant height may exceed the source height
+	priority      int    // (default: 0) Formats of higher priority are processed first
+	output        string // (default: "") Extension of the format variants are encoded in, the source one if empty
+
+	focal  bool    // (default: false) If true, crop around the focal point rather than the center
+	focalX float64 // Focal point abscissa as a fraction of the source width
+	focalY float64 // Focal point ordinate as a fraction of the source height
+
+	backdropWidth  int // (default: 0, format width) Width of the fallback backdrop
+	backdropHeight int // (default: 0, format height) Height of the fallback backdrop
+
+	dpi int // (default: 0, unset) Density written to JPEG and PNG variants, in dots per inch
+
+	maxLongSide int // (default: 0, unset) If set, variants are scaled down so that their longer side fits it
+
+	grayscale bool // (default: false) If true, variants are encoded with a single gray channel
+
+	previewSize int // (default: 0, unset) If set, a preview fitting this size is written with each variant
+
+	scrim *scrim // (default: nil) If not nil, a gradient is overlaid on a side of variants
 }
 
 // Name returns Name option format
-func(o Format) Name() string {
+func (o Format) Name() string {
 	return o.name
 }
 
 // Width returns Width option format
-func(o Format) Width() int {
+func (o Format) Width() int {
 	return o.width
 }
 
 // Height returns Height option format
-func(o Format) Height() int {
+func (o Format) Height() int {
 	return o.height
 }
 
 // Backdrop returns Backdrop option format
-func(o Format) Backdrop() bool {
+func (o Format) Backdrop() bool {
 	return o.backdrop
 }
 
+// SkipIfSmaller returns SkipIfSmaller option format
+func (o Format) SkipIfSmaller() bool {
+	return o.skipIfSmaller
+}
+
+// UpscaleWidth returns UpscaleWidth option format
+func (o Format) UpscaleWidth() bool {
+	return o.upscaleWidth
+}
+
+// UpscaleHeight returns UpscaleHeight option format
+func (o Format) UpscaleHeight() bool {
+	return o.upscaleHeight
+}
+
+// Priority returns Priority option format
+func (o Format) Priority() int {
+	return o.priority
+}
+
+// Output returns Output option format
+func (o Format) Output() string {
+	return o.output
+}
+
+// Focal returns the focal point option format as fractions of the source, and whether it is set
+func (o Format) Focal() (float64, float64, bool) {
+	return o.focalX, o.focalY, o.focal
+}
+
+// BackdropSize returns the size of the fallback backdrop option format
+func (o Format) BackdropSize() (int, int) {
+	width, height := o.width, o.height
+	if o.backdropWidth > 0 {
+		width = o.backdropWidth
+	}
+	if o.backdropHeight > 0 {
+		height = o.backdropHeight
+	}
+	return width, height
+}
+
+// DPI returns DPI option format
+func (o Format) DPI() int {
+	return o.dpi
+}
+
+// Grayscale returns Grayscale option format
+func (o Format) Grayscale() bool {
+	return o.grayscale
+}
+
+// PreviewSize returns PreviewSize option format
+func (o Format) PreviewSize() int {
+	return o.previewSize
+}
+
+// MaxLongSide returns MaxLongSide option format
+func (o Format) MaxLongSide() int {
+	return o.maxLongSide
+}
+
+// longSideSize returns the size of a source of the given size scaled down so that its longer
+// side fits MaxLongSide, aspect preserved
+func (o Format) longSideSize(width, height int) (int, int) {
+	long := width
+	if height > long {
+		long = height
+	}
+	if long <= o.maxLongSide || long <= 0 {
+		return width, height
+	}
+
+	width = int(math.Max(1, math.Round(float64(width)*float64(o.maxLongSide)/float64(long))))
+	height = int(math.Max(1, math.Round(float64(height)*float64(o.maxLongSide)/float64(long))))
+	return width, height
+}
+
+// Scrim returns the scrim option format: side, color and opacities at the side and the opposite one,
+// and whether it is set
+func (o Format) Scrim() (int, color.NRGBA, float64, float64, bool) {
+	if o.scrim == nil {
+		return 0, color.NRGBA{}, 0, 0, false
+	}
+	return o.scrim.side, o.scrim.color, o.scrim.from, o.scrim.to, true
+}
+
+// smallerSource checks if a source of the given size is smaller than format
+func (o Format) smallerSource(width, height int) bool {
+	return (o.width > 0 && width < o.width) || (o.height > 0 && height < o.height)
+}
+
+// coveredBy checks if an image of the given size is large enough to generate format
+func (o Format) coveredBy(width, height int) bool {
+	if o.width <= 0 && o.height <= 0 {
+		return false
+	}
+	return width >= o.width && height >= o.height
+}
+
+// validate checks if format can be processed
+func (o Format) validate() error {
+	if o.name == "" {
+		return fmt.Errorf("format name empty")
+	}
+	if o.width < 0 || o.height < 0 {
+		return fmt.Errorf("format %v has negative dimensions", o.name)
+	}
+	return nil
+}
+
+// native checks if format keeps the size of the source, only re-encoding it
+func (o Format) native() bool {
+	return o.width == 0 && o.height == 0
+}
+
+// clamp returns format with negative dimensions set to 0
+// A format left without dimensions is invalid rather than native
+func (o Format) clamp() (Format, error) {
+	if o.width < 0 && o.height <= 0 || o.height < 0 && o.width <= 0 {
+		return o, fmt.Errorf("format %v has no dimensions", o.name)
+	}
+	if o.width < 0 {
+		o.width = 0
+	}
+	if o.height < 0 {
+		o.height = 0
+	}
+	return o, o.validate()
+}
+
 // Watermark returns Watermark option format
-func(o Format) Watermark() OptionsWatermark {
+func (o Format) Watermark() OptionsWatermark {
 	return *o.watermark
 }
 
 type OptionsImage struct {
-	minWidth  int
-	minHeight int
-	formats   []Format
+	minWidth          int
+	minHeight         int
+	maxFormats        int
+	fastThumbnail     bool
+	watermarkMinWidth int
+	watermarkFormats  []string
+	watermark         *OptionsWatermark
+	storage           Storage
+	idempotencyStore  IdempotencyStore
+	overrides         map[string]*FormatOverride
+	preserveModTime   bool
+	lqip              int
+	targetSSIM        float64
+	qualityFunc       func(width, height int) int
+	filter            imaging.ResampleFilter
+	atomic            bool
+	latestWins        bool
+	perceptualHash    bool
+	onInvalidFormat   int
+	writeSidecar      bool
+	flattenColor      color.NRGBA
+	backdropColor     color.NRGBA
+	backdropFeather   float64
+	verifyDecode      bool
+	readCaptureTime   bool
+	openRetries       int
+	openBackoff       time.Duration
+	decodeTimeout     time.Duration
+	exifThumbnail     bool
+	sourceCache       int
+	placeholder       int
+	palettedSources   int
+	placeholderColor  color.NRGBA
+	convertSRGB       bool
+	convertedNaming   string
+	shardLevels       int
+	deterministic     bool
+	overridesFile     bool
+	maxJobs           int
+	maxOutput         int
+	quantizeColors    int
+	quantizeDither    bool
+	quantizer         draw.Quantizer
+	onFormatSkipped   func(FormatSkipped)
+	formats           []Format
+	animatedFormats   []Format
+	imageSignatures   [][]byte
+	imagePredicate    func([]byte) bool
+	allowedDirs       []string
+	writePolicy       int
+	formatOpts        []namedFormatOptions
 }
 
 // EvaluateImageOptions returns optionsImage
@@ -58,24 +316,282 @@ func EvaluateImageOptions(opts ...OptionImage) *OptionsImage {
 	for _, o := range opts {
 		o(optCopy)
 	}
+	optCopy.applyFormatOptions()
+	optCopy.applyWatermarkFormats()
 	return optCopy
 }
 
 // MinWidth returns MinWidth option image
-func(o OptionsImage) MinWidth() int {
+func (o OptionsImage) MinWidth() int {
 	return o.minWidth
 }
 
 // MinHeight returns MinHeight option image
-func(o OptionsImage) MinHeight() int {
+func (o OptionsImage) MinHeight() int {
 	return o.minHeight
 }
 
+// MaxFormats returns MaxFormats option image
+func (o OptionsImage) MaxFormats() int {
+	return o.maxFormats
+}
+
+// FastThumbnail returns FastThumbnail option image
+func (o OptionsImage) FastThumbnail() bool {
+	return o.fastThumbnail
+}
+
+// WatermarkMinWidth returns WatermarkMinWidth option image
+func (o OptionsImage) WatermarkMinWidth() int {
+	return o.watermarkMinWidth
+}
+
+// WatermarkFormats returns the names of the formats given the WatermarkFormats watermark
+func (o OptionsImage) WatermarkFormats() []string {
+	return o.watermarkFormats
+}
+
+// Storage returns Storage option image
+func (o OptionsImage) Storage() Storage {
+	return o.storage
+}
+
+// IdempotencyStore returns IdempotencyStore option image
+func (o OptionsImage) IdempotencyStore() IdempotencyStore {
+	return o.idempotencyStore
+}
+
+// Override returns the FormatOverride for images of source type imgType, if any
+func (o OptionsImage) Override(imgType string) *FormatOverride {
+	return o.overrides[imgType]
+}
+
+// sourceFormats returns formats with the output of the Override of imgType applied
+func (o OptionsImage) sourceFormats(imgType string, formats []Format) []Format {
+	override := o.Override(imgType)
+	if override == nil || override.output == "" {
+		return formats
+	}
+
+	overridden := make([]Format, len(formats))
+	for i, format := range formats {
+		format.output = override.output
+		overridden[i] = format
+	}
+	return overridden
+}
+
+// PreserveModTime returns PreserveModTime option image
+func (o OptionsImage) PreserveModTime() bool {
+	return o.preserveModTime
+}
+
+// LQIP returns LQIP option image
+func (o OptionsImage) LQIP() int {
+	return o.lqip
+}
+
+// TargetSSIM returns TargetSSIM option image
+func (o OptionsImage) TargetSSIM() float64 {
+	return o.targetSSIM
+}
+
+// QualityFunc returns QualityFunc option image
+func (o OptionsImage) QualityFunc() func(width, height int) int {
+	return o.qualityFunc
+}
+
+// Filter returns Filter option image
+func (o OptionsImage) Filter() imaging.ResampleFilter {
+	return o.filter
+}
+
+// Atomic returns Atomic option image
+func (o OptionsImage) Atomic() bool {
+	return o.atomic
+}
+
+// LatestWins returns LatestWins option image
+func (o OptionsImage) LatestWins() bool {
+	return o.latestWins
+}
+
+// HashPerceptual returns HashPerceptual option image
+func (o OptionsImage) HashPerceptual() bool {
+	return o.perceptualHash
+}
+
+// OnInvalidFormat returns OnInvalidFormat option image
+func (o OptionsImage) OnInvalidFormat() int {
+	return o.onInvalidFormat
+}
+
+// WriteSidecar returns WriteSidecar option image
+func (o OptionsImage) WriteSidecar() bool {
+	return o.writeSidecar
+}
+
+// BackdropColor returns BackdropColor option image
+func (o OptionsImage) BackdropColor() color.NRGBA {
+	return o.backdropColor
+}
+
+// BackdropFeather returns BackdropFeather option image
+func (o OptionsImage) BackdropFeather() float64 {
+	return o.backdropFeather
+}
+
+// FlattenColor returns FlattenColor option image
+func (o OptionsImage) FlattenColor() color.NRGBA {
+	return o.flattenColor
+}
+
+// VerifyDecode returns VerifyDecode option image
+func (o OptionsImage) VerifyDecode() bool {
+	return o.verifyDecode
+}
+
+// ReadCaptureTime returns ReadCaptureTime option image
+func (o OptionsImage) ReadCaptureTime() bool {
+	return o.readCaptureTime
+}
+
+// OpenRetries returns OpenRetries option image
+func (o OptionsImage) OpenRetries() int {
+	return o.openRetries
+}
+
+// OpenBackoff returns OpenBackoff option image
+func (o OptionsImage) OpenBackoff() time.Duration {
+	return o.openBackoff
+}
+
+// ExifThumbnail returns ExifThumbnail option image
+func (o OptionsImage) ExifThumbnail() bool {
+	return o.exifThumbnail
+}
+
+// SourceCache returns SourceCache option image
+func (o OptionsImage) SourceCache() int {
+	return o.sourceCache
+}
+
+// Placeholder returns Placeholder option image
+func (o OptionsImage) Placeholder() int {
+	return o.placeholder
+}
+
+// PalettedSources returns PalettedSources option image
+func (o OptionsImage) PalettedSources() int {
+	return o.palettedSources
+}
+
+// PlaceholderColor returns PlaceholderColor option image
+func (o OptionsImage) PlaceholderColor() color.NRGBA {
+	return o.placeholderColor
+}
+
+// ConvertSRGB returns ConvertSRGB option image
+func (o OptionsImage) ConvertSRGB() bool {
+	return o.convertSRGB
+}
+
+// ConvertedNaming returns ConvertedNaming option image
+func (o OptionsImage) ConvertedNaming() string {
+	return o.convertedNaming
+}
+
+// WritePolicy returns WritePolicy option image
+func (o OptionsImage) WritePolicy() int {
+	return o.writePolicy
+}
+
+// AllowedDirs returns AllowedDirs option image
+func (o OptionsImage) AllowedDirs() []string {
+	return o.allowedDirs
+}
+
+// ShardLevels returns ShardLevels option image
+func (o OptionsImage) ShardLevels() int {
+	return o.shardLevels
+}
+
+// Deterministic returns Deterministic option image
+func (o OptionsImage) Deterministic() bool {
+	return o.deterministic
+}
+
+// OverridesFile returns OverridesFile option image
+func (o OptionsImage) OverridesFile() bool {
+	return o.overridesFile
+}
+
+// OnFormatSkipped returns OnFormatSkipped option image
+func (o OptionsImage) OnFormatSkipped() func(FormatSkipped) {
+	return o.onFormatSkipped
+}
+
+// DecodeTimeout returns DecodeTimeout option image
+func (o OptionsImage) DecodeTimeout() time.Duration {
+	return o.decodeTimeout
+}
+
+// MaxOutputDimension returns MaxOutputDimension option image
+func (o OptionsImage) MaxOutputDimension() int {
+	return o.maxOutput
+}
+
+// QuantizeColors returns QuantizeColors option image
+func (o OptionsImage) QuantizeColors() int {
+	return o.quantizeColors
+}
+
+// QuantizeDither returns QuantizeDither option image
+func (o OptionsImage) QuantizeDither() bool {
+	return o.quantizeDither
+}
+
+// Quantizer returns Quantizer option image
+func (o OptionsImage) Quantizer() draw.Quantizer {
+	return o.quantizer
+}
+
+// MaxJobs returns MaxJobs option image
+func (o OptionsImage) MaxJobs() int {
+	return o.maxJobs
+}
+
 // Formats returns Formats option image
-func(o OptionsImage) Formats() []Format {
+func (o OptionsImage) Formats() []Format {
 	return o.formats
 }
 
+// ImageSignatures returns ImageSignatures option image
+func (o OptionsImage) ImageSignatures() [][]byte {
+	return o.imageSignatures
+}
+
+// ImagePredicate returns ImagePredicate option image
+func (o OptionsImage) ImagePredicate() func([]byte) bool {
+	return o.imagePredicate
+}
+
+// AnimatedFormats returns AnimatedFormats option image
+func (o OptionsImage) AnimatedFormats() []Format {
+	return o.animatedFormats
+}
+
+// Format returns the Format option image matching name
+func (o OptionsImage) Format(name string) (Format, bool) {
+	for _, format := range o.formats {
+		if format.name == name {
+			return format, true
+		}
+	}
+
+	return Format{}, false
+}
+
 // OptionImage is a function to modify options image
 type OptionImage func(*OptionsImage)
 
@@ -93,7 +609,433 @@ func MinHeight(d int) OptionImage {
 	}
 }
 
+// MaxFormats returns a function to modify MaxFormats option image
+func MaxFormats(n int) OptionImage {
+	return func(o *OptionsImage) {
+		o.maxFormats = n
+	}
+}
+
+// FastThumbnail returns a function to modify FastThumbnail option image
+// If true, small targets are box downsampled before the final resize pass
+func FastThumbnail(b bool) OptionImage {
+	return func(o *OptionsImage) {
+		o.fastThumbnail = b
+	}
+}
+
+// WatermarkFormats returns a function to watermark the formats named names
+// The watermark of opts is configured once for all of them; formats given their own
+// watermark keep it, and other formats are not watermarked. Empty names leave
+// formats as configured
+func WatermarkFormats(names []string, opts ...OptionWatermark) OptionImage {
+	return func(o *OptionsImage) {
+		o.watermarkFormats = names
+		o.watermark = EvaluateWatermarkOptions(opts...)
+	}
+}
+
+// WatermarkMinWidth returns a function to modify WatermarkMinWidth option image
+// Variants narrower than d are not watermarked (default: 0, always watermark)
+func WatermarkMinWidth(d int) OptionImage {
+	return func(o *OptionsImage) {
+		o.watermarkMinWidth = d
+	}
+}
+
+// WithStorage returns a function to modify Storage option image
+func WithStorage(s Storage) OptionImage {
+	return func(o *OptionsImage) {
+		o.storage = s
+	}
+}
+
+// WithIdempotencyStore returns a function to modify IdempotencyStore option image
+// Jobs given an IdempotencyKey are recorded in s once completed without failure,
+// Process returning ErrAlreadyProcessed for their key afterwards (default: nil)
+func WithIdempotencyStore(s IdempotencyStore) OptionImage {
+	return func(o *OptionsImage) {
+		o.idempotencyStore = s
+	}
+}
+
+// SourceOverride returns a function to add a FormatOverride for images of source type imgType
+// imgType is the decoded image format name (e.g. TypeImageJPEG, TypeImagePNG)
+// Qualities set for an image by overrides files prevail over the override quality, which
+// prevails over QualityFunc and TargetSSIM. GetOrGenerate writes formats as requested
+func SourceOverride(imgType string, opts ...OptionOverride) OptionImage {
+	return func(o *OptionsImage) {
+		overrides := make(map[string]*FormatOverride, len(o.overrides)+1)
+		for k, v := range o.overrides {
+			overrides[k] = v
+		}
+		overrides[imgType] = EvaluateOverrideOptions(opts...)
+		o.overrides = overrides
+	}
+}
+
+// PreserveModTime returns a function to modify PreserveModTime option image
+// If true, variants written on disk carry the modification time of their source
+func PreserveModTime(b bool) OptionImage {
+	return func(o *OptionsImage) {
+		o.preserveModTime = b
+	}
+}
+
+// LQIP returns a function to modify LQIP option image
+// If maxDim > 0, jobs carry a placeholder data URI fitting within maxDim x maxDim
+func LQIP(maxDim int) OptionImage {
+	return func(o *OptionsImage) {
+		o.lqip = maxDim
+	}
+}
+
+// TargetSSIM returns a function to modify TargetSSIM option image
+// If > 0, JPEG variants are encoded at the lowest quality keeping this SSIM (0-1)
+// against the resized image. The search costs several extra encodes per variant.
+func TargetSSIM(t float64) OptionImage {
+	return func(o *OptionsImage) {
+		o.targetSSIM = t
+	}
+}
+
+// QualityFunc returns a function to modify QualityFunc option image
+// JPEG variants are encoded at the quality f returns for their output size, e.g.
+// DefaultQualityCurve, and TargetSSIM is not searched. f is not called when a quality
+// is set for the image by overrides files or for its type by SourceOverride. Results of 0
+// or less fall back to TargetSSIM (default: nil)
+func QualityFunc(f func(width, height int) int) OptionImage {
+	return func(o *OptionsImage) {
+		o.qualityFunc = f
+	}
+}
+
+// DefaultQualityCurve is a QualityFunc lowering JPEG quality for smaller variants, where
+// artifacts are less visible: 70 up to 150px on the longer side, 75 up to 400px,
+// 80 up to 1000px and 85 above
+func DefaultQualityCurve(width, height int) int {
+	long := width
+	if height > long {
+		long = height
+	}
+
+	switch {
+	case long <= 150:
+		return 70
+	case long <= 400:
+		return 75
+	case long <= 1000:
+		return 80
+	}
+	return 85
+}
+
+// Filter returns a function to modify Filter option image
+// The filter is used by every resize of a format, backdrop included (default: imaging.Lanczos)
+func Filter(f imaging.ResampleFilter) OptionImage {
+	return func(o *OptionsImage) {
+		o.filter = f
+	}
+}
+
+// Atomic returns a function to modify Atomic option image
+// If true, a job failing on any format removes all its variants and reports failure
+func Atomic(b bool) OptionImage {
+	return func(o *OptionsImage) {
+		o.atomic = b
+	}
+}
+
+// LatestWins returns a function to modify LatestWins option image
+// If true, an image submitted while a job is in progress for its disk path cancels that job,
+// which removes the variants it wrote, and is processed once it is done (default: false)
+func LatestWins(b bool) OptionImage {
+	return func(o *OptionsImage) {
+		o.latestWins = b
+	}
+}
+
+// HashPerceptual returns a function to modify HashPerceptual option image
+// If true, jobs carry the perceptual hash of their source image
+func HashPerceptual(b bool) OptionImage {
+	return func(o *OptionsImage) {
+		o.perceptualHash = b
+	}
+}
+
+// OnInvalidFormat returns a function to modify OnInvalidFormat option image
+// (default: InvalidFormatIgnore)
+func OnInvalidFormat(policy int) OptionImage {
+	return func(o *OptionsImage) {
+		o.onInvalidFormat = policy
+	}
+}
+
+// WriteSidecar returns a function to modify WriteSidecar option image
+// If true, a JSON Sidecar describing each variant is written next to it
+func WriteSidecar(b bool) OptionImage {
+	return func(o *OptionsImage) {
+		o.writeSidecar = b
+	}
+}
+
+// BackdropColor returns a function to modify BackdropColor option image
+// Backdropped formats fall back to a backdrop of color c when their backdrop image
+// cannot be opened (default: opaque dark blue)
+func BackdropColor(c color.NRGBA) OptionImage {
+	return func(o *OptionsImage) {
+		o.backdropColor = c
+	}
+}
+
+// BackdropFeather returns a function to modify BackdropFeather option image
+// Transparent edges of images composited on their backdrop (e.g. rounded corners) are
+// feathered inwards over about radius pixels, smoothing jagged transitions to the
+// backdrop; opaque images are unaffected (default: 0, no feathering)
+func BackdropFeather(radius float64) OptionImage {
+	return func(o *OptionsImage) {
+		o.backdropFeather = radius
+	}
+}
+
+// FlattenColor returns a function to modify FlattenColor option image
+// Transparent images encoded to JPEG are composited over c (default: white)
+func FlattenColor(c color.NRGBA) OptionImage {
+	return func(o *OptionsImage) {
+		o.flattenColor = c
+	}
+}
+
+// VerifyDecode returns a function to modify VerifyDecode option image
+// If true, Process fully decodes images to reject truncated files synchronously
+func VerifyDecode(b bool) OptionImage {
+	return func(o *OptionsImage) {
+		o.verifyDecode = b
+	}
+}
+
+// ReadCaptureTime returns a function to modify ReadCaptureTime option image
+// If true, jobs carry the EXIF capture time of their source image, if any
+func ReadCaptureTime(b bool) OptionImage {
+	return func(o *OptionsImage) {
+		o.readCaptureTime = b
+	}
+}
+
+// OpenRetries returns a function to modify OpenRetries and OpenBackoff option image
+// Opening a source image is retried up to n times, doubling backoff between attempts.
+// This helps on Windows where a just written file may briefly be locked.
+func OpenRetries(n int, backoff time.Duration) OptionImage {
+	return func(o *OptionsImage) {
+		o.openRetries = n
+		o.openBackoff = backoff
+	}
+}
+
+// ExifThumbnail returns a function to modify ExifThumbnail option image
+// If true, formats smaller than the embedded EXIF thumbnail are resized from it
+func ExifThumbnail(b bool) OptionImage {
+	return func(o *OptionsImage) {
+		o.exifThumbnail = b
+	}
+}
+
+// SourceCache returns a function to modify SourceCache option image
+// Decoded sources are kept across jobs up to a total of pixels, and decoded again
+// once modified on disk (default: 0, disabled)
+func SourceCache(pixels int) OptionImage {
+	return func(o *OptionsImage) {
+		o.sourceCache = pixels
+	}
+}
+
+// Placeholder returns a function to modify Placeholder option image
+// Placeholders are written synchronously by Process at each variant path, then replaced
+// by the variants; placeholders of formats not generated are removed (default: PlaceholderNone)
+func Placeholder(strategy int) OptionImage {
+	return func(o *OptionsImage) {
+		o.placeholder = strategy
+	}
+}
+
+// PalettedSources returns a function to modify PalettedSources option image
+// Paletted sources are converted to truecolor before resizing to avoid banding, or rejected
+// by Process with PalettedReject (default: PalettedConvert)
+func PalettedSources(policy int) OptionImage {
+	return func(o *OptionsImage) {
+		o.palettedSources = policy
+	}
+}
+
+// PlaceholderColor returns a function to modify PlaceholderColor option image
+// (default: light gray)
+func PlaceholderColor(c color.NRGBA) OptionImage {
+	return func(o *OptionsImage) {
+		o.placeholderColor = c
+	}
+}
+
+// ConvertSRGB returns a function to modify ConvertSRGB option image
+// If true, JPEG sources embedding a wide-gamut ICC profile (e.g. Display P3, Adobe RGB)
+// have their pixels converted to sRGB; disable for color-managed archival (default: true)
+// Uploads whose profile is dropped by MaxICCSize are converted on upload instead
+func ConvertSRGB(b bool) OptionImage {
+	return func(o *OptionsImage) {
+		o.convertSRGB = b
+	}
+}
+
+// ConvertedNaming returns a function to modify ConvertedNaming option image
+// The template names variants encoded in another format than their source, next to it.
+// It expands {base} (source name without extension), {format} (format name),
+// {ext} (variant extension) and {srcext} (source extension) (default: "{base}_{format}.{ext}")
+func ConvertedNaming(template string) OptionImage {
+	return func(o *OptionsImage) {
+		o.convertedNaming = template
+	}
+}
+
+// WritePolicy returns a function to modify WritePolicy option image
+// The policy applies to variants existing on DiskStorage before their write, which still goes
+// through a temporary file: with WriteErrorIfExists it only lands if no file appeared meanwhile.
+// Other storages overwrite, and placeholders are only written with WriteOverwrite
+// (default: WriteOverwrite)
+func WritePolicy(policy int) OptionImage {
+	return func(o *OptionsImage) {
+		o.writePolicy = policy
+	}
+}
+
+// AllowedDirs returns a function to modify AllowedDirs option image
+// Images and variants must lie within one of dirs once ../ traversal is resolved, or are
+// rejected with ErrPathNotAllowed, e.g. when paths are derived from user input.
+// Symbolic links are not resolved (default: none, any path allowed)
+func AllowedDirs(dirs ...string) OptionImage {
+	return func(o *OptionsImage) {
+		o.allowedDirs = dirs
+	}
+}
+
+// ShardLevels returns a function to modify ShardLevels option image
+// Variants are written n directories down from their source, in ShardDir of its file name
+// (e.g. ab/cd/name.jpg:thumb), keeping directories small; the directories are created as
+// needed. Sources themselves stay in place (default: 0, variants next to their source)
+func ShardLevels(n int) OptionImage {
+	return func(o *OptionsImage) {
+		o.shardLevels = n
+	}
+}
+
+// Deterministic returns a function to modify Deterministic option image
+// If true, encoder settings are pinned instead of left to library defaults so that the
+// same source and options yield byte-identical variants. Output remains tied to the
+// versions of Go (image/jpeg, image/png, compress/flate) and imaging in use
+func Deterministic(b bool) OptionImage {
+	return func(o *OptionsImage) {
+		o.deterministic = b
+	}
+}
+
+// OverridesFile returns a function to modify OverridesFile option image
+// If true, the dimensions and quality of an image are overridden by the ImageOverrides
+// read from <name>.upload.json next to it, if any
+func OverridesFile(b bool) OptionImage {
+	return func(o *OptionsImage) {
+		o.overridesFile = b
+	}
+}
+
+// OnFormatSkipped returns a function to modify OnFormatSkipped option image
+// f is called from the job goroutine for every format of a job not generated on purpose,
+// telling misconfigured formats (SkippedInvalid) from SkipIfSmaller ones (SkippedSmaller)
+func OnFormatSkipped(f func(FormatSkipped)) OptionImage {
+	return func(o *OptionsImage) {
+		o.onFormatSkipped = f
+	}
+}
+
+// DecodeTimeout returns a function to modify DecodeTimeout option image
+// Decoding a source is given up on after d with ErrDecodeTimeout, guarding workers against
+// crafted inputs slow to decode (e.g. progressive JPEGs) (default: 0, no timeout)
+func DecodeTimeout(d time.Duration) OptionImage {
+	return func(o *OptionsImage) {
+		o.decodeTimeout = d
+	}
+}
+
+// MaxOutputDimension returns a function to modify MaxOutputDimension option image
+// Format dimensions above n pixels are clamped to n whatever formats and overrides request,
+// guarding against misconfiguration (default: 0, no limit)
+func MaxOutputDimension(n int) OptionImage {
+	return func(o *OptionsImage) {
+		o.maxOutput = n
+	}
+}
+
+// Quantize returns a function to modify QuantizeColors and QuantizeDither option image
+// PNG and GIF variants are reduced to a palette of at most colors colors, shrinking flat-color
+// graphics considerably; dither diffuses the quantization error (default: 0, truecolor)
+func Quantize(colors int, dither bool) OptionImage {
+	return func(o *OptionsImage) {
+		o.quantizeColors = colors
+		o.quantizeDither = dither
+	}
+}
+
+// Quantizer returns a function to modify Quantizer option image
+// The quantizer builds the palette used by Quantize (default: nil, median cut)
+func Quantizer(q draw.Quantizer) OptionImage {
+	return func(o *OptionsImage) {
+		o.quantizer = q
+	}
+}
+
+// ImageSignature returns a function to add an ImageSignature option image
+// Content starting with magic is accepted as an image besides the types known to filetype,
+// e.g. a variant the bundled matchers lag behind on.
+// Loosening validation lets more untrusted input reach the decoders: only add signatures
+// of formats a registered decoder handles, the content still having to decode
+func ImageSignature(magic []byte) OptionImage {
+	return func(o *OptionsImage) {
+		o.imageSignatures = append(o.imageSignatures, magic)
+	}
+}
+
+// ImagePredicate returns a function to modify ImagePredicate option image
+// Content f returns true for is accepted as an image besides the types known to filetype.
+// As with ImageSignature, f widens what untrusted input reaches the decoders: keep it strict
+func ImagePredicate(f func(content []byte) bool) OptionImage {
+	return func(o *OptionsImage) {
+		o.imagePredicate = f
+	}
+}
+
+// AnimatedFormats returns a function to modify AnimatedFormats option image
+// Animated sources (multi-frame GIF, APNG) are processed with the formats of opts
+// (Formats, FormatOptions) instead of the image formats, e.g. smaller sizes to limit file size
+func AnimatedFormats(opts ...OptionImage) OptionImage {
+	return func(o *OptionsImage) {
+		animated := &OptionsImage{}
+		for _, opt := range opts {
+			opt(animated)
+		}
+		animated.applyFormatOptions()
+		o.animatedFormats = animated.formats
+	}
+}
+
+// MaxJobs returns a function to modify MaxJobs option image
+// Process blocks while n jobs are in progress, ProcessTimeout gives up with ErrQueueFull
+// (default: 0, no limit)
+func MaxJobs(n int) OptionImage {
+	return func(o *OptionsImage) {
+		o.maxJobs = n
+	}
+}
+
 // Formats returns a function to add Format option image
+// A dimension set to 0 preserves the aspect ratio; both set to 0 keep the size of the source,
+// which is only re-encoded (output format, quality, no metadata), e.g. to optimize uploads
 func Formats(name string, width int, height int, backdrop bool, opts ...OptionWatermark) OptionImage {
 	return func(o *OptionsImage) {
 		var watermarkOpts *OptionsWatermark
@@ -0,0 +1,49 @@
+package upload
+
+import (
+	"image"
+	"time"
+)
+
+// OptionJob is a function to modify a job before it is processed
+type OptionJob func(*Job)
+
+// WatermarkFunc decides the watermark of a format for a job, nil meaning no watermark
+type WatermarkFunc func(format Format) *OptionsWatermark
+
+// WithWatermarkFunc returns OptionJob to decide the watermark of each format at runtime
+// When set, it replaces the watermark configured on the formats
+func WithWatermarkFunc(fn WatermarkFunc) OptionJob {
+	return func(j *Job) {
+		j.watermarkFunc = fn
+	}
+}
+
+// CropResolver decides the region of the source cropped for a format, in source pixels,
+// false meaning the default crop
+type CropResolver func(config image.Config, format Format) (image.Rectangle, bool)
+
+// WithCropResolver returns OptionJob to decide the crop of each format at runtime, e.g. from
+// an editorial or cropping service. The region is then resized to the format; regions outside
+// the source or empty fall back to the default crop with a logged warning
+func WithCropResolver(fn CropResolver) OptionJob {
+	return func(j *Job) {
+		j.cropResolver = fn
+	}
+}
+
+// IdempotencyKey returns OptionJob to identify a job by key, e.g. a message ID
+// With an IdempotencyStore, jobs of keys already completed are not processed again
+func IdempotencyKey(key string) OptionJob {
+	return func(j *Job) {
+		j.idempotencyKey = key
+	}
+}
+
+// Deadline returns OptionJob to abort a job still in progress at t
+// Formats not started by then are not processed, the job reporting ErrDeadlineExceeded
+func Deadline(t time.Time) OptionJob {
+	return func(j *Job) {
+		j.Deadline = t
+	}
+}
@@ -0,0 +1,50 @@
+package upload
+
+var (
+	defaultOverrideOptions = &FormatOverride{}
+)
+
+// FormatOverride holds options overriding formats for images of a specific source type
+type FormatOverride struct {
+	quality int    // (default: 0) If > 0, JPEG encoding quality
+	output  string // (default: "") If set, extension of the format variants are encoded in
+}
+
+// EvaluateOverrideOptions returns FormatOverride
+func EvaluateOverrideOptions(opts ...OptionOverride) *FormatOverride {
+	optCopy := &FormatOverride{}
+	*optCopy = *defaultOverrideOptions
+	for _, o := range opts {
+		o(optCopy)
+	}
+	return optCopy
+}
+
+// Quality returns Quality option override
+func (o FormatOverride) Quality() int {
+	return o.quality
+}
+
+// Output returns Output option override
+func (o FormatOverride) Output() string {
+	return o.output
+}
+
+// OptionOverride is a function to modify override options
+type OptionOverride func(*FormatOverride)
+
+// OverrideQuality returns OptionOverride to modify Quality
+func OverrideQuality(q int) OptionOverride {
+	return func(o *FormatOverride) {
+		o.quality = q
+	}
+}
+
+// OverrideOutput returns OptionOverride to modify Output
+// Variants of sources of the type are encoded in the format of extension ext whatever the
+// OutputFormat of their format, e.g. "png" to keep PNG sources as PNG
+func OverrideOutput(ext string) OptionOverride {
+	return func(o *FormatOverride) {
+		o.output = ext
+	}
+}
@@ -1,5 +1,9 @@
 package upload
 
+import (
+	"image/color"
+)
+
 var (
 	defaultWatermarkOptions = &OptionsWatermark{}
 )
@@ -10,6 +14,17 @@ type OptionsWatermark struct {
 	vertical   int
 	offsetX    int
 	offsetY    int
+
+	relativeToContent bool // (default: false) If true, position within the image content rather than the backdrop frame
+
+	blend int // (default: BlendNormal) Mode the watermark is blended with the image in
+
+	scale   float64 // (default: 0, natural size) Width of the watermark as a fraction of the image width
+	minSize int     // (default: 0) Minimum width in pixels of a scaled watermark
+
+	tint      bool        // (default: false) If true, recolor the watermark after the brightness of the region behind it
+	tintLight color.NRGBA // Color of the watermark over dark regions
+	tintDark  color.NRGBA // Color of the watermark over light regions
 }
 
 // EvaluateWatermarkOptions returns OptionsWatermark
@@ -52,3 +67,46 @@ func WatermarkOffsetY(d int) OptionWatermark {
 		o.offsetY = d
 	}
 }
+
+// WatermarkRelativeToContent returns OptionWatermark to modify WatermarkRelativeToContent
+func WatermarkRelativeToContent(b bool) OptionWatermark {
+	return func(o *OptionsWatermark) {
+		o.relativeToContent = b
+	}
+}
+
+// WatermarkBlend returns OptionWatermark to modify WatermarkBlend
+// mode is one of BlendNormal, BlendMultiply, BlendScreen and BlendOverlay, applied pixel-wise
+// against the image behind the watermark
+func WatermarkBlend(mode int) OptionWatermark {
+	return func(o *OptionsWatermark) {
+		o.blend = mode
+	}
+}
+
+// WatermarkScale returns OptionWatermark to modify WatermarkScale
+// The watermark is resized to the fraction f of the width it is positioned within, keeping its aspect ratio
+func WatermarkScale(f float64) OptionWatermark {
+	return func(o *OptionsWatermark) {
+		o.scale = f
+	}
+}
+
+// WatermarkMinSize returns OptionWatermark to modify WatermarkMinSize
+// A scaled watermark is never narrower than px pixels, keeping it legible on small images
+func WatermarkMinSize(px int) OptionWatermark {
+	return func(o *OptionsWatermark) {
+		o.minSize = px
+	}
+}
+
+// WatermarkTint returns OptionWatermark to recolor the watermark for legibility
+// The watermark takes the light color over dark regions and the dark color over
+// light ones, the brightness being sampled where the watermark lands; its alpha is kept
+func WatermarkTint(light, dark color.NRGBA) OptionWatermark {
+	return func(o *OptionsWatermark) {
+		o.tint = true
+		o.tintLight = light
+		o.tintDark = dark
+	}
+}
@@ -0,0 +1,46 @@
+package upload
+
+import (
+	"io"
+)
+
+// Orientations of an image as displayed
+const (
+	// OrientationLandscape is wider than tall
+	OrientationLandscape = iota
+	// OrientationPortrait is taller than wide
+	OrientationPortrait
+	// OrientationSquare is as wide as tall
+	OrientationSquare
+)
+
+// orientation returns the orientation of an image of the given size
+func orientation(width, height int) int {
+	switch {
+	case width > height:
+		return OrientationLandscape
+	case width < height:
+		return OrientationPortrait
+	}
+	return OrientationSquare
+}
+
+// displaySize returns the size of an image as displayed, the EXIF orientation of its JPEG
+// stream r, if any, swapping width and height for rotations of 90 or 270 degrees
+func displaySize(r io.Reader, width, height int) (int, int) {
+	exif, err := readExif(r)
+	if err != nil {
+		return width, height
+	}
+
+	ifd0, _, err := exif.ifd(exif.ifd0Offset())
+	if err != nil {
+		return width, height
+	}
+
+	// Orientations 5 to 8 are transposed
+	if value, ok := exif.uint(ifd0[exifTagOrientation]); ok && value >= 5 && value <= 8 {
+		return height, width
+	}
+	return width, height
+}
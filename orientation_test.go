@@ -0,0 +1,36 @@
+package upload
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestOrientation(t *testing.T) {
+	header := []byte{'I', 'I', 42, 0, 8, 0, 0, 0}
+	rotated := func(value byte) []byte {
+		ifd0 := buildIFD(8, []exifIFDEntry{{exifTagOrientation, exifTypeShort, 1, []byte{value, 0}}}, 0)
+		return buildExifJPEG(append(append([]byte{}, header...), ifd0...))
+	}
+
+	tests := []struct {
+		name     string
+		content  []byte
+		width    int
+		height   int
+		expected int
+	}{
+		{"Landscape", nil, 400, 300, OrientationLandscape},
+		{"Portrait", nil, 300, 400, OrientationPortrait},
+		{"Square", nil, 300, 300, OrientationSquare},
+		{"Upright", rotated(1), 400, 300, OrientationLandscape},
+		{"Upside Down", rotated(3), 400, 300, OrientationLandscape},
+		{"Rotated 90", rotated(6), 400, 300, OrientationPortrait},
+		{"Rotated 270", rotated(8), 300, 400, OrientationLandscape},
+	}
+
+	for _, tt := range tests {
+		if got := orientation(displaySize(bytes.NewReader(tt.content), tt.width, tt.height)); got != tt.expected {
+			t.Errorf("%v: expected orientation %v, got %v", tt.name, tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package upload
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// overridesFileSuffix names the overrides file of an image, e.g. photo.upload.json for photo.jpg
+	overridesFileSuffix = ".upload.json"
+)
+
+// ImageOverrides holds the settings of a single image overriding the processor options
+type ImageOverrides struct {
+	Quality int                            `json:"quality"` // JPEG quality of every format
+	Formats map[string]ImageFormatOverride `json:"formats"` // Settings by format name
+}
+
+// ImageFormatOverride holds the settings of a single format of an image
+type ImageFormatOverride struct {
+	Width   *int `json:"width"`
+	Height  *int `json:"height"`
+	Quality int  `json:"quality"`
+}
+
+// overridesFilePath returns the disk path of the overrides file of the image at imgDiskPath
+func overridesFilePath(imgDiskPath string) string {
+	return strings.TrimSuffix(imgDiskPath, filepath.Ext(imgDiskPath)) + overridesFileSuffix
+}
+
+// readImageOverrides reads the overrides file of the image at imgDiskPath
+// It returns nil if the image has no overrides file
+func readImageOverrides(imgDiskPath string) (*ImageOverrides, error) {
+	content, err := ioutil.ReadFile(overridesFilePath(imgDiskPath))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	overrides := &ImageOverrides{}
+	if err := json.Unmarshal(content, overrides); err != nil {
+		log.Printf("error decoding overrides of %v: %v\n", imgDiskPath, err)
+		return nil, err
+	}
+	return overrides, nil
+}
+
+// apply returns format with its dimensions overridden and the JPEG quality to use, 0 if not overridden
+func (o *ImageOverrides) apply(format Format) (Format, int) {
+	if o == nil {
+		return format, 0
+	}
+
+	quality := o.Quality
+	if override, ok := o.Formats[format.name]; ok {
+		if override.Width != nil {
+			format.width = *override.Width
+		}
+		if override.Height != nil {
+			format.height = *override.Height
+		}
+		if override.Quality > 0 {
+			quality = override.Quality
+		}
+	}
+	return format, quality
+}
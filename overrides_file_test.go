@@ -0,0 +1,67 @@
+package upload
+
+import (
+	"image"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOverridesFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "overrides")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content, err := ioutil.ReadFile(filepath.Join("testdata", "normal.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srcDiskPath := filepath.Join(dir, "photo.jpg")
+	if err := ioutil.WriteFile(srcDiskPath, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	overrides := `{"quality": 60, "formats": {"thumb": {"width": 50, "height": 40, "quality": 90}}}`
+	if err := ioutil.WriteFile(filepath.Join(dir, "photo.upload.json"), []byte(overrides), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	storage := newMemStorage()
+	p := NewImageProcessor(
+		Formats("thumb", 200, 200, false),
+		OverridesFile(true),
+		WithStorage(storage),
+	)
+	format, _ := p.Options().Format("thumb")
+
+	fileDiskPath, err := p.GetOrGenerate(srcDiskPath, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, _, err := image.DecodeConfig(storage.files[fileDiskPath])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Width != 50 || config.Height != 40 {
+		t.Errorf("expected overridden size 50x40, got %vx%v", config.Width, config.Height)
+	}
+
+	read, err := readImageOverrides(srcDiskPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, quality := read.apply(Format{name: "card"}); quality != 60 {
+		t.Errorf("expected image quality 60, got %v", quality)
+	}
+	if _, quality := read.apply(format); quality != 90 {
+		t.Errorf("expected format quality 90, got %v", quality)
+	}
+
+	if read, err := readImageOverrides(filepath.Join(dir, "missing.jpg")); read != nil || err != nil {
+		t.Errorf("expected no overrides for missing file, got %v: %v", read, err)
+	}
+}
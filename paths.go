@@ -0,0 +1,53 @@
+package upload
+
+import (
+	"errors"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+// ErrPathNotAllowed is returned for images or variants outside the AllowedDirs
+var ErrPathNotAllowed = errors.New("path not allowed")
+
+// allowedPath checks if path lies within one of AllowedDirs, once cleaned of ../ traversal
+// Any path is allowed when no directory is set
+func (o OptionsImage) allowedPath(path string) bool {
+	if len(o.allowedDirs) == 0 {
+		return true
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	for _, dir := range o.allowedDirs {
+		root, err := filepath.Abs(dir)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(root, abs)
+		if err != nil {
+			continue
+		}
+		if rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPaths checks that the image at imgDiskPath and its variants for formats lie within AllowedDirs
+func (o OptionsImage) checkPaths(imgDiskPath string, formats ...Format) error {
+	if !o.allowedPath(imgDiskPath) {
+		log.Printf("image %v outside allowed directories\n", imgDiskPath)
+		return ErrPathNotAllowed
+	}
+	for _, format := range formats {
+		if fileDiskPath := o.variantPath(imgDiskPath, format); !o.allowedPath(fileDiskPath) {
+			log.Printf("variant %v outside allowed directories\n", fileDiskPath)
+			return ErrPathNotAllowed
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package upload
+
+import (
+	"bytes"
+	"image/color"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestAllowedDirs(t *testing.T) {
+	options := EvaluateImageOptions(
+		Formats("thumb", 200, 200, false),
+		AllowedDirs("/srv/media"),
+	)
+	thumb, _ := options.Format("thumb")
+
+	tests := []struct {
+		path    string
+		allowed bool
+	}{
+		{"/srv/media/name.jpg", true},
+		{"/srv/media/user/../name.jpg", true},
+		{"/srv/media/../secrets/name.jpg", false},
+		{"/srv/media-other/name.jpg", false},
+		{"/etc/name.jpg", false},
+	}
+
+	for _, tt := range tests {
+		err := options.checkPaths(tt.path, thumb)
+		if tt.allowed && err != nil {
+			t.Errorf("%v: expected allowed, got %v", tt.path, err)
+		}
+		if !tt.allowed && err != ErrPathNotAllowed {
+			t.Errorf("%v: expected ErrPathNotAllowed, got %v", tt.path, err)
+		}
+	}
+
+	if err := EvaluateImageOptions().checkPaths("../name.jpg", thumb); err != nil {
+		t.Errorf("expected any path allowed without AllowedDirs, got %v", err)
+	}
+
+	options.convertedNaming = "../{base}_{format}.{ext}"
+	thumb.output = "png"
+	if err := options.checkPaths("/srv/media/name.jpg", thumb); err != ErrPathNotAllowed {
+		t.Errorf("expected variant outside allowed directories rejected, got %v", err)
+	}
+}
+
+func TestProcessSourceFormatPaths(t *testing.T) {
+	var content bytes.Buffer
+	if err := imaging.Encode(&content, imaging.New(4, 4, color.White), imaging.JPEG); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the output of the type override names the variant outside the allowed directories
+	p := NewImageProcessor(
+		Formats("thumb", 200, 200, false),
+		AllowedDirs("/srv/media"),
+		ConvertedNaming("../{base}_{format}.{ext}"),
+		SourceOverride(TypeImageJPEG, OverrideOutput("png")),
+	)
+	file := &UploadedFile{diskPath: "/srv/media/name.jpg", content: content.Bytes()}
+	if _, err := p.Process(file, false); err != ErrPathNotAllowed {
+		t.Errorf("expected ErrPathNotAllowed, got %v", err)
+	}
+}
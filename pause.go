@@ -0,0 +1,66 @@
+package upload
+
+import (
+	"sync"
+)
+
+// pauseGate holds jobs back while paused
+type pauseGate struct {
+	mu      sync.Mutex
+	resumed chan struct{} // Closed on resume, nil while running
+}
+
+func (g *pauseGate) pause() {
+	g.mu.Lock()
+	if g.resumed == nil {
+		g.resumed = make(chan struct{})
+	}
+	g.mu.Unlock()
+}
+
+func (g *pauseGate) resume() {
+	g.mu.Lock()
+	if g.resumed != nil {
+		close(g.resumed)
+		g.resumed = nil
+	}
+	g.mu.Unlock()
+}
+
+func (g *pauseGate) paused() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.resumed != nil
+}
+
+// wait blocks while paused, unless cancel is closed
+func (g *pauseGate) wait(cancel <-chan struct{}) {
+	g.mu.Lock()
+	resumed := g.resumed
+	g.mu.Unlock()
+
+	if resumed == nil {
+		return
+	}
+	select {
+	case <-resumed:
+	case <-cancel:
+	}
+}
+
+// Pause stops starting jobs, e.g. during a maintenance window
+// Jobs are still accepted and wait, holding their MaxJobs slot: once all slots are taken,
+// Process blocks and ProcessTimeout returns ErrQueueFull. Jobs already started run to completion
+func (p *ImageProcessor) Pause() {
+	p.gate.pause()
+}
+
+// Resume starts the jobs accepted while paused and the following ones
+func (p *ImageProcessor) Resume() {
+	p.gate.resume()
+}
+
+// Paused checks if the processor is paused
+func (p *ImageProcessor) Paused() bool {
+	return p.gate.paused()
+}
@@ -0,0 +1,45 @@
+package upload
+
+import (
+	"image"
+	"image/color"
+	"log"
+	"math/bits"
+
+	"github.com/disintegration/imaging"
+)
+
+// PerceptualHash returns the 64-bit difference hash (dHash) of the image at path
+// Near-duplicate images have hashes with a small Hamming distance
+func PerceptualHash(path string) (uint64, error) {
+	img, err := imaging.Open(path)
+	if err != nil {
+		log.Printf("Image error: %v\n", err)
+		return 0, err
+	}
+
+	return dHash(img), nil
+}
+
+// HammingDistance returns the number of differing bits between two perceptual hashes
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// dHash computes the difference hash of an already decoded image
+func dHash(img image.Image) uint64 {
+	small := imaging.Resize(img, 9, 8, imaging.Box)
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			left := color.GrayModel.Convert(small.At(x, y)).(color.Gray).Y
+			right := color.GrayModel.Convert(small.At(x+1, y)).(color.Gray).Y
+			hash <<= 1
+			if left > right {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
@@ -0,0 +1,359 @@
+package upload
+
+import (
+	"image"
+	"image/draw"
+	"io"
+	"log"
+	"os"
+
+	"github.com/disintegration/imaging"
+	"github.com/lsldigital/gocipe-upload/core"
+)
+
+// Pipeline applies the processing steps of a format to an image
+// Steps are chainable: once a step fails, the following ones are skipped and Err reports the failure
+type Pipeline struct {
+	options *OptionsImage
+	img     image.Image
+	srcW    int             // Width of the source image
+	srcH    int             // Height of the source image
+	content image.Rectangle // Region of the image content once composited
+	err     error
+}
+
+// NewPipeline returns a new Pipeline processing img based on specific options
+func NewPipeline(img image.Image, opts ...OptionImage) *Pipeline {
+	return newPipeline(img, EvaluateImageOptions(opts...))
+}
+
+func newPipeline(img image.Image, options *OptionsImage) *Pipeline {
+	bounds := img.Bounds()
+	return &Pipeline{
+		options: options,
+		img:     img,
+		srcW:    bounds.Dx(),
+		srcH:    bounds.Dy(),
+	}
+}
+
+// Image returns the processed image
+func (p *Pipeline) Image() image.Image {
+	return p.img
+}
+
+// Err returns the error of the first failed step, if any
+func (p *Pipeline) Err() error {
+	return p.err
+}
+
+// Apply applies a custom step to the image
+func (p *Pipeline) Apply(step func(image.Image) (image.Image, error)) *Pipeline {
+	if p.err != nil {
+		return p
+	}
+
+	p.img, p.err = step(p.img)
+	return p
+}
+
+// backdropped checks if format puts the image on a backdrop
+func (p *Pipeline) backdropped(format Format) bool {
+	landscape := p.srcH < p.srcW
+	return _diskPathBackdrop != "" && format.backdrop && !landscape && !format.native() && format.maxLongSide <= 0
+}
+
+// Resize resizes the image to the dimensions of format without upscaling
+// Backdropped formats are only scaled down to fit, to be composited by Backdrop,
+// MaxLongSide formats to fit their longer side and formats without dimensions keep
+// the size of the source
+func (p *Pipeline) Resize(format Format) *Pipeline {
+	if p.err != nil {
+		return p
+	}
+
+	if format.maxLongSide > 0 {
+		bounds := p.img.Bounds()
+		width, height := format.longSideSize(bounds.Dx(), bounds.Dy())
+		if width != bounds.Dx() || height != bounds.Dy() {
+			p.img = resize(p.img, width, height, p.options.filter, p.options.fastThumbnail)
+		}
+		return p
+	}
+	if format.native() {
+		return p
+	}
+
+	// Prepare metra for processing
+	newWidth := format.width
+	newHeight := format.height
+
+	// Do not upscale unless allowed for the axis
+	if format.width > p.srcW && !format.upscaleWidth {
+		newWidth = p.srcW
+	}
+	if format.height > p.srcH && !format.upscaleHeight {
+		newHeight = p.srcH
+	}
+
+	// -1 pixel size does not exist
+	if format.width < 0 {
+		newWidth = 0
+	}
+	if format.height < 0 {
+		newHeight = 0
+	}
+
+	preserveAspect := newWidth <= 0 || newHeight <= 0
+
+	if p.backdropped(format) {
+		// Do not crop and resize when using backdrop but downscale
+		// Scale down srcImage to fit the bounding box
+		p.img = imaging.Fit(p.img, newWidth, newHeight, p.options.filter)
+	} else if preserveAspect {
+		// Resize srcImage to proper width or height preserving the aspect ratio.
+		p.img = resize(p.img, newWidth, newHeight, p.options.filter, p.options.fastThumbnail)
+	} else if format.focal {
+		// Crop around the focal point then resize to the [newWidth x newHeight] area
+		p.img = fillFocal(p.img, newWidth, newHeight, format.focalX, format.focalY, p.options.filter, p.options.fastThumbnail)
+	} else {
+		// Resize and crop the image to fill the [newWidth x newHeight] area
+		p.img = fill(p.img, newWidth, newHeight, p.options.filter, p.options.fastThumbnail)
+	}
+
+	return p
+}
+
+// Backdrop composites the image in the center of the backdrop of format
+func (p *Pipeline) Backdrop(format Format) *Pipeline {
+	if p.err != nil || !p.backdropped(format) {
+		return p
+	}
+
+	var err error
+
+	// Open a new image to use as backdrop layer
+	var back image.Image
+	if core.Env == core.EnvironmentDEV {
+		back, err = imaging.Open(_diskPathBackdrop + ":" + format.name)
+	} else {
+		var staticAsset *os.File
+		staticAsset, err = _assetBox.Open(_diskPathBackdrop + ":" + format.name)
+		if err == nil {
+			defer staticAsset.Close()
+			back, _, err = image.Decode(staticAsset)
+		}
+	}
+
+	if err != nil {
+		// if err, fall back to a solid color backdrop
+		backW, backH := format.BackdropSize()
+		back = imaging.New(backW, backH, p.options.backdropColor)
+	} else {
+		// Resize and crop backdrop accordingly
+		back = imaging.Fill(back, format.width, format.height, imaging.Center, p.options.filter)
+	}
+
+	// Track where the image lands on the backdrop layer
+	backBounds := back.Bounds()
+	imgW, imgH := p.img.Bounds().Dx(), p.img.Bounds().Dy()
+	contentMin := image.Pt(backBounds.Min.X+backBounds.Dx()/2-imgW/2, backBounds.Min.Y+backBounds.Dy()/2-imgH/2)
+	p.content = image.Rectangle{Min: contentMin, Max: contentMin.Add(image.Pt(imgW, imgH))}
+
+	// Overlay image in center on backdrop layer
+	img := p.img
+	if p.options.backdropFeather > 0 {
+		img = feather(img, p.options.backdropFeather)
+	}
+	p.img = imaging.OverlayCenter(back, img, 1.0)
+
+	return p
+}
+
+// feather softens the transparent edges of img, its alpha being lowered to a blur of itself
+// of radius sigma so that edges fade inwards without revealing hidden colors
+func feather(img image.Image, sigma float64) image.Image {
+	if isOpaque(img) {
+		return img
+	}
+
+	feathered := imaging.Clone(img)
+	alpha := image.NewNRGBA(feathered.Bounds())
+	for i := 3; i < len(feathered.Pix); i += 4 {
+		alpha.Pix[i-3], alpha.Pix[i] = feathered.Pix[i], 255
+	}
+	blurred := imaging.Blur(alpha, sigma)
+	for i := 3; i < len(feathered.Pix); i += 4 {
+		if a := blurred.Pix[i-3]; a < feathered.Pix[i] {
+			feathered.Pix[i] = a
+		}
+	}
+	return feathered
+}
+
+// Scrim overlays the gradient scrim of format on the image
+func (p *Pipeline) Scrim(format Format) *Pipeline {
+	if p.err != nil || format.scrim == nil {
+		return p
+	}
+
+	p.img = format.scrim.apply(p.img)
+	return p
+}
+
+// Watermark overlays the watermark of format on the image
+func (p *Pipeline) Watermark(format Format) *Pipeline {
+	if p.err != nil || _diskPathWatermark == "" || format.watermark == nil || p.img.Bounds().Dx() < p.options.watermarkMinWidth {
+		return p
+	}
+
+	var (
+		watermark image.Image
+		err       error
+	)
+	if core.Env == core.EnvironmentDEV {
+		watermark, err = imaging.Open(_diskPathWatermark + ":" + format.name)
+	} else {
+		var staticAsset *os.File
+		staticAsset, err = _assetBox.Open(_diskPathWatermark + ":" + format.name)
+		if err != nil {
+			log.Printf("Watermark not found: %v", err)
+			p.err = err
+			return p
+		}
+		defer staticAsset.Close()
+		watermark, _, err = image.Decode(staticAsset)
+	}
+	if err != nil {
+		return p
+	}
+
+	bgBounds := p.img.Bounds()
+	if format.watermark.relativeToContent && !p.content.Empty() {
+		bgBounds = p.content
+	}
+	bgW := bgBounds.Dx()
+	bgH := bgBounds.Dy()
+
+	if format.watermark.scale > 0 {
+		watermark = scaleWatermark(watermark, bgW, format.watermark.scale, format.watermark.minSize, p.options.filter)
+	}
+
+	watermarkBounds := watermark.Bounds()
+	watermarkW := watermarkBounds.Dx()
+	watermarkH := watermarkBounds.Dy()
+
+	var watermarkPos image.Point
+
+	switch format.watermark.horizontal {
+	default:
+		format.watermark.horizontal = Left
+		fallthrough
+	case Left:
+		watermarkPos.X = bgBounds.Min.X + format.watermark.offsetX
+	case Right:
+		RightX := bgBounds.Min.X + bgW - watermarkW
+		watermarkPos.X = RightX - format.watermark.offsetX
+	case Center:
+		CenterX := bgBounds.Min.X + bgW/2
+		watermarkPos.X = CenterX - watermarkW/2 + format.watermark.offsetX
+	}
+
+	switch format.watermark.vertical {
+	default:
+		format.watermark.vertical = Top
+		fallthrough
+	case Top:
+		watermarkPos.Y = bgBounds.Min.Y + format.watermark.offsetY
+	case Bottom:
+		BottomY := bgBounds.Min.Y + bgH - watermarkH
+		watermarkPos.Y = BottomY - format.watermark.offsetY
+	case Center:
+		CenterY := bgBounds.Min.Y + bgH/2
+		watermarkPos.Y = CenterY - watermarkH/2 + format.watermark.offsetY
+	}
+
+	if format.watermark.tint {
+		region := image.Rectangle{Min: watermarkPos, Max: watermarkPos.Add(watermarkBounds.Size())}
+		tint := format.watermark.tintLight
+		if brightness(p.img, region) > 0.5 {
+			tint = format.watermark.tintDark
+		}
+		watermark = tintImage(watermark, tint)
+	}
+
+	p.img = blend(p.img, watermark, watermarkPos, format.watermark.blend)
+
+	return p
+}
+
+// scaleWatermark resizes watermark to the fraction scale of width, but not narrower than minSize
+func scaleWatermark(watermark image.Image, width int, scale float64, minSize int, filter imaging.ResampleFilter) image.Image {
+	newWidth := int(float64(width)*scale + 0.5)
+	if newWidth < minSize {
+		newWidth = minSize
+	}
+	if newWidth <= 0 || newWidth == watermark.Bounds().Dx() {
+		return watermark
+	}
+
+	return imaging.Resize(watermark, newWidth, 0, filter)
+}
+
+// Flatten composites a transparent image over FlattenColor when encoding to a format without alpha
+func (p *Pipeline) Flatten(f imaging.Format) *Pipeline {
+	// JPEG has no alpha channel: flatten transparency over a solid color
+	if p.err != nil || f != imaging.JPEG || isOpaque(p.img) {
+		return p
+	}
+
+	bounds := p.img.Bounds()
+	back := imaging.New(bounds.Dx(), bounds.Dy(), p.options.flattenColor)
+	p.img = imaging.Overlay(back, p.img, image.Pt(0, 0), 1.0)
+
+	return p
+}
+
+// Grayscale converts the image to luminance when format is Grayscale
+// Opaque images become single channel *image.Gray, encoded as such; others keep their alpha
+func (p *Pipeline) Grayscale(format Format) *Pipeline {
+	if p.err != nil || !format.grayscale {
+		return p
+	}
+
+	if !isOpaque(p.img) {
+		p.img = imaging.Grayscale(p.img)
+		return p
+	}
+
+	bounds := p.img.Bounds()
+	gray := image.NewGray(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(gray, gray.Bounds(), p.img, bounds.Min, draw.Src)
+	p.img = gray
+
+	return p
+}
+
+// Quantize reduces the image to a palette when encoding to PNG or GIF and QuantizeColors is set
+func (p *Pipeline) Quantize(f imaging.Format) *Pipeline {
+	if p.err != nil || p.options.quantizeColors <= 0 || (f != imaging.PNG && f != imaging.GIF) {
+		return p
+	}
+
+	colors := p.options.quantizeColors
+	if colors > 256 {
+		colors = 256
+	}
+	p.img = quantize(p.img, colors, p.options.quantizer, p.options.quantizeDither)
+
+	return p
+}
+
+// Encode flattens and encodes the image to w
+func (p *Pipeline) Encode(w io.Writer, f imaging.Format, opts ...imaging.EncodeOption) error {
+	if p.Flatten(f); p.err != nil {
+		return p.err
+	}
+
+	return imaging.Encode(w, p.img, f, opts...)
+}
@@ -0,0 +1,307 @@
+package upload
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestScaleWatermark(t *testing.T) {
+	watermark := imaging.New(100, 50, color.NRGBA{255, 255, 255, 255})
+
+	tests := []struct {
+		width   int
+		scale   float64
+		minSize int
+		want    image.Rectangle
+	}{
+		{1000, 0.2, 40, image.Rect(0, 0, 200, 100)},
+		{100, 0.2, 40, image.Rect(0, 0, 40, 20)},
+		{100, 0.2, 0, image.Rect(0, 0, 20, 10)},
+		{500, 0.2, 0, image.Rect(0, 0, 100, 50)},
+	}
+
+	for _, test := range tests {
+		scaled := scaleWatermark(watermark, test.width, test.scale, test.minSize, imaging.Lanczos)
+		if scaled.Bounds() != test.want {
+			t.Errorf("width %d, scale %v, min %d: expected %v, got %v", test.width, test.scale, test.minSize, test.want, scaled.Bounds())
+		}
+	}
+}
+
+func TestBackdropFallback(t *testing.T) {
+	oldBackdrop := _diskPathBackdrop
+	defer func() { _diskPathBackdrop = oldBackdrop }()
+	_diskPathBackdrop = "testdata/missing_backdrop.png"
+
+	// Portrait image, smaller than the format
+	img := imaging.New(50, 100, color.NRGBA{255, 0, 0, 255})
+
+	options := EvaluateImageOptions(
+		Formats("default", 200, 200, true),
+		Formats("canvas", 200, 200, true),
+		FormatOptions("canvas", BackdropSize(300, 250)),
+	)
+
+	tests := []struct {
+		format string
+		want   image.Rectangle
+	}{
+		{"default", image.Rect(0, 0, 200, 200)},
+		{"canvas", image.Rect(0, 0, 300, 250)},
+	}
+
+	for _, test := range tests {
+		format, _ := options.Format(test.format)
+		pipeline := newPipeline(img, options).Resize(format).Backdrop(format)
+		if err := pipeline.Err(); err != nil {
+			t.Fatal(err)
+		}
+
+		backdropped := pipeline.Image()
+		if backdropped.Bounds() != test.want {
+			t.Errorf("%s: expected %v, got %v", test.format, test.want, backdropped.Bounds())
+		}
+		if !isOpaque(backdropped) {
+			t.Errorf("%s: expected an opaque fallback backdrop", test.format)
+		}
+		if c := color.NRGBAModel.Convert(backdropped.At(0, 0)); c != options.backdropColor {
+			t.Errorf("%s: expected backdrop color %v, got %v", test.format, options.backdropColor, c)
+		}
+	}
+}
+
+func TestWatermarkRelativeToContent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watermark")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldBackdrop, oldWatermark := _diskPathBackdrop, _diskPathWatermark
+	defer func() { _diskPathBackdrop, _diskPathWatermark = oldBackdrop, oldWatermark }()
+	_diskPathBackdrop = filepath.Join(dir, "missing_backdrop.png")
+	_diskPathWatermark = filepath.Join(dir, "watermark.png")
+
+	green := color.NRGBA{0, 255, 0, 255}
+	for _, name := range []string{"topleft", "bottomright", "frame"} {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, imaging.New(10, 10, green)); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(_diskPathWatermark+":"+name, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Portrait image fit in the middle of a 200x200 backdrop, at 75,50-125,150
+	img := imaging.New(50, 100, color.NRGBA{255, 0, 0, 255})
+	options := EvaluateImageOptions(
+		Formats("topleft", 200, 200, true, WatermarkHorizontal(Left), WatermarkVertical(Top), WatermarkRelativeToContent(true)),
+		Formats("bottomright", 200, 200, true, WatermarkHorizontal(Right), WatermarkVertical(Bottom), WatermarkRelativeToContent(true)),
+		Formats("frame", 200, 200, true, WatermarkHorizontal(Left), WatermarkVertical(Top)),
+	)
+
+	tests := []struct {
+		format    string
+		watermark image.Rectangle
+	}{
+		{"topleft", image.Rect(75, 50, 85, 60)},
+		{"bottomright", image.Rect(115, 140, 125, 150)},
+		{"frame", image.Rect(0, 0, 10, 10)},
+	}
+
+	for _, test := range tests {
+		format, _ := options.Format(test.format)
+		pipeline := newPipeline(img, options)
+		pipeline.srcW, pipeline.srcH = 50, 100
+		watermarked := pipeline.Resize(format).Backdrop(format).Watermark(format)
+		if err := watermarked.Err(); err != nil {
+			t.Fatal(err)
+		}
+
+		result := watermarked.Image()
+		for _, pt := range []image.Point{test.watermark.Min, test.watermark.Max.Sub(image.Pt(1, 1))} {
+			if c := color.NRGBAModel.Convert(result.At(pt.X, pt.Y)); c != green {
+				t.Errorf("%s: expected watermark at %v, got %v", test.format, pt, c)
+			}
+		}
+		// Outside of the watermark, the content or the bars are left as is
+		outside := test.watermark.Min.Sub(image.Pt(1, 1))
+		if test.watermark.Min == image.Pt(0, 0) {
+			outside = test.watermark.Max
+		}
+		if c := color.NRGBAModel.Convert(result.At(outside.X, outside.Y)); c == green {
+			t.Errorf("%s: expected no watermark at %v", test.format, outside)
+		}
+	}
+}
+
+func TestFlattenColor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flatten")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	baseDiskPath := filepath.Join(dir, "transparent.png")
+	if err := imaging.Save(imaging.New(40, 40, color.NRGBA{0, 0, 0, 0}), baseDiskPath); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		opts []OptionImage
+		want color.NRGBA
+	}{
+		{"default", nil, color.NRGBA{255, 255, 255, 255}},
+		{"custom", []OptionImage{FlattenColor(color.NRGBA{200, 0, 0, 255})}, color.NRGBA{200, 0, 0, 255}},
+	}
+
+	for _, test := range tests {
+		opts := append([]OptionImage{
+			Formats(test.name, 20, 20, false),
+			FormatOptions(test.name, OutputFormat("jpg")),
+		}, test.opts...)
+		p := NewImageProcessor(opts...)
+		format, _ := p.options.Format(test.name)
+		fileDiskPath, err := p.GetOrGenerate(baseDiskPath, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		img, err := imaging.Open(fileDiskPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := color.NRGBAModel.Convert(img.At(10, 10)).(color.NRGBA)
+		// JPEG is lossy
+		for i, pair := range [][2]uint8{{c.R, test.want.R}, {c.G, test.want.G}, {c.B, test.want.B}} {
+			if diff := int(pair[0]) - int(pair[1]); diff < -4 || diff > 4 {
+				t.Errorf("%s: expected flattened over %v, got %v (channel %d)", test.name, test.want, c, i)
+				break
+			}
+		}
+	}
+}
+
+func TestScrim(t *testing.T) {
+	img := imaging.New(10, 11, color.NRGBA{255, 255, 255, 255})
+	options := EvaluateImageOptions(
+		Formats("hero", 10, 11, false),
+		FormatOptions("hero", Scrim(Bottom, color.NRGBA{0, 0, 0, 255}, 1, 0)),
+	)
+	format, _ := options.Format("hero")
+
+	scrimmed := newPipeline(img, options).Scrim(format).Image()
+
+	// Opaque black at the bottom, untouched at the top, half way in the middle
+	tests := []struct {
+		y    int
+		want uint8
+	}{
+		{10, 0},
+		{5, 128},
+		{0, 255},
+	}
+	for _, test := range tests {
+		for x := 0; x < 10; x++ {
+			c := color.NRGBAModel.Convert(scrimmed.At(x, test.y)).(color.NRGBA)
+			if c.R != test.want || c.G != test.want || c.B != test.want || c.A != 255 {
+				t.Fatalf("pixel %d,%d: expected gray %d, got %v", x, test.y, test.want, c)
+			}
+		}
+	}
+
+	// Gradients run horizontally for Left and Right
+	format.scrim = &scrim{side: Left, color: color.NRGBA{0, 0, 0, 255}, from: 1, to: 0}
+	scrimmed = newPipeline(img, options).Scrim(format).Image()
+	if c := color.NRGBAModel.Convert(scrimmed.At(0, 5)).(color.NRGBA); c.R != 0 {
+		t.Errorf("expected black on the left, got %v", c)
+	}
+	if c := color.NRGBAModel.Convert(scrimmed.At(9, 5)).(color.NRGBA); c.R != 255 {
+		t.Errorf("expected white on the right, got %v", c)
+	}
+}
+
+func TestGrayscale(t *testing.T) {
+	img := imaging.New(40, 30, color.NRGBA{200, 40, 40, 255})
+	options := EvaluateImageOptions(
+		Formats("print", 40, 30, false),
+		FormatOptions("print", Grayscale(true)),
+	)
+	format, _ := options.Format("print")
+
+	var buf bytes.Buffer
+	pipeline := newPipeline(img, options).Flatten(imaging.JPEG).Grayscale(format)
+	if err := pipeline.Encode(&buf, imaging.JPEG); err != nil {
+		t.Fatal(err)
+	}
+
+	// Single component JPEGs decode to *image.Gray
+	decoded, err := jpeg.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := decoded.(*image.Gray); !ok {
+		t.Errorf("expected single component JPEG, decoded %T", decoded)
+	}
+
+	buf.Reset()
+	transparent := imaging.New(40, 30, color.NRGBA{200, 40, 40, 128})
+	pipeline = newPipeline(transparent, options).Grayscale(format)
+	if err := pipeline.Encode(&buf, imaging.PNG); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err = png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, g, b, a := decoded.At(0, 0).RGBA(); r != g || g != b || a == 0xffff {
+		t.Errorf("expected gray pixel with alpha kept, got %v %v %v %v", r, g, b, a)
+	}
+}
+
+func TestBackdropFeather(t *testing.T) {
+	oldBackdrop := _diskPathBackdrop
+	defer func() { _diskPathBackdrop = oldBackdrop }()
+	_diskPathBackdrop = "testdata/missing_backdrop.png"
+
+	// Portrait image, transparent on its left half, centered at (75, 50) on the backdrop
+	img := imaging.New(50, 100, color.NRGBA{255, 0, 0, 255})
+	img = imaging.Paste(img, imaging.New(25, 100, color.NRGBA{0, 0, 0, 0}), image.Pt(0, 0))
+
+	red := color.NRGBA{255, 0, 0, 255}
+	tests := []struct {
+		feather float64
+		edge    bool // Whether the first opaque column keeps its color
+	}{
+		{0, true},
+		{2, false},
+	}
+
+	for _, test := range tests {
+		options := EvaluateImageOptions(Formats("default", 200, 200, true), BackdropFeather(test.feather))
+		format, _ := options.Format("default")
+		out := newPipeline(img, options).Resize(format).Backdrop(format).Image()
+
+		edge := color.NRGBAModel.Convert(out.At(100, 100)).(color.NRGBA)
+		if (edge == red) != test.edge {
+			t.Errorf("feather %v: unexpected edge pixel %v", test.feather, edge)
+		}
+		if inner := color.NRGBAModel.Convert(out.At(120, 100)); inner != red {
+			t.Errorf("feather %v: expected inner pixel unchanged, got %v", test.feather, inner)
+		}
+		if outer := color.NRGBAModel.Convert(out.At(80, 100)); outer != options.backdropColor {
+			t.Errorf("feather %v: expected backdrop outside the image, got %v", test.feather, outer)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package upload
+
+import (
+	"image"
+	"log"
+
+	"github.com/disintegration/imaging"
+)
+
+// writePlaceholders writes a cheap placeholder at the variant path of every format of job
+// Placeholders of the downsample strategy are resized from src, decoded within DecodeMemory if nil
+func (p *ImageProcessor) writePlaceholders(job *Job, formats []Format) {
+	if p.options.placeholder == PlaceholderDownsample && job.src == nil {
+		reserved := reserve(decodedSize(job.Config))
+		src, err := p.openSource(job, reserved)
+		reserved.release()
+		if err != nil {
+			log.Printf("Image placeholder error: %v\n", err)
+			return
+		}
+		// Kept for the job so that the source is decoded once
+		job.src = src
+	}
+
+	for _, format := range formats {
+		width, height := placeholderSize(format, job.Config.Width, job.Config.Height)
+
+		var img image.Image
+		switch p.options.placeholder {
+		case PlaceholderSolid:
+			img = imaging.New(width, height, p.options.placeholderColor)
+		case PlaceholderDownsample:
+			img = imaging.Fill(toRGB(job.src), width, height, imaging.Center, imaging.Box)
+		default:
+			return
+		}
+
+		imagingFormat, err := p.options.outputFormat(job.File.DiskPath(), format)
+		if err != nil {
+			log.Printf("Image get format error: %v", err)
+			continue
+		}
+
+		w, err := p.options.storage.Create(p.options.variantPath(job.File.DiskPath(), format))
+		if err != nil {
+			log.Printf("Image placeholder error: %v\n", err)
+			continue
+		}
+		if err := imaging.Encode(w, img, imagingFormat); err != nil {
+			log.Printf("Image placeholder error: %v\n", err)
+			w.Abort()
+			continue
+		}
+		if err := w.Close(); err != nil {
+			log.Printf("Image placeholder error: %v\n", err)
+		}
+	}
+}
+
+// placeholderSize returns the size of the variant of format for a source of the given size
+func placeholderSize(format Format, srcW, srcH int) (int, int) {
+	if format.maxLongSide > 0 {
+		return format.longSideSize(srcW, srcH)
+	}
+
+	width, height := format.width, format.height
+	if width > srcW && !format.upscaleWidth {
+		width = srcW
+	}
+	if height > srcH && !format.upscaleHeight {
+		height = srcH
+	}
+
+	switch {
+	case width <= 0 && height <= 0:
+		return srcW, srcH
+	case width <= 0:
+		width = srcW * height / srcH
+	case height <= 0:
+		height = srcH * width / srcW
+	}
+
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+	return width, height
+}
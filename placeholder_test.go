@@ -0,0 +1,76 @@
+package upload
+
+import (
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestPlaceholderSize(t *testing.T) {
+	tests := []struct {
+		name          string
+		format        Format
+		width, height int
+	}{
+		{"Fill", Format{width: 200, height: 100}, 200, 100},
+		{"Width Only", Format{width: 200}, 200, 150},
+		{"Height Only", Format{height: 300}, 400, 300},
+		{"No Upscale", Format{width: 1000, height: 100}, 800, 100},
+		{"Upscale Width", Format{width: 1000, height: 100, upscaleWidth: true}, 1000, 100},
+	}
+
+	for _, tt := range tests {
+		width, height := placeholderSize(tt.format, 800, 600)
+		if width != tt.width || height != tt.height {
+			t.Errorf("%v: expected %vx%v, got %vx%v", tt.name, tt.width, tt.height, width, height)
+		}
+	}
+}
+
+func TestPlaceholderDecodeBudget(t *testing.T) {
+	dir, err := ioutil.TempDir("", "placeholder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	diskPath := filepath.Join(dir, "a.jpg")
+	if err := imaging.Save(imaging.New(40, 40, color.White), diskPath); err != nil {
+		t.Fatal(err)
+	}
+
+	DecodeMemory(1)
+	defer DecodeMemory(0)
+	held := reserve(1)
+
+	p := NewImageProcessor(Formats("thumb", 10, 10, false), Placeholder(PlaceholderDownsample))
+	job := &Job{File: &UploadedFile{diskPath: diskPath}, Config: &image.Config{Width: 40, Height: 40}}
+	done := make(chan struct{})
+	go func() {
+		p.writePlaceholders(job, p.options.formats)
+		close(done)
+	}()
+
+	// The downsample waits for the memory of its decode
+	select {
+	case <-done:
+		t.Fatal("expected the placeholder decode to wait for the budget")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	held.release()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the placeholder written once the budget is released")
+	}
+	if _, err := os.Stat(diskPath + ":thumb"); err != nil {
+		t.Errorf("expected placeholder written: %v", err)
+	}
+}
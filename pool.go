@@ -0,0 +1,45 @@
+package upload
+
+import (
+	"runtime"
+)
+
+var (
+	// _workers bounds the formats processed concurrently across all jobs
+	_workers = newWorkerPool(runtime.NumCPU())
+)
+
+// Workers sets the number of formats processed concurrently across all jobs
+// (default: number of CPUs)
+func Workers(n int) {
+	_workers.resize(n)
+}
+
+// workerPool bounds the number of tasks running concurrently
+// Tasks of the high lane waiting for a worker run before those of the normal lane
+type workerPool struct {
+	sem *laneSemaphore
+}
+
+func newWorkerPool(n int) *workerPool {
+	return &workerPool{sem: newLaneSemaphore(n)}
+}
+
+// resize changes the pool size, taking effect as workers free up
+func (w *workerPool) resize(n int) {
+	w.sem.resize(n)
+}
+
+// submit blocks until a worker is free then runs fn on it
+func (w *workerPool) submit(fn func()) {
+	w.submitLane(laneNormal, fn)
+}
+
+// submitLane blocks until a worker is free for lane then runs fn on it
+func (w *workerPool) submitLane(lane int, fn func()) {
+	w.sem.acquire(lane, -1)
+	go func() {
+		defer w.sem.release()
+		fn()
+	}()
+}
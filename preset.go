@@ -0,0 +1,64 @@
+package upload
+
+import (
+	"log"
+	"sync"
+)
+
+var (
+	// Registry of named image options
+	_presets   = make(map[string]*OptionsImage)
+	_presetsMu sync.RWMutex
+)
+
+// RegisterPreset registers image options under name for reuse
+// Registering an existing name replaces the preset
+func RegisterPreset(name string, opts ...OptionImage) {
+	options := EvaluateImageOptions(opts...)
+
+	_presetsMu.Lock()
+	_presets[name] = options
+	_presetsMu.Unlock()
+}
+
+// Preset returns a copy of the image options registered under name
+func Preset(name string) (*OptionsImage, bool) {
+	_presetsMu.RLock()
+	options, ok := _presets[name]
+	_presetsMu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+
+	return options.copy(), true
+}
+
+// WithPreset returns a function to replace options image by the preset registered under name
+// Options following it are applied on top of the preset
+func WithPreset(name string) OptionImage {
+	return func(o *OptionsImage) {
+		preset, ok := Preset(name)
+		if !ok {
+			log.Printf("preset %v not found\n", name)
+			return
+		}
+		*o = *preset
+	}
+}
+
+// copy returns a copy of options image not sharing formats
+func (o *OptionsImage) copy() *OptionsImage {
+	optCopy := &OptionsImage{}
+	*optCopy = *o
+
+	optCopy.formats = make([]Format, len(o.formats))
+	for i, format := range o.formats {
+		if format.watermark != nil {
+			watermark := *format.watermark
+			format.watermark = &watermark
+		}
+		optCopy.formats[i] = format
+	}
+
+	return optCopy
+}
@@ -0,0 +1,32 @@
+package upload
+
+import (
+	"testing"
+)
+
+func TestPreset(t *testing.T) {
+	RegisterPreset("avatar", Formats("small", 50, 50, false, WatermarkHorizontal(Center)))
+
+	preset, ok := Preset("avatar")
+	if !ok {
+		t.Fatal("preset not registered")
+	}
+
+	// Mutating a returned preset must not affect the registry
+	preset.formats[0].width = 100
+	preset.formats[0].watermark.horizontal = Right
+
+	again, _ := Preset("avatar")
+	if again.formats[0].width != 50 || again.formats[0].watermark.horizontal != Center {
+		t.Errorf("preset mutated: %+v", again.formats[0])
+	}
+
+	options := EvaluateImageOptions(WithPreset("avatar"), MinWidth(10))
+	if len(options.formats) != 1 || options.minWidth != 10 {
+		t.Errorf("unexpected options from preset: %+v", options)
+	}
+
+	if _, ok := Preset("unknown"); ok {
+		t.Error("unknown preset found")
+	}
+}
@@ -2,14 +2,21 @@ package upload
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"log"
 	"image"
-	"image/color"
 	"image/gif"
 	"image/jpeg"
 	"image/png"
+	"io"
+	"io/ioutil"
+	"log"
 	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/disintegration/imaging"
 	"github.com/lsldigital/gocipe-upload/core"
@@ -33,6 +40,92 @@ const (
 	Center
 )
 
+var (
+	// deterministicEncodeOptions pins the encoder settings of deterministic output
+	deterministicEncodeOptions = []imaging.EncodeOption{
+		imaging.JPEGQuality(95),
+		imaging.PNGCompressionLevel(png.DefaultCompression),
+	}
+)
+
+var (
+	// ErrJobCancelled is reported by a job cancelled before all formats were processed
+	ErrJobCancelled = errors.New("job cancelled")
+
+	// ErrFormatSkipped is reported when a format is deliberately not generated
+	ErrFormatSkipped = errors.New("format skipped")
+
+	// ErrDeadlineExceeded is reported by a job aborted at its deadline
+	ErrDeadlineExceeded = errors.New("job deadline exceeded")
+
+	// ErrPalettedSource is returned for paletted images with PalettedReject
+	ErrPalettedSource = errors.New("paletted image not allowed")
+
+	// ErrQueueFull is returned when a job cannot start before its timeout
+	ErrQueueFull = errors.New("queue full")
+)
+
+// FormatError records the failure to generate the variant of a format
+type FormatError struct {
+	Name string // Format name
+	Path string // Disk path of the source image
+	Err  error
+}
+
+func (e *FormatError) Error() string {
+	return e.Name + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *FormatError) Unwrap() error {
+	return e.Err
+}
+
+// Reasons a format is skipped
+const (
+	// SkippedInvalid reports a format with invalid dimensions, a misconfiguration
+	SkippedInvalid = "invalid"
+	// SkippedSmaller reports a format larger than the source, per SkipIfSmaller
+	SkippedSmaller = "smaller"
+)
+
+// FormatSkipped is emitted when a format of an image is not generated on purpose
+type FormatSkipped struct {
+	Name   string // Format name
+	Path   string // Disk path of the source image
+	Reason string // SkippedInvalid or SkippedSmaller
+	Err    error  // Validation error of invalid formats
+}
+
+// Variant describes a variant written by a job
+type Variant struct {
+	Name             string  // Format name
+	Path             string  // Disk path of the variant
+	Width            int     // Width in pixels
+	Height           int     // Height in pixels
+	Bytes            int64   // Size of the encoded variant
+	CompressionRatio float64 // Bytes over the size of the decoded pixels (4 bytes each)
+
+	DecodeTime time.Duration // Time taken to decode the source, shared by the variants of a job
+	ResizeTime time.Duration // Time taken to resize and composite the variant
+	EncodeTime time.Duration // Time taken to encode and store the variant
+
+	PreviewPath string // Disk path of the preview of the variant with PreviewSize, if written
+	Location    string // Final URL or key of the variant in its Storage, e.g. of an object store
+
+	clamped *ClampedFormat
+}
+
+// ClampedFormat records a variant generated smaller than its format requested,
+// the source being too small to fill it without upscaling
+type ClampedFormat struct {
+	Name         string // Format name
+	Width        int    // Requested width, 0 if free
+	Height       int    // Requested height, 0 if free
+	ActualWidth  int    // Width of the variant
+	ActualHeight int    // Height of the variant
+}
+
 var (
 	// Disk paths to static assets
 	_diskPathWatermark string
@@ -44,9 +137,33 @@ var (
 
 // Job represents current image file being processed
 type Job struct {
-	File	Uploaded
-	Config	*image.Config
-	Done 	chan struct{}
+	File           Uploaded
+	Config         *image.Config
+	Type           string
+	LQIP           string
+	PerceptualHash uint64
+	CaptureTime    time.Time
+	Animated       bool
+	Orientation    int
+	Deadline       time.Time
+	Skipped        []string
+	Failed         []*FormatError
+	Clamped        []*ClampedFormat
+	Variants       []*Variant
+	Err            error
+	Done           chan struct{}
+
+	src            image.Image // Decoded source, if already decoded
+	srcDecodeTime  time.Duration
+	formats        []Format // Formats of the job, depending on animation
+	watermarkFunc  WatermarkFunc
+	cropResolver   CropResolver
+	idempotencyKey string
+	highPriority   bool
+	inMemory       bool // Source decoded from content, not on disk
+	cancel         chan struct{}
+	started        time.Time
+	cancelOnce     sync.Once
 }
 
 type assetBoxer interface {
@@ -75,8 +192,13 @@ func AssetBox(assetBox assetBoxer) {
 }
 
 // ImageProcessor implements the processor interface
-type ImageProcessor struct{
+type ImageProcessor struct {
 	options *OptionsImage
+	flight  *flightGroup
+	jobs    *jobRegistry
+	cache   *sourceCache
+	slots   *laneSemaphore // Jobs in progress, if limited
+	gate    *pauseGate
 }
 
 // NewImageProcessor returns a new ImageProcessor
@@ -84,6 +206,15 @@ func NewImageProcessor(opts ...OptionImage) *ImageProcessor {
 	options := EvaluateImageOptions(opts...)
 	processor := &ImageProcessor{
 		options: options,
+		flight:  newFlightGroup(),
+		jobs:    newJobRegistry(),
+		gate:    &pauseGate{},
+	}
+	if options.sourceCache > 0 {
+		processor.cache = newSourceCache(options.sourceCache)
+	}
+	if options.maxJobs > 0 {
+		processor.slots = newLaneSemaphore(options.maxJobs)
 	}
 
 	return processor
@@ -95,18 +226,104 @@ func (p ImageProcessor) Options() OptionsImage {
 }
 
 // Process adds a job to process an image based on specific options
-func (p *ImageProcessor) Process(file Uploaded, validate bool) (*Job, error) {
+// It blocks while MaxJobs jobs are in progress
+func (p *ImageProcessor) Process(file Uploaded, validate bool, opts ...OptionJob) (*Job, error) {
+	return p.add(file, validate, -1, opts...)
+}
+
+// ProcessPriority adds a job like Process in the high priority lane, e.g. for a user waiting
+// It starts (MaxJobs) and gets its formats processed (Workers) before jobs added by Process
+func (p *ImageProcessor) ProcessPriority(file Uploaded, validate bool, opts ...OptionJob) (*Job, error) {
+	return p.add(file, validate, -1, append(opts, highPriority)...)
+}
+
+// highPriority puts a job in the high priority lane
+func highPriority(j *Job) {
+	j.highPriority = true
+}
+
+// lane returns the lane of the job
+func (j *Job) lane() int {
+	if j.highPriority {
+		return laneHigh
+	}
+	return laneNormal
+}
+
+// ProcessTimeout adds a job like Process, returning ErrQueueFull if MaxJobs jobs
+// are still in progress after timeout
+func (p *ImageProcessor) ProcessTimeout(file Uploaded, validate bool, timeout time.Duration, opts ...OptionJob) (*Job, error) {
+	return p.add(file, validate, timeout, opts...)
+}
+
+// add validates file and starts its job once a slot is free, waiting at most timeout if not negative
+func (p *ImageProcessor) add(file Uploaded, validate bool, timeout time.Duration, opts ...OptionJob) (*Job, error) {
 	content := file.Content()
-	if !isValidImage(content) {
+	if len(content) == 0 {
+		return nil, ErrEmptyUpload
+	}
+
+	if !p.options.isImage(content) {
 		return nil, fmt.Errorf("image type invalid")
 	}
 
-	config, _, err := image.DecodeConfig(bytes.NewReader(content))
+	// Animated sources may have their own formats
+	animated := isAnimated(content)
+	formats := p.options.formats
+	if animated && p.options.animatedFormats != nil {
+		formats = p.options.animatedFormats
+	}
+
+	if p.options.maxFormats != core.NoLimit && len(formats) > p.options.maxFormats {
+		log.Printf("image %v has too many formats: %d\n", file.DiskPath(), len(formats))
+		return nil, fmt.Errorf("%d formats exceed max of %d formats", len(formats), p.options.maxFormats)
+	}
+
+	if p.options.onInvalidFormat == InvalidFormatAbort {
+		for _, format := range formats {
+			if err := format.validate(); err != nil {
+				log.Printf("image %v invalid format: %v\n", file.DiskPath(), err)
+				return nil, err
+			}
+		}
+	}
+
+	config, imgType, err := image.DecodeConfig(bytes.NewReader(content))
 	if err != nil {
 		log.Printf("error decoding image: %v", err)
 		return nil, err
 	}
 
+	// Variants are written at the paths of the formats for the source type
+	formats = p.options.sourceFormats(imgType, formats)
+	if err := p.options.checkPaths(file.DiskPath(), formats...); err != nil {
+		return nil, err
+	}
+
+	if p.options.palettedSources == PalettedReject && isPaletted(config.ColorModel) {
+		log.Printf("image %v is paletted\n", file.DiskPath())
+		return nil, ErrPalettedSource
+	}
+
+	// Headers alone do not reveal truncated files
+	var (
+		src        image.Image
+		decodeTime time.Duration
+	)
+	if p.options.verifyDecode {
+		start := time.Now()
+		reserved := reserve(decodedSize(&config))
+		src, err = decodeTimeout(p.options.decodeTimeout, reserved, func() (image.Image, error) {
+			return imaging.Decode(bytes.NewReader(content))
+		})
+		reserved.release()
+		if err != nil {
+			log.Printf("error decoding image %v: %v\n", file.DiskPath(), err)
+			return nil, fmt.Errorf("image incomplete: %v", err)
+		}
+		decodeTime = time.Since(start)
+	}
+
 	// Check min width and height
 	if validate && p.options.minWidth != core.NoLimit && config.Width < p.options.minWidth {
 		log.Printf("image %v lower than min width: %v\n", file.DiskPath(), p.options.minWidth)
@@ -119,170 +336,857 @@ func (p *ImageProcessor) Process(file Uploaded, validate bool) (*Job, error) {
 	}
 
 	job := &Job{
-		File:	file,
-		Config:	&config,
-		Done: 	make(chan struct{}),
+		File:          file,
+		Config:        &config,
+		Type:          imgType,
+		Animated:      animated,
+		Orientation:   orientation(displaySize(bytes.NewReader(content), config.Width, config.Height)),
+		Done:          make(chan struct{}),
+		src:           src,
+		srcDecodeTime: decodeTime,
+		formats:       formats,
+		cancel:        make(chan struct{}),
+	}
+	for _, o := range opts {
+		o(job)
+	}
+
+	if job.idempotencyKey != "" && p.options.idempotencyStore != nil {
+		processed, err := p.options.idempotencyStore.Has(job.idempotencyKey)
+		if err != nil {
+			log.Printf("error checking idempotency key %v: %v\n", job.idempotencyKey, err)
+			return nil, err
+		}
+		if processed {
+			log.Printf("image %v not processed: key %v already processed\n", file.DiskPath(), job.idempotencyKey)
+			return nil, ErrAlreadyProcessed
+		}
+	}
+
+	// The latest submission replaces the job in progress for the same image
+	if p.options.latestWins {
+		for _, old := range p.jobs.get(file.DiskPath()) {
+			log.Printf("image %v resubmitted, cancelling job in progress\n", file.DiskPath())
+			old.cancelOnce.Do(func() {
+				close(old.cancel)
+			})
+			p.jobs.wait(old)
+		}
 	}
-	
+
+	if !p.acquireSlot(job.lane(), timeout) {
+		log.Printf("image %v not processed: queue full\n", file.DiskPath())
+		return nil, ErrQueueFull
+	}
+
+	if p.options.placeholder != PlaceholderNone && p.options.writePolicy == WriteOverwrite {
+		p.writePlaceholders(job, p.filterFormats(job.formats, nil))
+	}
+
+	p.jobs.add(job)
+
 	go p.process(job)
 
 	return job, nil
 }
 
-func (p *ImageProcessor) process(job *Job) {
-	var (
-		img image.Image
-		err error
-	)
+// GetOrGenerate returns the disk path of the variant of an image for a specific format
+// The variant is generated synchronously if it does not exist yet
+func (p *ImageProcessor) GetOrGenerate(baseDiskPath string, format Format) (string, error) {
+	if format.name == "" {
+		return "", fmt.Errorf("format name empty")
+	}
+	if err := p.options.checkPaths(baseDiskPath, format); err != nil {
+		return "", err
+	}
 
-	for _, format := range p.options.formats {
-		if format.name == "" {
-			continue
+	fileDiskPath := p.options.variantPath(baseDiskPath, format)
+
+	// Concurrent requests for the same variant share a single generation
+	return p.flight.do(fileDiskPath, func() (string, error) {
+		if _, err := os.Stat(fileDiskPath); err == nil {
+			return fileDiskPath, nil
 		}
 
-		imgDiskPath := job.File.DiskPath()
+		file, err := os.Open(baseDiskPath)
+		if err != nil {
+			log.Printf("error opening %v: %v\n", baseDiskPath, err)
+			return "", err
+		}
+		defer file.Close()
 
-		img, err = imaging.Open(imgDiskPath)
+		config, imgType, err := image.DecodeConfig(file)
 		if err != nil {
-			log.Printf("Image error: %v\n", err)
-			continue
+			log.Printf("error decoding image: %v", err)
+			return "", err
 		}
 
-		// Prepare metra for processing
-		newWidth := format.width
-		newHeight := format.height
+		reserved := reserve(decodedSize(&config))
+		defer reserved.release()
 
-		// Do not upscale
-		if format.width > job.Config.Width {
-			newWidth = job.Config.Width
+		source, err := p.diskSource(baseDiskPath, &config, imgType, reserved)
+		if err != nil {
+			return "", err
 		}
-		if format.height > job.Config.Height {
-			newHeight = job.Config.Height
+
+		if _, err := p.processFormat(source, format); err != nil {
+			if err == ErrFormatSkipped {
+				return "", err
+			}
+			return "", &FormatError{Name: format.name, Path: baseDiskPath, Err: err}
 		}
 
-		// -1 pixel size does not exist
-		if format.width < 0 {
-			newWidth = 0
+		return fileDiskPath, nil
+	})
+}
+
+// ProcessFormats regenerates the variants of the image at baseDiskPath for the formats named
+// in only, e.g. after the options of one format changed, leaving other variants as is
+// Every name must be a format of the processor; the source is decoded once for all of them
+func (p *ImageProcessor) ProcessFormats(baseDiskPath string, only []string) error {
+	if len(only) == 0 {
+		return fmt.Errorf("no format to process")
+	}
+
+	formats := make([]Format, 0, len(only))
+	for _, name := range only {
+		format, ok := p.options.Format(name)
+		if !ok {
+			log.Printf("image %v unknown format: %v\n", baseDiskPath, name)
+			return fmt.Errorf("format %v not found", name)
 		}
-		if format.height < 0 {
-			newHeight = 0
+		if err := format.validate(); err != nil {
+			log.Printf("image %v invalid format: %v\n", baseDiskPath, err)
+			return err
 		}
+		formats = append(formats, format)
+	}
+	if err := p.options.checkPaths(baseDiskPath, formats...); err != nil {
+		return err
+	}
 
-		landscape := job.Config.Height < job.Config.Width
-		preserveAspect := newWidth <= 0 || newHeight <= 0
+	file, err := os.Open(baseDiskPath)
+	if err != nil {
+		log.Printf("error opening %v: %v\n", baseDiskPath, err)
+		return err
+	}
+	config, imgType, err := image.DecodeConfig(file)
+	file.Close()
+	if err != nil {
+		log.Printf("error decoding image: %v", err)
+		return err
+	}
 
-		// Do not crop and resize when using backdrop but downscale
-		if _diskPathBackdrop != "" && format.backdrop && !landscape {
-			// Scale down srcImage to fit the bounding box
-			img = imaging.Fit(img, newWidth, newHeight, imaging.Lanczos)
+	reserved := reserve(decodedSize(&config))
+	defer reserved.release()
 
-			// Open a new image to use as backdrop layer
-			var back image.Image
-			if core.Env == core.EnvironmentDEV {
-				back, err = imaging.Open(_diskPathBackdrop + ":" + format.name)
-			} else {
-				var staticAsset *os.File
-				staticAsset, err = _assetBox.Open(_diskPathBackdrop + ":" + format.name)
-				if err != nil {
-					// if err, fall back to a blue background backdrop
-					back = imaging.New(format.width, format.height, color.NRGBA{0, 29, 56, 0})
-				}
-				defer staticAsset.Close()
-				back, _, err = image.Decode(staticAsset)
-			}
+	source, err := p.diskSource(baseDiskPath, &config, imgType, reserved)
+	if err != nil {
+		return err
+	}
 
-			if err != nil {
-				// if err, fall back to a blue background backdrop
-				back = imaging.New(format.width, format.height, color.NRGBA{0, 29, 56, 0})
-			} else {
-				// Resize and crop backdrop accordingly
-				back = imaging.Fill(back, format.width, format.height, imaging.Center, imaging.Lanczos)
-			}
+	for _, format := range p.options.sourceFormats(imgType, formats) {
+		if _, err := p.processFormat(source, format); err != nil && err != ErrFormatSkipped {
+			log.Printf("image %v format %v error: %v\n", baseDiskPath, format.name, err)
+			return &FormatError{Name: format.name, Path: baseDiskPath, Err: err}
+		}
+	}
+	return nil
+}
+
+// diskSource decodes the image at baseDiskPath, of config and imgType, into the source of its variants
+func (p *ImageProcessor) diskSource(baseDiskPath string, config *image.Config, imgType string, reserved *reservation) (*source, error) {
+	start := time.Now()
+	src, err := p.open(baseDiskPath, reserved)
+	if err != nil {
+		log.Printf("Image error: %v\n", err)
+		return nil, err
+	}
 
-			// Overlay image in center on backdrop layer
-			img = imaging.OverlayCenter(back, img, 1.0)
-		} else if preserveAspect {
-			// Resize srcImage to proper width or height preserving the aspect ratio.
-			img = imaging.Resize(img, newWidth, newHeight, imaging.Lanczos)
-		} else {
-			// Resize and crop the image to fill the [newWidth x newHeight] area
-			img = imaging.Fill(img, newWidth, newHeight, imaging.Center, imaging.Lanczos)
-		}
-
-		if _diskPathWatermark != "" && format.watermark != nil {
-			var watermark image.Image
-			if core.Env == core.EnvironmentDEV {
-				watermark, err = imaging.Open(_diskPathWatermark + ":" + format.name)
-			} else {
-				var staticAsset *os.File
-				staticAsset, err = _assetBox.Open(_diskPathWatermark + ":" + format.name)
-				if err != nil {
-					log.Printf("Watermark not found: %v", err)
-					continue
-				}
-				defer staticAsset.Close()
-				watermark, _, err = image.Decode(staticAsset)
+	src = toRGB(src)
+	if p.options.palettedSources == PalettedConvert {
+		src = fromPalette(src)
+	}
+	if p.options.convertSRGB && imgType == TypeImageJPEG {
+		if content, err := ioutil.ReadFile(baseDiskPath); err == nil {
+			src = convertToSRGB(src, content)
+		}
+	}
+
+	source := &source{
+		diskPath:   baseDiskPath,
+		img:        src,
+		config:     config,
+		imgType:    imgType,
+		decodeTime: time.Since(start),
+	}
+	if p.options.exifThumbnail {
+		source.thumb = p.exifThumbnail(baseDiskPath)
+	}
+	if p.options.overridesFile {
+		source.overrides, _ = readImageOverrides(baseDiskPath)
+	}
+	return source, nil
+}
+
+// Cancel aborts the remaining formats of the jobs processing fileDiskPath
+// Variants already written by the jobs are removed and the jobs report ErrJobCancelled
+func (p *ImageProcessor) Cancel(fileDiskPath string) bool {
+	jobs := p.jobs.get(fileDiskPath)
+	for _, job := range jobs {
+		job := job
+		job.cancelOnce.Do(func() {
+			close(job.cancel)
+		})
+	}
+	return len(jobs) > 0
+}
+
+// Drain waits for all jobs in progress to complete and returns how many completed
+// It returns early with ctx error if ctx is done first
+func (p *ImageProcessor) Drain(ctx context.Context) (int, error) {
+	return p.jobs.drain(ctx)
+}
+
+func (p *ImageProcessor) process(job *Job) {
+	// Abort the job at its deadline
+	if !job.Deadline.IsZero() {
+		timer := time.AfterFunc(time.Until(job.Deadline), func() {
+			job.cancelOnce.Do(func() {
+				close(job.cancel)
+			})
+		})
+		defer timer.Stop()
+	}
+
+	// Hold the job back while paused
+	p.gate.wait(job.cancel)
+
+	// Reserve the memory of the decoded source
+	reserved := reserve(decodedSize(job.Config))
+
+	// Decode source once for all formats
+	start := time.Now()
+	src := job.src
+	if src == nil {
+		var err error
+		src, err = p.openSource(job, reserved)
+		if err != nil {
+			log.Printf("Image error: %v\n", err)
+			job.Err = err
+			reserved.release()
+			p.releaseSlot()
+			p.jobs.remove(job)
+			job.Done <- struct{}{}
+			return
+		}
+	}
+	job.src = nil
+	src = toRGB(src)
+	if p.options.palettedSources == PalettedConvert {
+		src = fromPalette(src)
+	}
+	if p.options.convertSRGB && job.Type == TypeImageJPEG {
+		src = convertToSRGB(src, job.File.Content())
+	}
+
+	source := &source{
+		diskPath:   job.File.DiskPath(),
+		img:        src,
+		config:     job.Config,
+		imgType:    job.Type,
+		decodeTime: job.srcDecodeTime + time.Since(start),
+
+		watermarkFunc: job.watermarkFunc,
+		cropResolver:  job.cropResolver,
+	}
+	if p.options.exifThumbnail {
+		source.thumb = p.exifThumbnail(job.File.DiskPath())
+	}
+	if p.options.overridesFile {
+		// Invalid overrides files are logged and ignored
+		source.overrides, _ = readImageOverrides(job.File.DiskPath())
+	}
+
+	if p.options.lqip > 0 {
+		if lqip, err := lqipFromImage(src, p.options.lqip); err == nil {
+			job.LQIP = lqip
+		}
+	}
+
+	if p.options.perceptualHash {
+		job.PerceptualHash = dHash(src)
+	}
+
+	if p.options.readCaptureTime {
+		if captured, ok, err := captureTime(bytes.NewReader(job.File.Content())); err == nil && ok {
+			job.CaptureTime = captured
+		}
+	}
+
+	// Process formats on the shared worker pool
+	formats := p.filterFormats(job.formats, func(format Format, err error) {
+		p.formatSkipped(FormatSkipped{Name: format.name, Path: job.File.DiskPath(), Reason: SkippedInvalid, Err: err})
+	})
+	results := make([]error, len(formats))
+	variants := make([]*Variant, len(formats))
+	var wg sync.WaitGroup
+	for i, format := range formats {
+		i, format := i, format
+		wg.Add(1)
+		_workers.submitLane(job.lane(), func() {
+			defer wg.Done()
+
+			select {
+			case <-job.cancel:
+				results[i] = ErrJobCancelled
+				return
+			default:
 			}
-			if err == nil {
-				bgBounds := img.Bounds()
-				bgW := bgBounds.Dx()
-				bgH := bgBounds.Dy()
-
-				watermarkBounds := watermark.Bounds()
-				watermarkW := watermarkBounds.Dx()
-				watermarkH := watermarkBounds.Dy()
-
-				var watermarkPos image.Point
-
-				switch format.watermark.horizontal {
-				default:
-					format.watermark.horizontal = Left
-					fallthrough
-				case Left:
-					watermarkPos.X += format.watermark.offsetX
-				case Right:
-					RightX := bgBounds.Min.X + bgW - watermarkW
-					watermarkPos.X = RightX - format.watermark.offsetX
-				case Center:
-					CenterX := bgBounds.Min.X + bgW/2
-					watermarkPos.X = CenterX - watermarkW/2 + format.watermark.offsetX
-				}
-
-				switch format.watermark.vertical {
-				default:
-					format.watermark.vertical = Top
-					fallthrough
-				case Top:
-					watermarkPos.Y += format.watermark.offsetY
-				case Bottom:
-					BottomY := bgBounds.Min.Y + bgH - watermarkH
-					watermarkPos.Y = BottomY - format.watermark.offsetY
-				case Center:
-					CenterY := bgBounds.Min.Y + bgH/2
-					watermarkPos.Y = CenterY - watermarkH/2 + format.watermark.offsetY
-				}
-
-				img = imaging.Overlay(img, watermark, watermarkPos, 1.0)
+
+			variants[i], results[i] = p.processFormat(source, format)
+		})
+	}
+	wg.Wait()
+
+	var (
+		written   []string
+		failed    []string
+		cancelled bool
+	)
+	for i, format := range formats {
+		switch err := results[i]; err {
+		case nil:
+			written = append(written, p.options.variantPath(job.File.DiskPath(), format))
+			job.Variants = append(job.Variants, variants[i])
+			if variants[i].clamped != nil {
+				job.Clamped = append(job.Clamped, variants[i].clamped)
 			}
+		case ErrFormatSkipped:
+			job.Skipped = append(job.Skipped, format.name)
+			p.formatSkipped(FormatSkipped{Name: format.name, Path: job.File.DiskPath(), Reason: SkippedSmaller})
+		case ErrJobCancelled:
+			cancelled = true
+		default:
+			formatErr := &FormatError{Name: format.name, Path: job.File.DiskPath(), Err: err}
+			job.Failed = append(job.Failed, formatErr)
+			failed = append(failed, formatErr.Error())
+		}
+
+		// Placeholders are only replaced by variants written
+		if results[i] != nil && p.options.placeholder != PlaceholderNone {
+			p.options.storage.Delete(p.options.variantPath(job.File.DiskPath(), format))
 		}
+	}
 
-		imagingFormat, err := imaging.FormatFromFilename(imgDiskPath)
+	switch {
+	case cancelled:
+		log.Printf("job %v cancelled\n", job.File.DiskPath())
+		p.deleteVariants(written)
+		job.Err = ErrJobCancelled
+		if !job.Deadline.IsZero() && !time.Now().Before(job.Deadline) {
+			job.Err = ErrDeadlineExceeded
+		}
+	case p.options.atomic && len(failed) > 0:
+		log.Printf("job %v failed, removing variants\n", job.File.DiskPath())
+		p.deleteVariants(written)
+		job.Err = fmt.Errorf("job failed: %s", strings.Join(failed, "; "))
+	}
+
+	if job.Err == nil && len(job.Failed) == 0 && job.idempotencyKey != "" && p.options.idempotencyStore != nil {
+		if err := p.options.idempotencyStore.Set(job.idempotencyKey); err != nil {
+			log.Printf("error recording idempotency key %v: %v\n", job.idempotencyKey, err)
+		}
+	}
+
+	reserved.release()
+	p.releaseSlot()
+	p.jobs.remove(job)
+	job.Done <- struct{}{}
+}
+
+// acquireSlot reserves a job slot in lane, waiting at most timeout if not negative
+func (p *ImageProcessor) acquireSlot(lane int, timeout time.Duration) bool {
+	if p.slots == nil {
+		return true
+	}
+	return p.slots.acquire(lane, timeout)
+}
+
+// releaseSlot frees the slot of a job done
+func (p *ImageProcessor) releaseSlot() {
+	if p.slots != nil {
+		p.slots.release()
+	}
+}
+
+// validFormats returns the formats to process according to the invalid format policy,
+// highest priority first
+func (p *ImageProcessor) validFormats() []Format {
+	return p.filterFormats(p.options.formats, nil)
+}
+
+// filterFormats returns the valid formats among all, highest priority first,
+// reporting invalid ones to invalid if not nil
+func (p *ImageProcessor) filterFormats(all []Format, invalid func(Format, error)) []Format {
+	var formats []Format
+	for _, format := range all {
+		var err error
+		switch p.options.onInvalidFormat {
+		case InvalidFormatSkip:
+			err = format.validate()
+		case InvalidFormatClamp:
+			format, err = format.clamp()
+		}
 		if err != nil {
-			log.Printf("Image get format error: %v", err)
+			log.Printf("Skipping invalid format: %v\n", err)
+			if invalid != nil {
+				invalid(format, err)
+			}
 			continue
 		}
 
-		outputFile, err := os.Create(imgDiskPath + ":" + format.name)
-		if err != nil {
-			log.Printf("Image get format error: %v", err)
+		if format.name == "" {
 			continue
 		}
-		defer outputFile.Close()
 
-		if err := imaging.Encode(outputFile, img, imagingFormat); err != nil {
-			log.Printf("Image encode format error: %v", err)
+		formats = append(formats, format)
+	}
+
+	sort.SliceStable(formats, func(i, j int) bool {
+		return formats[i].priority > formats[j].priority
+	})
+	return formats
+}
+
+// formatSkipped emits event to OnFormatSkipped, if set
+func (p *ImageProcessor) formatSkipped(event FormatSkipped) {
+	if p.options.onFormatSkipped != nil {
+		p.options.onFormatSkipped(event)
+	}
+}
+
+// deleteVariants removes variants written to storage
+func (p *ImageProcessor) deleteVariants(written []string) {
+	for _, fileDiskPath := range written {
+		if err := p.options.storage.Delete(fileDiskPath); err != nil {
+			log.Printf("error deleting %v: %v\n", fileDiskPath, err)
+		}
+		if p.options.writeSidecar {
+			p.options.storage.Delete(fileDiskPath + ".json")
 		}
+		p.options.storage.Delete(previewPath(fileDiskPath))
 	}
+}
 
-	job.Done <- struct{}{}
-}
\ No newline at end of file
+// open decodes the source image at path, from the source cache if enabled, within reserved if not nil
+func (p *ImageProcessor) open(path string, reserved *reservation) (image.Image, error) {
+	if p.cache == nil {
+		return p.decode(path, reserved)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return p.decode(path, reserved)
+	}
+	if img, ok := p.cache.get(path, info.ModTime()); ok {
+		return img, nil
+	}
+
+	img, err := p.decode(path, reserved)
+	if err != nil {
+		return img, err
+	}
+	p.cache.add(path, info.ModTime(), img)
+	return img, nil
+}
+
+// decode decodes the source image at path, retrying on failure as configured
+func (p *ImageProcessor) decode(path string, reserved *reservation) (image.Image, error) {
+	backoff := p.options.openBackoff
+	for attempt := 0; ; attempt++ {
+		img, err := decodeTimeout(p.options.decodeTimeout, reserved, func() (image.Image, error) {
+			return imaging.Open(path)
+		})
+		if err == nil || attempt >= p.options.openRetries {
+			return img, err
+		}
+
+		// Only failures to open the file are transient, not decoding errors
+		if _, ok := err.(*os.PathError); !ok {
+			return img, err
+		}
+
+		if core.Env == core.EnvironmentDEV {
+			log.Printf("retrying open of %v in %v: %v\n", path, backoff, err)
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// source holds a decoded source image
+type source struct {
+	diskPath string
+	img      image.Image
+	config   *image.Config
+	imgType  string
+	thumb    image.Image // Embedded EXIF thumbnail, if any
+
+	decodeTime time.Duration // Time taken to decode the source
+
+	watermarkFunc WatermarkFunc
+	cropResolver  CropResolver
+	overrides     *ImageOverrides // Settings of the image overriding the options, if any
+}
+
+// crop returns the region of the source resolved for format, if any
+func (src *source) crop(format Format) (image.Rectangle, bool) {
+	if src.cropResolver == nil {
+		return image.Rectangle{}, false
+	}
+	region, ok := src.cropResolver(*src.config, format)
+	if !ok {
+		return image.Rectangle{}, false
+	}
+	if region.Empty() || !region.In(src.img.Bounds()) {
+		log.Printf("ignoring crop %v of %v for format %v: outside source %v\n", region, src.diskPath, format.name, src.img.Bounds())
+		return image.Rectangle{}, false
+	}
+	return region, true
+}
+
+// exifThumbnail returns the embedded EXIF thumbnail of the image at path, if any
+func (p *ImageProcessor) exifThumbnail(path string) image.Image {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	thumb, err := exifThumbnail(file)
+	if err != nil {
+		return nil
+	}
+	return toRGB(thumb)
+}
+
+// processFormat generates the variant of an image for a specific format
+// It returns the Variant written, clamped when smaller than the format requested
+func (p *ImageProcessor) processFormat(src *source, format Format) (*Variant, error) {
+	imgDiskPath := src.diskPath
+	imgType := src.imgType
+	config := src.config
+
+	format, quality := src.overrides.apply(format)
+	format = p.options.capFormat(format)
+
+	if format.skipIfSmaller && format.smallerSource(config.Width, config.Height) {
+		return nil, ErrFormatSkipped
+	}
+
+	if src.watermarkFunc != nil {
+		format.watermark = src.watermarkFunc(format)
+	}
+
+	resizeStart := time.Now()
+	pipeline := newPipeline(src.img, p.options)
+	pipeline.srcW, pipeline.srcH = config.Width, config.Height
+
+	// Resize the region resolved for the format, or else from the embedded thumbnail
+	// when it is large enough for the format
+	if region, ok := src.crop(format); ok {
+		pipeline = newPipeline(imaging.Crop(src.img, region), p.options)
+	} else if src.thumb != nil && format.coveredBy(src.thumb.Bounds().Dx(), src.thumb.Bounds().Dy()) {
+		pipeline = newPipeline(src.thumb, p.options)
+	}
+	if err := pipeline.Resize(format).Backdrop(format).Scrim(format).Watermark(format).Err(); err != nil {
+		return nil, err
+	}
+
+	imagingFormat, err := p.options.outputFormat(imgDiskPath, format)
+	if err != nil {
+		log.Printf("Image get format error: %v", err)
+		return nil, err
+	}
+
+	img := pipeline.Flatten(imagingFormat).Grayscale(format).Quantize(imagingFormat).Image()
+	resizeTime := time.Since(resizeStart)
+	encodeStart := time.Now()
+
+	var encodeOpts []imaging.EncodeOption
+	if p.options.deterministic {
+		encodeOpts = append(encodeOpts, deterministicEncodeOptions...)
+	}
+
+	// Qualities of the image prevail over the type Override, then over the curve
+	if override := p.options.Override(imgType); quality <= 0 && override != nil && override.quality > 0 {
+		quality = override.quality
+	}
+	if quality <= 0 && p.options.qualityFunc != nil {
+		quality = p.options.qualityFunc(img.Bounds().Dx(), img.Bounds().Dy())
+	}
+
+	if quality > 0 {
+		// Quality set for the image, its type or the curve is final
+		encodeOpts = append(encodeOpts, imaging.JPEGQuality(quality))
+	} else if p.options.targetSSIM > 0 && imagingFormat == imaging.JPEG {
+		quality, err := searchJPEGQuality(img, p.options.targetSSIM)
+		if err != nil {
+			log.Printf("Image quality search error: %v", err)
+			return nil, err
+		}
+		encodeOpts = append(encodeOpts, imaging.JPEGQuality(quality))
+	}
+
+	outputFile, err := p.createVariant(p.options.variantPath(imgDiskPath, format))
+	if err != nil {
+		log.Printf("Image get format error: %v", err)
+		return nil, err
+	}
+
+	counter := &countingWriter{w: outputFile}
+	var w io.Writer = counter
+	if format.dpi > 0 {
+		w = newDensityWriter(counter, imagingFormat, format.dpi)
+	}
+	if err := pipeline.Encode(w, imagingFormat, encodeOpts...); err != nil {
+		log.Printf("Image encode format error: %v", err)
+		outputFile.Abort()
+		return nil, err
+	}
+
+	if err := outputFile.Close(); err != nil {
+		log.Printf("Image store format error: %v", err)
+		return nil, err
+	}
+	encodeTime := time.Since(encodeStart)
+
+	var preview string
+	if format.previewSize > 0 {
+		preview = previewPath(p.options.variantPath(imgDiskPath, format))
+		if err := p.writePreview(preview, img, format.previewSize, imagingFormat, encodeOpts); err != nil {
+			log.Printf("Image preview error: %v", err)
+			preview = ""
+		}
+	}
+
+	if p.options.writeSidecar {
+		sidecar := newSidecar(format, img, imagingFormat, counter.n)
+		if err := writeSidecar(p.options.storage, p.options.variantPath(imgDiskPath, format), sidecar); err != nil {
+			log.Printf("Image sidecar error: %v", err)
+		}
+	}
+
+	if _, onDisk := p.options.storage.(*DiskStorage); onDisk && p.options.preserveModTime {
+		if info, err := os.Stat(imgDiskPath); err == nil {
+			if err := os.Chtimes(p.options.variantPath(imgDiskPath, format), info.ModTime(), info.ModTime()); err != nil {
+				log.Printf("Image mod time error: %v", err)
+			}
+		}
+	}
+
+	variant := newVariant(format, p.options.variantPath(imgDiskPath, format), img, counter.n)
+	variant.DecodeTime, variant.ResizeTime, variant.EncodeTime = src.decodeTime, resizeTime, encodeTime
+	variant.PreviewPath = preview
+	variant.Location = outputFile.Location()
+	return variant, nil
+}
+
+// writePreview encodes img scaled down to fit size x size pixels to fileDiskPath
+func (p *ImageProcessor) writePreview(fileDiskPath string, img image.Image, size int, f imaging.Format, opts []imaging.EncodeOption) error {
+	preview := imaging.Fit(img, size, size, p.options.filter)
+
+	w, err := p.createVariant(fileDiskPath)
+	if err != nil {
+		return err
+	}
+	if err := newPipeline(preview, p.options).Encode(w, f, opts...); err != nil {
+		w.Abort()
+		return err
+	}
+	return w.Close()
+}
+
+// capFormat clamps the dimensions of format to MaxOutputDimension
+func (o *OptionsImage) capFormat(format Format) Format {
+	max := o.maxOutput
+	if max <= 0 || (format.width <= max && format.height <= max && format.maxLongSide <= max) {
+		return format
+	}
+
+	log.Printf("clamping format %v %vx%v to max output dimension %v\n", format.name, format.width, format.height, max)
+	if format.maxLongSide > max {
+		format.maxLongSide = max
+	}
+	if format.width > max {
+		format.width = max
+	}
+	if format.height > max {
+		format.height = max
+	}
+	return format
+}
+
+// newVariant returns the Variant of format encoded from img in size bytes
+func newVariant(format Format, fileDiskPath string, img image.Image, size int64) *Variant {
+	bounds := img.Bounds()
+	variant := &Variant{
+		Name:    format.name,
+		Path:    fileDiskPath,
+		Width:   bounds.Dx(),
+		Height:  bounds.Dy(),
+		Bytes:   size,
+		clamped: clampedFormat(format, img),
+	}
+	if pixels := bounds.Dx() * bounds.Dy(); pixels > 0 {
+		variant.CompressionRatio = float64(size) / float64(pixels*4)
+	}
+	return variant
+}
+
+// clampedFormat returns the ClampedFormat of a variant smaller than format requested, if any
+func clampedFormat(format Format, img image.Image) *ClampedFormat {
+	actualW, actualH := img.Bounds().Dx(), img.Bounds().Dy()
+	if (format.width <= 0 || actualW >= format.width) && (format.height <= 0 || actualH >= format.height) {
+		return nil
+	}
+
+	clamped := &ClampedFormat{
+		Name:         format.name,
+		ActualWidth:  actualW,
+		ActualHeight: actualH,
+	}
+	if format.width > 0 {
+		clamped.Width = format.width
+	}
+	if format.height > 0 {
+		clamped.Height = format.height
+	}
+	return clamped
+}
+
+// isOpaque checks if img has no transparent pixels
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return true
+}
+
+// variantPath returns the disk path of the variant of an image for a specific format
+// when encoded in the format of the image
+func variantPath(imgDiskPath string, format Format) string {
+	return imgDiskPath + ":" + format.name
+}
+
+// jobRegistry holds the jobs in progress by disk path
+// Several jobs may process the same disk path, e.g. an image uploaded twice
+type jobRegistry struct {
+	mu        sync.Mutex
+	jobs      map[string][]*Job
+	pending   int // Jobs in progress for all disk paths
+	completed int
+	errored   int
+	elapsed   time.Duration // Total time of completed jobs
+	changed   chan struct{}
+}
+
+func newJobRegistry() *jobRegistry {
+	return &jobRegistry{
+		jobs:    make(map[string][]*Job),
+		changed: make(chan struct{}),
+	}
+}
+
+func (r *jobRegistry) add(job *Job) {
+	r.mu.Lock()
+	job.started = time.Now()
+	r.jobs[job.File.DiskPath()] = append(r.jobs[job.File.DiskPath()], job)
+	r.pending++
+	r.mu.Unlock()
+}
+
+// get returns the jobs in progress for fileDiskPath, oldest first
+func (r *jobRegistry) get(fileDiskPath string) []*Job {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*Job(nil), r.jobs[fileDiskPath]...)
+}
+
+// inProgress checks if job is still registered; the lock must be held
+func (r *jobRegistry) inProgress(job *Job) bool {
+	for _, j := range r.jobs[job.File.DiskPath()] {
+		if j == job {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *jobRegistry) remove(job *Job) {
+	r.mu.Lock()
+	jobs := r.jobs[job.File.DiskPath()]
+	for i, j := range jobs {
+		if j == job {
+			jobs = append(jobs[:i:i], jobs[i+1:]...)
+			r.pending--
+			break
+		}
+	}
+	if len(jobs) == 0 {
+		delete(r.jobs, job.File.DiskPath())
+	} else {
+		r.jobs[job.File.DiskPath()] = jobs
+	}
+	r.completed++
+	r.elapsed += time.Since(job.started)
+	if job.Err != nil {
+		r.errored++
+	}
+	// Wake up anyone draining
+	close(r.changed)
+	r.changed = make(chan struct{})
+	r.mu.Unlock()
+}
+
+// wait waits until job is no longer in progress
+func (r *jobRegistry) wait(job *Job) {
+	for {
+		r.mu.Lock()
+		pending := r.inProgress(job)
+		changed := r.changed
+		r.mu.Unlock()
+
+		if !pending {
+			return
+		}
+		<-changed
+	}
+}
+
+// drain waits until no job is in progress
+func (r *jobRegistry) drain(ctx context.Context) (int, error) {
+	r.mu.Lock()
+	start := r.completed
+	r.mu.Unlock()
+
+	for {
+		r.mu.Lock()
+		pending := r.pending
+		drained := r.completed - start
+		changed := r.changed
+		r.mu.Unlock()
+
+		if pending == 0 {
+			log.Printf("drained %d jobs\n", drained)
+			return drained, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return drained, ctx.Err()
+		case <-changed:
+		}
+	}
+}
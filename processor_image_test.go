@@ -2,15 +2,28 @@ package upload_test
 
 // Basic imports
 import (
-	"path/filepath"
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"image"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/fstest"
 	"time"
 
-	"github.com/stretchr/testify/suite"
-	"github.com/lsldigital/gocipe-upload/core"
+	"github.com/disintegration/imaging"
 	"github.com/lsldigital/gocipe-upload"
+	"github.com/lsldigital/gocipe-upload/core"
+	"github.com/stretchr/testify/suite"
 )
 
 type mockAssetBoxer struct{}
@@ -25,9 +38,9 @@ func (m *mockAssetBoxer) Open(name string) (*os.File, error) {
 
 type imageProcessTest struct {
 	name                 string
-	prod 				 bool
-	inputFile 			 string
-	expectedFile		 string
+	prod                 bool
+	inputFile            string
+	expectedFile         string
 	expectedProcessError bool
 	processor            *upload.ImageProcessor
 }
@@ -59,6 +72,9 @@ func (s *ProcessorTestSuite) SetupSuite() {
 		{"Normal Upscale", false, "normal.jpg", "upscale_normal_out.jpg", false, upload.NewImageProcessor(upload.Formats("upscale", 500, 500, false))},
 		{"Small Width", false, "normal.jpg", "min_normal_out.jpg", true, upload.NewImageProcessor(upload.MinWidth(500))},
 		{"Small Height", false, "normal.jpg", "min_normal_out.jpg", true, upload.NewImageProcessor(upload.MinHeight(500))},
+		{"Too Many Formats", false, "normal.jpg", "format_normal_out.jpg", true, upload.NewImageProcessor(upload.MaxFormats(1), upload.Formats("thumb", 200, 200, false), upload.Formats("neg", -1, -1, false))},
+		{"Invalid Format Abort", false, "normal.jpg", "format_normal_out.jpg", true, upload.NewImageProcessor(upload.OnInvalidFormat(upload.InvalidFormatAbort), upload.Formats("neg", -1, -1, false))},
+		{"Truncated Verify Decode", false, "truncated.jpg", "format_normal_out.jpg", true, upload.NewImageProcessor(upload.VerifyDecode(true), upload.Formats("thumb", 200, 200, false))},
 		{"Invalid File Type", false, "damaged.jpg", "invalid_normal_out.jpg", true, upload.NewImageProcessor()},
 		{"Invalid Image Type", false, "normal.gif", "invalid_normal_out.gif", true, upload.NewImageProcessor()},
 		{"Watermark Top Left", false, "normal.jpg", "watermarked_tl_normal_out.jpg", false, upload.NewImageProcessor(upload.Formats("water", 400, 400, false, upload.WatermarkHorizontal(upload.Left), upload.WatermarkVertical(upload.Top)))},
@@ -72,6 +88,7 @@ func (s *ProcessorTestSuite) SetupSuite() {
 		{"Watermark Center Right", false, "normal.jpg", "watermarked_cr_normal_out.jpg", false, upload.NewImageProcessor(upload.Formats("water", 400, 400, false, upload.WatermarkHorizontal(upload.Right), upload.WatermarkVertical(upload.Center)))},
 		{"Watermark Bad Pos", false, "normal.jpg", "watermarked_bad_prod_normal_out.jpg", false, upload.NewImageProcessor(upload.Formats("water", 400, 400, false, upload.WatermarkHorizontal(10), upload.WatermarkVertical(10)))},
 		{"PROD Watermark Bad Pos", true, "normal.jpg", "watermarked_bad_normal_out.jpg", false, upload.NewImageProcessor(upload.Formats("water", 400, 400, false, upload.WatermarkHorizontal(10), upload.WatermarkVertical(10)))},
+		{"Watermark Below Min Width", false, "normal.jpg", "format_normal_out.jpg", false, upload.NewImageProcessor(upload.WatermarkMinWidth(300), upload.Formats("thumb", 200, 200, false, upload.WatermarkHorizontal(upload.Center), upload.WatermarkVertical(upload.Center)))},
 		{"Watermark Bad Pos", false, "normal.jpg", "watermarked_normal_out.jpg", false, upload.NewImageProcessor(upload.Formats("damaged", 400, 400, false, upload.WatermarkHorizontal(upload.Center), upload.WatermarkVertical(upload.Center)))},
 		{"Backdrop Landscape", false, "normal.jpg", "backdropped_normal_out.jpg", false, upload.NewImageProcessor(upload.Formats("back", 200, 200, true))},
 		{"PROD Backdrop Landscape", true, "normal.jpg", "backdropped_prod_normal_out.jpg", false, upload.NewImageProcessor(upload.Formats("back", 200, 200, true))},
@@ -94,10 +111,10 @@ func (s *ProcessorTestSuite) TestImageProcess() {
 	)
 
 	for _, tt := range s.imageProcessTests {
-		s.Run(tt.name, func(){
+		s.Run(tt.name, func() {
 			oldEnv := core.Env
 			// Adjust environment
-			defer func(){
+			defer func() {
 				core.Env = oldEnv
 			}()
 			if tt.prod {
@@ -105,7 +122,7 @@ func (s *ProcessorTestSuite) TestImageProcess() {
 			} else {
 				core.Env = core.EnvironmentDEV
 			}
-	
+
 			uploadedFile := upload.NewMockUploadedFile(tt.inputFile, *commonOpts)
 			job, err := tt.processor.Process(uploadedFile, true)
 			if tt.expectedProcessError && err != nil {
@@ -115,7 +132,7 @@ func (s *ProcessorTestSuite) TestImageProcess() {
 				s.Failf("Cannot process file", "%v", err)
 				return
 			}
-	
+
 			select {
 			case <-time.After(3 * time.Second):
 				// We timed out!
@@ -127,34 +144,34 @@ func (s *ProcessorTestSuite) TestImageProcess() {
 				// Job done! We are good!
 			}
 			for _, format := range tt.processor.Options().Formats() {
-				fileDiskPath := job.File.DiskPath()+":"+format.Name()
+				fileDiskPath := job.File.DiskPath() + ":" + format.Name()
 				content, err := ioutil.ReadFile(fileDiskPath)
 				if err != nil {
 					s.Failf("Cannot open processed file", "%s: %v", fileDiskPath, err)
 					return
 				}
-	
-				defer func(){
+
+				defer func() {
 					// Cleanup
 					if err = os.Remove(fileDiskPath); err != nil {
 						// Not a problem!
 					}
 				}()
-		
-				expectedFileDiskPath := tt.expectedFile+":"+format.Name()
+
+				expectedFileDiskPath := tt.expectedFile + ":" + format.Name()
 				if *update {
 					if err = ioutil.WriteFile(filepath.Join(testDataFolder, expectedFileDiskPath), content, 0644); err != nil {
 						s.Failf("Cannot update golden file", "%s: %v", expectedFileDiskPath, err)
 						continue
 					}
 				}
-		
+
 				expectedContent, err := ioutil.ReadFile(filepath.Join(testDataFolder, expectedFileDiskPath))
 				if err != nil {
 					s.Failf("Cannot open output golden file", "%s: %v", expectedFileDiskPath, err)
 					continue
 				}
-		
+
 				// Check if file content valid
 				s.Equalf(expectedContent, content, "Uploaded content invalid")
 			}
@@ -162,6 +179,1116 @@ func (s *ProcessorTestSuite) TestImageProcess() {
 	}
 }
 
+func (s *ProcessorTestSuite) TestGetOrGenerate() {
+	processor := upload.NewImageProcessor(upload.Formats("thumb", 200, 200, false))
+	format, _ := processor.Options().Format("thumb")
+
+	fileDiskPath, err := processor.GetOrGenerate(filepath.Join(testDataFolder, "normal.jpg"), format)
+	if err != nil {
+		s.Failf("Cannot generate file", "%v", err)
+		return
+	}
+	defer os.Remove(fileDiskPath)
+
+	content, err := ioutil.ReadFile(fileDiskPath)
+	if err != nil {
+		s.Failf("Cannot open generated file", "%s: %v", fileDiskPath, err)
+		return
+	}
+
+	expectedContent, err := ioutil.ReadFile(filepath.Join(testDataFolder, "format_normal_out.jpg:thumb"))
+	if err != nil {
+		s.Failf("Cannot open output golden file", "%v", err)
+		return
+	}
+	s.Equalf(expectedContent, content, "Generated content invalid")
+
+	// Second call must return the existing variant
+	again, err := processor.GetOrGenerate(filepath.Join(testDataFolder, "normal.jpg"), format)
+	s.NoError(err)
+	s.Equal(fileDiskPath, again)
+}
+
+func (s *ProcessorTestSuite) TestPreserveModTime() {
+	processor := upload.NewImageProcessor(upload.PreserveModTime(true), upload.Formats("thumb", 200, 200, false))
+	format, _ := processor.Options().Format("thumb")
+	srcDiskPath := filepath.Join(testDataFolder, "normal.jpg")
+
+	fileDiskPath, err := processor.GetOrGenerate(srcDiskPath, format)
+	if err != nil {
+		s.Failf("Cannot generate file", "%v", err)
+		return
+	}
+	defer os.Remove(fileDiskPath)
+
+	srcInfo, err := os.Stat(srcDiskPath)
+	s.NoError(err)
+	info, err := os.Stat(fileDiskPath)
+	s.NoError(err)
+	s.True(srcInfo.ModTime().Equal(info.ModTime()), "Mod time not preserved")
+}
+
+func (s *ProcessorTestSuite) TestGenerateLQIP() {
+	lqip, err := upload.GenerateLQIP(filepath.Join(testDataFolder, "normal.jpg"), 20)
+	s.NoError(err)
+	s.True(strings.HasPrefix(lqip, "data:image/jpeg;base64,"), "Invalid data URI")
+
+	_, err = upload.GenerateLQIP(filepath.Join(testDataFolder, "damaged.jpg"), 20)
+	s.Error(err)
+}
+
+func (s *ProcessorTestSuite) TestDrain() {
+	commonOpts := upload.EvaluateOptions(upload.Dir(testDataFolder))
+	processor := upload.NewImageProcessor(upload.Formats("thumb", 200, 200, false))
+
+	job, err := processor.Process(upload.NewMockUploadedFile("normal.jpg", *commonOpts), true)
+	if err != nil {
+		s.Failf("Cannot process file", "%v", err)
+		return
+	}
+	defer os.Remove(job.File.DiskPath() + ":thumb")
+	go func() {
+		<-job.Done
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = processor.Drain(ctx)
+	s.NoError(err)
+	s.FileExists(job.File.DiskPath() + ":thumb")
+}
+
+func (s *ProcessorTestSuite) TestWatermarkFunc() {
+	commonOpts := upload.EvaluateOptions(upload.Dir(testDataFolder))
+	processor := upload.NewImageProcessor(
+		upload.Formats("water", 400, 400, false),
+		upload.Formats("plain", 200, 200, false, upload.WatermarkHorizontal(upload.Left)),
+	)
+
+	var mu sync.Mutex
+	decided := map[string]bool{}
+	watermarkFunc := func(format upload.Format) *upload.OptionsWatermark {
+		mu.Lock()
+		defer mu.Unlock()
+		decided[format.Name()] = true
+		if format.Name() != "water" {
+			return nil
+		}
+		return upload.EvaluateWatermarkOptions(upload.WatermarkHorizontal(upload.Right), upload.WatermarkVertical(upload.Bottom))
+	}
+
+	job, err := processor.Process(upload.NewMockUploadedFile("normal.jpg", *commonOpts), true, upload.WithWatermarkFunc(watermarkFunc))
+	if err != nil {
+		s.Failf("Cannot process file", "%v", err)
+		return
+	}
+	defer os.Remove(job.File.DiskPath() + ":water")
+	defer os.Remove(job.File.DiskPath() + ":plain")
+	<-job.Done
+
+	s.NoError(job.Err)
+	s.Equal(map[string]bool{"water": true, "plain": true}, decided)
+}
+
+func (s *ProcessorTestSuite) TestPlaceholder() {
+	commonOpts := upload.EvaluateOptions(upload.Dir(testDataFolder))
+	processor := upload.NewImageProcessor(
+		upload.Formats("thumb", 200, 200, false),
+		upload.Placeholder(upload.PlaceholderSolid),
+	)
+
+	job, err := processor.Process(upload.NewMockUploadedFile("normal.jpg", *commonOpts), true)
+	if err != nil {
+		s.Failf("Cannot process file", "%v", err)
+		return
+	}
+	defer os.Remove(job.File.DiskPath() + ":thumb")
+
+	// Written before processing completes
+	s.FileExists(job.File.DiskPath() + ":thumb")
+	<-job.Done
+	s.NoError(job.Err)
+	s.FileExists(job.File.DiskPath() + ":thumb")
+}
+
+type unavailableStorage struct{}
+
+func (unavailableStorage) Create(key string) (upload.StorageWriter, error) {
+	return nil, errors.New("unavailable")
+}
+
+func (unavailableStorage) Delete(key string) error {
+	return errors.New("unavailable")
+}
+
+func (s *ProcessorTestSuite) TestFormatError() {
+	commonOpts := upload.EvaluateOptions(upload.Dir(testDataFolder))
+	processor := upload.NewImageProcessor(
+		upload.Formats("thumb", 200, 200, false),
+		upload.WithStorage(unavailableStorage{}),
+	)
+
+	job, err := processor.Process(upload.NewMockUploadedFile("normal.jpg", *commonOpts), true)
+	if err != nil {
+		s.Failf("Cannot process file", "%v", err)
+		return
+	}
+	<-job.Done
+
+	if s.Len(job.Failed, 1) {
+		s.Equal("thumb", job.Failed[0].Name)
+		s.Equal(job.File.DiskPath(), job.Failed[0].Path)
+		s.EqualError(job.Failed[0].Err, "unavailable")
+	}
+}
+
+func (s *ProcessorTestSuite) TestClamped() {
+	src, err := imaging.Open(filepath.Join(testDataFolder, "normal.jpg"))
+	if err != nil {
+		s.Failf("Cannot open file", "%v", err)
+		return
+	}
+	srcW, srcH := src.Bounds().Dx(), src.Bounds().Dy()
+
+	commonOpts := upload.EvaluateOptions(upload.Dir(testDataFolder))
+	processor := upload.NewImageProcessor(
+		upload.Formats("thumb", 200, 200, false),
+		upload.Formats("hero", srcW*2, 0, false),
+	)
+
+	job, err := processor.Process(upload.NewMockUploadedFile("normal.jpg", *commonOpts), true)
+	if err != nil {
+		s.Failf("Cannot process file", "%v", err)
+		return
+	}
+	defer os.Remove(job.File.DiskPath() + ":thumb")
+	defer os.Remove(job.File.DiskPath() + ":hero")
+	<-job.Done
+
+	s.NoError(job.Err)
+	if s.Len(job.Clamped, 1) {
+		s.Equal(&upload.ClampedFormat{
+			Name:         "hero",
+			Width:        srcW * 2,
+			ActualWidth:  srcW,
+			ActualHeight: srcH,
+		}, job.Clamped[0])
+	}
+}
+
+func (s *ProcessorTestSuite) TestVariants() {
+	commonOpts := upload.EvaluateOptions(upload.Dir(testDataFolder))
+	processor := upload.NewImageProcessor(upload.Formats("thumb", 200, 100, false))
+
+	job, err := processor.Process(upload.NewMockUploadedFile("normal.jpg", *commonOpts), true)
+	if err != nil {
+		s.Failf("Cannot process file", "%v", err)
+		return
+	}
+	defer os.Remove(job.File.DiskPath() + ":thumb")
+	<-job.Done
+
+	if s.Len(job.Variants, 1) {
+		variant := job.Variants[0]
+		s.Equal("thumb", variant.Name)
+		s.Equal(job.File.DiskPath()+":thumb", variant.Path)
+		s.Equal(200, variant.Width)
+		s.Equal(100, variant.Height)
+
+		info, err := os.Stat(variant.Path)
+		if s.NoError(err) {
+			s.Equal(info.Size(), variant.Bytes)
+		}
+		s.Equal(float64(variant.Bytes)/(200*100*4), variant.CompressionRatio)
+		s.True(variant.DecodeTime > 0, "Decode time not measured")
+		s.True(variant.ResizeTime > 0, "Resize time not measured")
+		s.True(variant.EncodeTime > 0, "Encode time not measured")
+	}
+}
+
+func (s *ProcessorTestSuite) TestNativeFormat() {
+	commonOpts := upload.EvaluateOptions(upload.Dir(testDataFolder))
+	processor := upload.NewImageProcessor(
+		upload.Formats("optimized", 0, 0, false),
+		upload.FormatOptions("optimized", upload.OutputFormat("png")),
+	)
+
+	job, err := processor.Process(upload.NewMockUploadedFile("normal.jpg", *commonOpts), true)
+	if err != nil {
+		s.Failf("Cannot process file", "%v", err)
+		return
+	}
+	<-job.Done
+
+	source, err := os.Open(job.File.DiskPath())
+	if !s.NoError(err) {
+		return
+	}
+	defer source.Close()
+	config, _, err := image.DecodeConfig(source)
+	if !s.NoError(err) {
+		return
+	}
+
+	if s.Len(job.Variants, 1) {
+		variant := job.Variants[0]
+		defer os.Remove(variant.Path)
+		s.Equal(strings.TrimSuffix(job.File.DiskPath(), ".jpg")+"_optimized.png", variant.Path)
+		s.Equal(config.Width, variant.Width)
+		s.Equal(config.Height, variant.Height)
+	}
+}
+
+func (s *ProcessorTestSuite) TestCropResolver() {
+	commonOpts := upload.EvaluateOptions(upload.Dir(testDataFolder))
+	processor := upload.NewImageProcessor(
+		upload.Formats("region", 0, 0, false),
+		upload.Formats("outside", 0, 0, false),
+		upload.Formats("default", 0, 0, false),
+		upload.FormatOptions("region", upload.OutputFormat("png")),
+		upload.FormatOptions("outside", upload.OutputFormat("png")),
+		upload.FormatOptions("default", upload.OutputFormat("png")),
+	)
+
+	var (
+		mu       sync.Mutex
+		resolved []string
+	)
+	resolver := func(config image.Config, format upload.Format) (image.Rectangle, bool) {
+		mu.Lock()
+		resolved = append(resolved, format.Name())
+		mu.Unlock()
+
+		switch format.Name() {
+		case "region":
+			return image.Rect(10, 20, 110, 70), true
+		case "outside":
+			return image.Rect(0, 0, config.Width+1, config.Height), true
+		}
+		return image.Rectangle{}, false
+	}
+
+	job, err := processor.Process(upload.NewMockUploadedFile("normal.jpg", *commonOpts), true, upload.WithCropResolver(resolver))
+	if err != nil {
+		s.Failf("Cannot process file", "%v", err)
+		return
+	}
+	<-job.Done
+
+	mu.Lock()
+	s.Len(resolved, 3)
+	mu.Unlock()
+
+	if s.Len(job.Variants, 3) {
+		for _, variant := range job.Variants {
+			defer os.Remove(variant.Path)
+			switch variant.Name {
+			case "region":
+				s.Equal(100, variant.Width)
+				s.Equal(50, variant.Height)
+			default:
+				s.Equal(job.Config.Width, variant.Width, variant.Name)
+				s.Equal(job.Config.Height, variant.Height, variant.Name)
+			}
+		}
+	}
+}
+
+func (s *ProcessorTestSuite) TestStats() {
+	commonOpts := upload.EvaluateOptions(upload.Dir(testDataFolder))
+	processor := upload.NewImageProcessor(upload.Formats("thumb", 200, 100, false))
+	// expvar names are global to the process, e.g. across -count runs
+	name := fmt.Sprintf("upload_test_stats_%d", time.Now().UnixNano())
+	s.NoError(processor.PublishExpvar(name))
+	s.Error(processor.PublishExpvar(name))
+
+	job, err := processor.Process(upload.NewMockUploadedFile("normal.jpg", *commonOpts), true)
+	if err != nil {
+		s.Failf("Cannot process file", "%v", err)
+		return
+	}
+	defer os.Remove(job.File.DiskPath() + ":thumb")
+	<-job.Done
+
+	stats := processor.Stats()
+	s.Equal(0, stats.QueueDepth)
+	s.Equal(1, stats.Processed)
+	s.Equal(0, stats.Errored)
+	s.True(stats.AvgDuration > 0, "Average duration not measured")
+
+	published := expvar.Get(name).String()
+	s.True(strings.Contains(published, `"Processed":1`), published)
+}
+
+func (s *ProcessorTestSuite) TestProcessFS() {
+	content, err := ioutil.ReadFile(filepath.Join(testDataFolder, "normal.jpg"))
+	if !s.NoError(err) {
+		return
+	}
+	fsys := fstest.MapFS{"defaults/avatar.jpg": &fstest.MapFile{Data: content}}
+
+	dir, err := ioutil.TempDir("", "processfs")
+	if !s.NoError(err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	processor := upload.NewImageProcessor(
+		upload.Formats("thumb", 200, 100, false),
+		upload.Placeholder(upload.PlaceholderDownsample),
+	)
+	fileDiskPath := filepath.Join(dir, "avatar.jpg")
+	job, err := processor.ProcessFS(fsys, "defaults/avatar.jpg", fileDiskPath, true)
+	if err != nil {
+		s.Failf("Cannot process file", "%v", err)
+		return
+	}
+	<-job.Done
+
+	s.NoError(job.Err)
+	if s.Len(job.Variants, 1) {
+		s.Equal(fileDiskPath+":thumb", job.Variants[0].Path)
+		s.Equal(200, job.Variants[0].Width)
+	}
+	_, err = os.Stat(fileDiskPath)
+	s.True(os.IsNotExist(err), "Source written to disk")
+
+	_, err = processor.ProcessFS(fsys, "defaults/missing.jpg", fileDiskPath, true)
+	s.Error(err)
+}
+
+func (s *ProcessorTestSuite) TestQualityFunc() {
+	commonOpts := upload.EvaluateOptions(upload.Dir(testDataFolder))
+
+	var (
+		mu    sync.Mutex
+		sizes = make(map[int]int)
+	)
+	processor := upload.NewImageProcessor(
+		upload.Formats("small", 200, 100, false),
+		upload.Formats("large", 400, 200, false),
+		upload.QualityFunc(func(width, height int) int {
+			mu.Lock()
+			sizes[width] = height
+			mu.Unlock()
+			if width <= 200 {
+				return 10
+			}
+			return 95
+		}),
+	)
+
+	job, err := processor.Process(upload.NewMockUploadedFile("normal.jpg", *commonOpts), true)
+	if err != nil {
+		s.Failf("Cannot process file", "%v", err)
+		return
+	}
+	defer os.Remove(job.File.DiskPath() + ":small")
+	defer os.Remove(job.File.DiskPath() + ":large")
+	<-job.Done
+
+	mu.Lock()
+	s.Equal(map[int]int{200: 100, 400: 200}, sizes)
+	mu.Unlock()
+
+	if s.Len(job.Variants, 2) {
+		perPixel := make(map[string]float64)
+		for _, variant := range job.Variants {
+			perPixel[variant.Name] = float64(variant.Bytes) / float64(variant.Width*variant.Height)
+		}
+		s.True(perPixel["small"]*2 < perPixel["large"], "Small variant not encoded at a lower quality: %v", perPixel)
+	}
+
+	s.Equal(70, upload.DefaultQualityCurve(150, 100))
+	s.Equal(85, upload.DefaultQualityCurve(1200, 1600))
+
+	// The curve is skipped for sources whose type sets a quality
+	var calls int32
+	processor = upload.NewImageProcessor(
+		upload.Formats("small", 200, 100, false),
+		upload.SourceOverride(upload.TypeImageJPEG, upload.OverrideQuality(90)),
+		upload.QualityFunc(func(width, height int) int {
+			atomic.AddInt32(&calls, 1)
+			return 10
+		}),
+	)
+	job, err = processor.Process(upload.NewMockUploadedFile("normal.jpg", *commonOpts), true)
+	if err != nil {
+		s.Failf("Cannot process file", "%v", err)
+		return
+	}
+	defer os.Remove(job.File.DiskPath() + ":small")
+	<-job.Done
+
+	s.NoError(job.Err)
+	s.Equal(int32(0), atomic.LoadInt32(&calls))
+}
+
+func (s *ProcessorTestSuite) TestLatestWins() {
+	commonOpts := upload.EvaluateOptions(upload.Dir(testDataFolder))
+	processor := upload.NewImageProcessor(upload.Formats("thumb", 200, 200, false), upload.LatestWins(true))
+
+	processor.Pause()
+	stale, err := processor.Process(upload.NewMockUploadedFile("normal.jpg", *commonOpts), true)
+	if err != nil {
+		s.Failf("Cannot process file", "%v", err)
+		return
+	}
+	defer os.Remove(stale.File.DiskPath() + ":thumb")
+
+	// Returns once the stale job is cancelled, while still paused
+	latest, err := processor.Process(upload.NewMockUploadedFile("normal.jpg", *commonOpts), true)
+	if !s.NoError(err) {
+		return
+	}
+	<-stale.Done
+	s.Equal(upload.ErrJobCancelled, stale.Err)
+
+	processor.Resume()
+	select {
+	case <-latest.Done:
+		s.NoError(latest.Err)
+		s.Len(latest.Variants, 1)
+	case <-time.After(5 * time.Second):
+		s.Fail("Latest job not processed")
+	}
+}
+
+func (s *ProcessorTestSuite) TestPreviewSize() {
+	commonOpts := upload.EvaluateOptions(upload.Dir(testDataFolder))
+	processor := upload.NewImageProcessor(
+		upload.Formats("large", 400, 0, false),
+		upload.FormatOptions("large", upload.PreviewSize(32)),
+	)
+
+	job, err := processor.Process(upload.NewMockUploadedFile("normal.jpg", *commonOpts), true)
+	if err != nil {
+		s.Failf("Cannot process file", "%v", err)
+		return
+	}
+	defer os.Remove(job.File.DiskPath() + ":large")
+	defer os.Remove(job.File.DiskPath() + ":large:preview")
+	<-job.Done
+
+	if !s.NoError(job.Err) || !s.Len(job.Variants, 1) {
+		return
+	}
+	s.Equal(job.File.DiskPath()+":large:preview", job.Variants[0].PreviewPath)
+
+	file, err := os.Open(job.Variants[0].PreviewPath)
+	if !s.NoError(err) {
+		return
+	}
+	defer file.Close()
+	preview, err := imaging.Decode(file)
+	if s.NoError(err) {
+		s.True(preview.Bounds().Dx() <= 32 && preview.Bounds().Dy() <= 32)
+		s.True(preview.Bounds().Dx() == 32 || preview.Bounds().Dy() == 32)
+	}
+}
+
+func (s *ProcessorTestSuite) TestPalettedSources() {
+	commonOpts := upload.EvaluateOptions(upload.Dir(testDataFolder))
+	processor := upload.NewImageProcessor(upload.Formats("thumb", 100, 100, false), upload.PalettedSources(upload.PalettedReject))
+
+	_, err := processor.Process(upload.NewMockUploadedFile("normal.gif", *commonOpts), true)
+	s.Equal(upload.ErrPalettedSource, err)
+
+	job, err := processor.Process(upload.NewMockUploadedFile("normal.jpg", *commonOpts), true)
+	if s.NoError(err) {
+		defer os.Remove(job.File.DiskPath() + ":thumb")
+		<-job.Done
+		s.NoError(job.Err)
+	}
+}
+
+func (s *ProcessorTestSuite) TestProcessToTar() {
+	content, err := ioutil.ReadFile(filepath.Join(testDataFolder, "normal.jpg"))
+	if err != nil {
+		s.Failf("Cannot read file", "%v", err)
+		return
+	}
+
+	processor := upload.NewImageProcessor(
+		upload.Formats("thumb", 200, 200, false),
+		upload.Formats("card", 300, 0, false),
+		upload.FormatOptions("card", upload.OutputFormat("png")),
+	)
+
+	var buf bytes.Buffer
+	if !s.NoError(processor.ProcessToTar(content, "streamed.jpg", &buf)) {
+		return
+	}
+
+	sizes := make(map[string]image.Point)
+	archive := tar.NewReader(&buf)
+	for {
+		header, err := archive.Next()
+		if err != nil {
+			break
+		}
+		img, _, err := image.Decode(archive)
+		if s.NoError(err, header.Name) {
+			sizes[header.Name] = img.Bounds().Size()
+		}
+	}
+	s.Equal(map[string]image.Point{"thumb.jpg": {200, 200}, "card.png": {300, 259}}, sizes)
+
+	// Deterministic archives are identical across runs
+	deterministic := upload.NewImageProcessor(upload.Formats("thumb", 200, 200, false), upload.Deterministic(true))
+	var first, second bytes.Buffer
+	s.NoError(deterministic.ProcessToTar(content, "streamed.jpg", &first))
+	time.Sleep(1100 * time.Millisecond)
+	s.NoError(deterministic.ProcessToTar(content, "streamed.jpg", &second))
+	s.True(bytes.Equal(first.Bytes(), second.Bytes()), "Deterministic archives differ")
+
+	_, err = os.Stat("streamed.jpg:thumb")
+	s.True(os.IsNotExist(err))
+
+	s.Error(processor.ProcessToTar([]byte("not an image"), "streamed.jpg", &buf))
+}
+
+func (s *ProcessorTestSuite) TestCancel() {
+	commonOpts := upload.EvaluateOptions(upload.Dir(testDataFolder))
+	processor := upload.NewImageProcessor(
+		upload.Formats("thumb", 200, 200, false),
+		upload.Formats("large", 400, 400, false),
+		upload.Placeholder(upload.PlaceholderSolid),
+	)
+
+	processor.Pause()
+	job, err := processor.Process(upload.NewMockUploadedFile("normal.jpg", *commonOpts), true)
+	if err != nil {
+		s.Failf("Cannot process file", "%v", err)
+		return
+	}
+	defer os.Remove(job.File.DiskPath() + ":thumb")
+	defer os.Remove(job.File.DiskPath() + ":large")
+
+	// Placeholders are on disk until the job is done
+	_, err = os.Stat(job.File.DiskPath() + ":thumb")
+	s.NoError(err)
+
+	s.True(processor.Cancel(job.File.DiskPath()))
+	s.False(processor.Cancel(job.File.DiskPath() + ".missing"))
+	processor.Resume()
+	<-job.Done
+
+	s.Equal(upload.ErrJobCancelled, job.Err)
+	s.Len(job.Variants, 0)
+	for _, name := range []string{"thumb", "large"} {
+		_, err := os.Stat(job.File.DiskPath() + ":" + name)
+		s.True(os.IsNotExist(err), name)
+	}
+}
+
+func (s *ProcessorTestSuite) TestSamePathJobs() {
+	commonOpts := upload.EvaluateOptions(upload.Dir(testDataFolder))
+	processor := upload.NewImageProcessor(upload.Formats("thumb", 200, 200, false))
+
+	// The second job for the same path is done first, at its deadline, while paused
+	processor.Pause()
+	first, err := processor.Process(upload.NewMockUploadedFile("normal.jpg", *commonOpts), true)
+	if err != nil {
+		s.Failf("Cannot process file", "%v", err)
+		return
+	}
+	defer os.Remove(first.File.DiskPath() + ":thumb")
+	second, err := processor.Process(upload.NewMockUploadedFile("normal.jpg", *commonOpts), true, upload.Deadline(time.Now().Add(10*time.Millisecond)))
+	if !s.NoError(err) {
+		return
+	}
+	s.Equal(2, processor.Stats().QueueDepth)
+
+	<-second.Done
+	s.Equal(upload.ErrDeadlineExceeded, second.Err)
+	s.Equal(1, processor.Stats().QueueDepth)
+
+	// The first job is still in progress
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = processor.Drain(ctx)
+	s.Equal(context.DeadlineExceeded, err)
+
+	processor.Resume()
+	<-first.Done
+	s.NoError(first.Err)
+	_, err = processor.Drain(context.Background())
+	s.NoError(err)
+
+	// Cancel reaches every job of the path
+	processor.Pause()
+	jobs := make([]*upload.Job, 2)
+	for i := range jobs {
+		jobs[i], err = processor.Process(upload.NewMockUploadedFile("normal.jpg", *commonOpts), true)
+		if !s.NoError(err) {
+			return
+		}
+	}
+	s.True(processor.Cancel(jobs[0].File.DiskPath()))
+	processor.Resume()
+	for _, job := range jobs {
+		<-job.Done
+		s.Equal(upload.ErrJobCancelled, job.Err)
+	}
+}
+
+func (s *ProcessorTestSuite) TestSourceOverride() {
+	commonOpts := upload.EvaluateOptions(upload.Dir(testDataFolder))
+	processor := upload.NewImageProcessor(
+		upload.Formats("thumb", 200, 100, false),
+		upload.FormatOptions("thumb", upload.OutputFormat("jpg")),
+		upload.SourceOverride(upload.TypeImagePNG, upload.OverrideOutput("png")),
+	)
+
+	// PNG sources keep their format, others are converted
+	png, err := processor.Process(upload.NewMockUploadedFile("normal.png", *commonOpts), true)
+	if err != nil {
+		s.Failf("Cannot process file", "%v", err)
+		return
+	}
+	<-png.Done
+	if s.NoError(png.Err) && s.Len(png.Variants, 1) {
+		defer os.Remove(png.Variants[0].Path)
+		s.Equal(png.File.DiskPath()+":thumb", png.Variants[0].Path)
+
+		variant, err := os.Open(png.Variants[0].Path)
+		if s.NoError(err) {
+			defer variant.Close()
+			_, imgType, err := image.DecodeConfig(variant)
+			s.NoError(err)
+			s.Equal(upload.TypeImagePNG, imgType)
+		}
+	}
+
+	jpg, err := processor.Process(upload.NewMockUploadedFile("normal.jpg", *commonOpts), true)
+	if err != nil {
+		s.Failf("Cannot process file", "%v", err)
+		return
+	}
+	<-jpg.Done
+	if s.NoError(jpg.Err) && s.Len(jpg.Variants, 1) {
+		defer os.Remove(jpg.Variants[0].Path)
+		s.Equal(jpg.File.DiskPath()+":thumb", jpg.Variants[0].Path)
+	}
+}
+
+func (s *ProcessorTestSuite) TestSourceOverrideQuality() {
+	commonOpts := upload.EvaluateOptions(upload.Dir(testDataFolder))
+	sizes := make([]int64, 0, 2)
+	for _, opts := range [][]upload.OptionImage{
+		{upload.SourceOverride(upload.TypeImageJPEG, upload.OverrideQuality(10))},
+		// The type quality prevails over the curve and the SSIM search
+		{
+			upload.SourceOverride(upload.TypeImageJPEG, upload.OverrideQuality(10)),
+			upload.QualityFunc(func(width, height int) int { return 95 }),
+			upload.TargetSSIM(0.99),
+		},
+	} {
+		processor := upload.NewImageProcessor(append(opts, upload.Formats("thumb", 200, 100, false))...)
+		job, err := processor.Process(upload.NewMockUploadedFile("normal.jpg", *commonOpts), true)
+		if err != nil {
+			s.Failf("Cannot process file", "%v", err)
+			return
+		}
+		<-job.Done
+		if !s.NoError(job.Err) || !s.Len(job.Variants, 1) {
+			return
+		}
+		os.Remove(job.Variants[0].Path)
+		sizes = append(sizes, job.Variants[0].Bytes)
+	}
+
+	s.Equal(sizes[0], sizes[1])
+}
+
+func (s *ProcessorTestSuite) TestFormatSkipped() {
+	commonOpts := upload.EvaluateOptions(upload.Dir(testDataFolder))
+
+	var (
+		mu      sync.Mutex
+		skipped []upload.FormatSkipped
+	)
+	processor := upload.NewImageProcessor(
+		upload.Formats("thumb", 200, 200, false),
+		upload.Formats("broken", -200, 200, false),
+		upload.Formats("huge", 20000, 20000, false),
+		upload.FormatOptions("huge", upload.SkipIfSmaller(true)),
+		upload.OnInvalidFormat(upload.InvalidFormatSkip),
+		upload.OnFormatSkipped(func(event upload.FormatSkipped) {
+			mu.Lock()
+			skipped = append(skipped, event)
+			mu.Unlock()
+		}),
+	)
+
+	job, err := processor.Process(upload.NewMockUploadedFile("normal.jpg", *commonOpts), true)
+	if err != nil {
+		s.Failf("Cannot process file", "%v", err)
+		return
+	}
+	defer os.Remove(job.File.DiskPath() + ":thumb")
+	<-job.Done
+
+	mu.Lock()
+	defer mu.Unlock()
+	if s.Len(skipped, 2) {
+		s.Equal("broken", skipped[0].Name)
+		s.Equal(upload.SkippedInvalid, skipped[0].Reason)
+		s.Error(skipped[0].Err)
+		s.Equal("huge", skipped[1].Name)
+		s.Equal(upload.SkippedSmaller, skipped[1].Reason)
+		s.Equal(job.File.DiskPath(), skipped[1].Path)
+	}
+}
+
+func (s *ProcessorTestSuite) TestIdempotencyKey() {
+	commonOpts := upload.EvaluateOptions(upload.Dir(testDataFolder))
+	store := upload.NewMemoryIdempotencyStore()
+	processor := upload.NewImageProcessor(upload.Formats("thumb", 200, 200, false), upload.WithIdempotencyStore(store))
+
+	job, err := processor.Process(upload.NewMockUploadedFile("normal.jpg", *commonOpts), true, upload.IdempotencyKey("message-1"))
+	if err != nil {
+		s.Failf("Cannot process file", "%v", err)
+		return
+	}
+	defer os.Remove(job.File.DiskPath() + ":thumb")
+	<-job.Done
+	s.NoError(job.Err)
+
+	// Keys are shared through the store, e.g. after a restart
+	restarted := upload.NewImageProcessor(upload.Formats("thumb", 200, 200, false), upload.WithIdempotencyStore(store))
+	_, err = restarted.Process(upload.NewMockUploadedFile("normal.jpg", *commonOpts), true, upload.IdempotencyKey("message-1"))
+	s.Equal(upload.ErrAlreadyProcessed, err)
+
+	job, err = restarted.Process(upload.NewMockUploadedFile("normal.jpg", *commonOpts), true, upload.IdempotencyKey("message-2"))
+	if s.NoError(err) {
+		<-job.Done
+	}
+}
+
+func (s *ProcessorTestSuite) TestPause() {
+	commonOpts := upload.EvaluateOptions(upload.Dir(testDataFolder))
+	processor := upload.NewImageProcessor(upload.Formats("thumb", 200, 200, false), upload.MaxJobs(1))
+
+	processor.Pause()
+	s.True(processor.Paused())
+
+	job, err := processor.Process(upload.NewMockUploadedFile("normal.jpg", *commonOpts), true)
+	if err != nil {
+		s.Failf("Cannot process file", "%v", err)
+		return
+	}
+	defer os.Remove(job.File.DiskPath() + ":thumb")
+
+	// The paused job holds the only slot
+	_, err = processor.ProcessTimeout(upload.NewMockUploadedFile("normal.jpg", *commonOpts), true, 50*time.Millisecond)
+	s.Equal(upload.ErrQueueFull, err)
+	select {
+	case <-job.Done:
+		s.Fail("Job processed while paused")
+		return
+	default:
+	}
+
+	processor.Resume()
+	s.False(processor.Paused())
+	select {
+	case <-job.Done:
+		s.NoError(job.Err)
+	case <-time.After(5 * time.Second):
+		s.Fail("Job not processed after resume")
+	}
+}
+
+func (s *ProcessorTestSuite) TestDeadline() {
+	commonOpts := upload.EvaluateOptions(upload.Dir(testDataFolder))
+	processor := upload.NewImageProcessor(upload.Formats("thumb", 200, 200, false))
+
+	// The deadline passes before the job starts
+	processor.Pause()
+	job, err := processor.Process(upload.NewMockUploadedFile("normal.jpg", *commonOpts), true, upload.Deadline(time.Now().Add(20*time.Millisecond)))
+	if err != nil {
+		s.Failf("Cannot process file", "%v", err)
+		return
+	}
+	defer os.Remove(job.File.DiskPath() + ":thumb")
+	time.Sleep(50 * time.Millisecond)
+	processor.Resume()
+	<-job.Done
+
+	s.Equal(upload.ErrDeadlineExceeded, job.Err)
+	_, err = os.Stat(job.File.DiskPath() + ":thumb")
+	s.True(os.IsNotExist(err))
+
+	job, err = processor.Process(upload.NewMockUploadedFile("normal.jpg", *commonOpts), true, upload.Deadline(time.Now().Add(time.Minute)))
+	if s.NoError(err) {
+		<-job.Done
+		s.NoError(job.Err)
+	}
+}
+
+func (s *ProcessorTestSuite) TestProcessPriority() {
+	commonOpts := upload.EvaluateOptions(upload.Dir(testDataFolder))
+	processor := upload.NewImageProcessor(upload.Formats("thumb", 200, 200, false), upload.MaxJobs(1))
+
+	processor.Pause()
+	background, err := processor.Process(upload.NewMockUploadedFile("normal.jpg", *commonOpts), true)
+	if err != nil {
+		s.Failf("Cannot process file", "%v", err)
+		return
+	}
+	defer os.Remove(background.File.DiskPath() + ":thumb")
+
+	// Waits for the slot of the background job
+	done := make(chan *upload.Job, 1)
+	go func() {
+		job, err := processor.ProcessPriority(upload.NewMockUploadedFile("normal.png", *commonOpts), true)
+		if s.NoError(err) {
+			<-job.Done
+			done <- job
+		}
+	}()
+
+	processor.Resume()
+	<-background.Done
+	select {
+	case job := <-done:
+		defer os.Remove(job.File.DiskPath() + ":thumb")
+		s.NoError(job.Err)
+	case <-time.After(5 * time.Second):
+		s.Fail("Priority job not processed")
+	}
+}
+
+func (s *ProcessorTestSuite) TestProcessTimeout() {
+	commonOpts := upload.EvaluateOptions(upload.Dir(testDataFolder))
+	processor := upload.NewImageProcessor(upload.Formats("thumb", 200, 200, false), upload.MaxJobs(1))
+
+	// Hold the only slot until released
+	release := make(chan struct{})
+	blocking := upload.WithWatermarkFunc(func(format upload.Format) *upload.OptionsWatermark {
+		<-release
+		return nil
+	})
+
+	job, err := processor.Process(upload.NewMockUploadedFile("normal.jpg", *commonOpts), true, blocking)
+	if err != nil {
+		s.Failf("Cannot process file", "%v", err)
+		return
+	}
+	defer os.Remove(job.File.DiskPath() + ":thumb")
+
+	_, err = processor.ProcessTimeout(upload.NewMockUploadedFile("normal.jpg", *commonOpts), true, 50*time.Millisecond)
+	s.Equal(upload.ErrQueueFull, err)
+
+	close(release)
+	<-job.Done
+
+	job, err = processor.ProcessTimeout(upload.NewMockUploadedFile("normal.jpg", *commonOpts), true, time.Second)
+	if s.NoError(err) {
+		<-job.Done
+	}
+}
+
+func (s *ProcessorTestSuite) TestArchiveVariants() {
+	processor := upload.NewImageProcessor(upload.Formats("thumb", 200, 200, false), upload.Formats("missing", 100, 100, false))
+	format, _ := processor.Options().Format("thumb")
+	srcDiskPath := filepath.Join(testDataFolder, "normal.jpg")
+
+	fileDiskPath, err := processor.GetOrGenerate(srcDiskPath, format)
+	if err != nil {
+		s.Failf("Cannot generate file", "%v", err)
+		return
+	}
+	defer os.Remove(fileDiskPath)
+
+	s.Equal(map[string]string{"thumb": fileDiskPath}, processor.ListVariants(srcDiskPath))
+
+	var buf bytes.Buffer
+	if err := processor.ArchiveVariants(srcDiskPath, &buf); err != nil {
+		s.Failf("Cannot archive variants", "%v", err)
+		return
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		s.Failf("Cannot read archive", "%v", err)
+		return
+	}
+
+	var names []string
+	for _, entry := range archive.File {
+		names = append(names, entry.Name)
+	}
+	s.Equal([]string{"normal.jpg", "thumb.jpg"}, names)
+}
+
+func (s *ProcessorTestSuite) TestAtomic() {
+	oldEnv := core.Env
+	defer func() {
+		core.Env = oldEnv
+	}()
+	// Missing watermark assets fail formats in production
+	core.Env = core.EnvironmentPROD
+
+	commonOpts := upload.EvaluateOptions(upload.Dir(testDataFolder))
+	processor := upload.NewImageProcessor(
+		upload.Atomic(true),
+		upload.Formats("thumb", 200, 200, false),
+		upload.Formats("nowatermark", 200, 200, false, upload.WatermarkHorizontal(upload.Center)),
+	)
+
+	job, err := processor.Process(upload.NewMockUploadedFile("normal.jpg", *commonOpts), true)
+	if err != nil {
+		s.Failf("Cannot process file", "%v", err)
+		return
+	}
+
+	select {
+	case <-time.After(3 * time.Second):
+		s.Failf("Cannot process file", "Timed out!")
+		return
+	case <-job.Done:
+	}
+
+	s.Error(job.Err)
+	_, err = os.Stat(job.File.DiskPath() + ":thumb")
+	s.True(os.IsNotExist(err), "Variant not removed")
+}
+
+func (s *ProcessorTestSuite) TestPerceptualHash() {
+	normal, err := upload.PerceptualHash(filepath.Join(testDataFolder, "normal.jpg"))
+	s.NoError(err)
+	resized, err := upload.PerceptualHash(filepath.Join(testDataFolder, "aspect_normal_out.jpg:hzero"))
+	s.NoError(err)
+	portrait, err := upload.PerceptualHash(filepath.Join(testDataFolder, "portrait.jpg"))
+	s.NoError(err)
+
+	s.True(upload.HammingDistance(normal, resized) < upload.HammingDistance(normal, portrait), "Resized image should be nearer than a different image")
+
+	_, err = upload.PerceptualHash(filepath.Join(testDataFolder, "damaged.jpg"))
+	s.Error(err)
+}
+
+func (s *ProcessorTestSuite) TestSkipIfSmaller() {
+	processor := upload.NewImageProcessor(
+		upload.Formats("huge", 5000, 5000, false),
+		upload.FormatOptions("huge", upload.SkipIfSmaller(true)),
+	)
+	format, _ := processor.Options().Format("huge")
+
+	_, err := processor.GetOrGenerate(filepath.Join(testDataFolder, "normal.jpg"), format)
+	s.Equal(upload.ErrFormatSkipped, err)
+}
+
+func (s *ProcessorTestSuite) TestMontage() {
+	paths := []string{
+		filepath.Join(testDataFolder, "normal.jpg"),
+		filepath.Join(testDataFolder, "portrait.jpg"),
+		filepath.Join(testDataFolder, "normal.png"),
+	}
+
+	montage, err := upload.Montage(paths, 2, 50, 40)
+	s.NoError(err)
+	s.Equal(image.Rect(0, 0, 100, 80), montage.Bounds())
+
+	montage, err = upload.Montage(paths[:1], 4, 50, 40)
+	s.NoError(err)
+	s.Equal(image.Rect(0, 0, 50, 40), montage.Bounds())
+
+	_, err = upload.Montage(nil, 2, 50, 40)
+	s.Error(err)
+}
+
+func (s *ProcessorTestSuite) TestSpritesheet() {
+	paths := []string{
+		filepath.Join(testDataFolder, "normal.jpg"),
+		filepath.Join(testDataFolder, "portrait.jpg"),
+		filepath.Join(testDataFolder, "normal.png"),
+	}
+
+	sheet, regions, err := upload.Spritesheet(paths, 50, 40)
+	if !s.NoError(err) {
+		return
+	}
+	s.Equal(image.Rect(0, 0, 100, 80), sheet.Bounds())
+
+	cells := []image.Rectangle{image.Rect(0, 0, 50, 40), image.Rect(50, 0, 100, 40), image.Rect(0, 40, 50, 80)}
+	for i, path := range paths {
+		region := regions[path]
+		s.Equal(cells[i].Min, region.Min)
+		s.True(region.In(cells[i]), "%v region %v out of its cell", path, region)
+		s.True(region.Dx() == 50 || region.Dy() == 40, "%v not scaled to its cell: %v", path, region)
+	}
+
+	css := upload.SpritesheetCSS(regions, "/sprites.png", "icon-")
+	s.Contains(css, fmt.Sprintf(".icon-portrait{background:url(\"/sprites.png\") -50px 0px;width:%dpx;height:%dpx}", regions[paths[1]].Dx(), regions[paths[1]].Dy()))
+
+	_, _, err = upload.Spritesheet(nil, 50, 40)
+	s.Error(err)
+}
+
+func (s *ProcessorTestSuite) TestPipeline() {
+	src, err := imaging.Open(filepath.Join(testDataFolder, "normal.jpg"))
+	if err != nil {
+		s.Failf("Cannot open file", "%v", err)
+		return
+	}
+
+	options := upload.EvaluateImageOptions(upload.Formats("thumb", 200, 100, false))
+	format, _ := options.Format("thumb")
+
+	pipeline := upload.NewPipeline(src).
+		Resize(format).
+		Apply(func(img image.Image) (image.Image, error) {
+			return imaging.Grayscale(img), nil
+		}).
+		Watermark(format)
+	s.NoError(pipeline.Err())
+	s.Equal(image.Rect(0, 0, 200, 100), pipeline.Image().Bounds())
+
+	var buf bytes.Buffer
+	s.NoError(pipeline.Encode(&buf, imaging.JPEG))
+	s.NotEqual(0, buf.Len())
+}
+
+func (s *ProcessorTestSuite) TestAllowUpscale() {
+	src, err := imaging.Open(filepath.Join(testDataFolder, "normal.jpg"))
+	if err != nil {
+		s.Failf("Cannot open file", "%v", err)
+		return
+	}
+
+	options := upload.EvaluateImageOptions(
+		upload.Formats("banner", 800, 800, false),
+		upload.FormatOptions("banner", upload.AllowUpscale(true, false)),
+	)
+	format, _ := options.Format("banner")
+
+	pipeline := upload.NewPipeline(src).Resize(format)
+	s.NoError(pipeline.Err())
+	s.Equal(image.Rect(0, 0, 800, src.Bounds().Dy()), pipeline.Image().Bounds())
+}
+
+func (s *ProcessorTestSuite) TestProcessFormats() {
+	processor := upload.NewImageProcessor(
+		upload.Formats("thumb", 200, 200, false),
+		upload.Formats("hero", 400, 200, false),
+	)
+	baseDiskPath := filepath.Join(testDataFolder, "normal.jpg")
+	defer os.Remove(baseDiskPath + ":hero")
+
+	s.NoError(processor.ProcessFormats(baseDiskPath, []string{"hero"}))
+	_, err := os.Stat(baseDiskPath + ":hero")
+	s.NoError(err)
+	_, err = os.Stat(baseDiskPath + ":thumb")
+	s.True(os.IsNotExist(err))
+
+	s.Error(processor.ProcessFormats(baseDiskPath, []string{"hero", "missing"}))
+}
+
 func TestProcessorTestSuite(t *testing.T) {
 	suite.Run(t, new(ProcessorTestSuite))
 }
@@ -0,0 +1,142 @@
+package upload
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"sort"
+)
+
+const (
+	// quantizeMaxSamples is the number of pixels sampled to build a palette
+	quantizeMaxSamples = 1 << 16
+)
+
+// medianCut is a draw.Quantizer building a palette by median cut:
+// the box of sampled colors with the widest channel range is split at its median
+// until the palette is full, each box giving its average color
+type medianCut struct{}
+
+// Quantize appends up to cap(p) - len(p) colors of m to p
+func (medianCut) Quantize(p color.Palette, m image.Image) color.Palette {
+	n := cap(p) - len(p)
+	if n <= 0 {
+		return p
+	}
+
+	boxes := []colorBox{sampleColors(m)}
+	for len(boxes) < n {
+		// Split the box with the widest range
+		widest, widestRange := -1, 0
+		for i, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			if _, r := box.widestChannel(); r > widestRange {
+				widest, widestRange = i, r
+			}
+		}
+		if widest < 0 {
+			break
+		}
+
+		low, high := boxes[widest].split()
+		boxes[widest] = low
+		boxes = append(boxes, high)
+	}
+
+	for _, box := range boxes {
+		if len(box) > 0 {
+			p = append(p, box.average())
+		}
+	}
+	return p
+}
+
+// colorBox holds colors as NRGBA channels
+type colorBox [][4]uint8
+
+// sampleColors returns the colors of at most quantizeMaxSamples pixels of m, evenly spread
+func sampleColors(m image.Image) colorBox {
+	bounds := m.Bounds()
+	step := 1
+	for bounds.Dx()*bounds.Dy()/(step*step) > quantizeMaxSamples {
+		step++
+	}
+
+	box := make(colorBox, 0, (bounds.Dx()/step+1)*(bounds.Dy()/step+1))
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += step {
+		for x := bounds.Min.X; x < bounds.Max.X; x += step {
+			c := color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)
+			box = append(box, [4]uint8{c.R, c.G, c.B, c.A})
+		}
+	}
+	return box
+}
+
+// widestChannel returns the channel of the box with the widest range and that range
+func (b colorBox) widestChannel() (int, int) {
+	channel, widest := 0, 0
+	for ch := 0; ch < 4; ch++ {
+		min, max := 255, 0
+		for _, c := range b {
+			v := int(c[ch])
+			if v < min {
+				min = v
+			}
+			if v > max {
+				max = v
+			}
+		}
+		if max-min > widest {
+			channel, widest = ch, max-min
+		}
+	}
+	return channel, widest
+}
+
+// split splits the box at the median of its widest channel
+func (b colorBox) split() (colorBox, colorBox) {
+	ch, _ := b.widestChannel()
+	sort.Slice(b, func(i, j int) bool { return b[i][ch] < b[j][ch] })
+	median := len(b) / 2
+	return b[:median], b[median:]
+}
+
+// average returns the average color of the box
+func (b colorBox) average() color.NRGBA {
+	var sum [4]int
+	for _, c := range b {
+		for ch := range sum {
+			sum[ch] += int(c[ch])
+		}
+	}
+	n := len(b)
+	return color.NRGBA{
+		R: uint8((sum[0] + n/2) / n),
+		G: uint8((sum[1] + n/2) / n),
+		B: uint8((sum[2] + n/2) / n),
+		A: uint8((sum[3] + n/2) / n),
+	}
+}
+
+// quantize reduces img to a palette of at most colors colors built by q (default: median cut),
+// optionally dithered with Floyd-Steinberg error diffusion
+func quantize(img image.Image, colors int, q draw.Quantizer, dither bool) *image.Paletted {
+	if q == nil {
+		q = medianCut{}
+	}
+
+	palette := q.Quantize(make(color.Palette, 0, colors), img)
+	if len(palette) == 0 {
+		palette = color.Palette{color.Transparent}
+	}
+
+	paletted := image.NewPaletted(img.Bounds(), palette)
+	var drawer draw.Drawer = draw.Src
+	if dither {
+		drawer = draw.FloydSteinberg
+	}
+	drawer.Draw(paletted, paletted.Rect, img, img.Bounds().Min)
+	return paletted
+}
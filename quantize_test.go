@@ -0,0 +1,71 @@
+package upload
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestQuantize(t *testing.T) {
+	// Gradient: many distinct colors
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			img.Set(x, y, color.NRGBA{uint8(x * 4), uint8(y * 4), 128, 255})
+		}
+	}
+
+	for _, dither := range []bool{false, true} {
+		paletted := quantize(img, 16, nil, dither)
+		if len(paletted.Palette) > 16 {
+			t.Errorf("dither %v: expected at most 16 colors, got %d", dither, len(paletted.Palette))
+		}
+		if paletted.Bounds() != img.Bounds() {
+			t.Errorf("dither %v: unexpected bounds %v", dither, paletted.Bounds())
+		}
+	}
+
+	// Fewer colors than the palette size are kept exact
+	flat := imaging.New(8, 8, color.NRGBA{255, 0, 0, 255})
+	paletted := quantize(flat, 256, nil, false)
+	if r, g, b, _ := paletted.At(3, 3).RGBA(); r>>8 != 255 || g != 0 || b != 0 {
+		t.Errorf("expected red, got %v", paletted.At(3, 3))
+	}
+}
+
+func TestPipelineQuantize(t *testing.T) {
+	// Noise: poorly compressed in truecolor
+	rnd := rand.New(rand.NewSource(1))
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	rnd.Read(img.Pix)
+
+	var truecolor, quantized bytes.Buffer
+	if err := NewPipeline(img).Encode(&truecolor, imaging.PNG); err != nil {
+		t.Fatal(err)
+	}
+	pipeline := NewPipeline(img, Quantize(8, false)).Quantize(imaging.PNG)
+	if err := pipeline.Encode(&quantized, imaging.PNG); err != nil {
+		t.Fatal(err)
+	}
+
+	if quantized.Len() >= truecolor.Len() {
+		t.Errorf("expected quantized PNG smaller than %d bytes, got %d", truecolor.Len(), quantized.Len())
+	}
+	decoded, err := png.Decode(&quantized)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := decoded.(*image.Paletted); !ok {
+		t.Errorf("expected paletted PNG, got %T", decoded)
+	}
+
+	// JPEG is left alone
+	if _, ok := NewPipeline(img, Quantize(8, false)).Quantize(imaging.JPEG).Image().(*image.Paletted); ok {
+		t.Error("expected JPEG not to be quantized")
+	}
+}
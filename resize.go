@@ -0,0 +1,98 @@
+package upload
+
+import (
+	"image"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	// fastThumbnailMaxSize is the largest target dimension eligible for the fast thumbnail path
+	fastThumbnailMaxSize = 300
+	// fastThumbnailFactor is the size relative to the target the source is box downsampled to
+	fastThumbnailFactor = 2
+)
+
+// fastThumbnailEligible checks if a target size benefits from a two-step resize
+func fastThumbnailEligible(src image.Image, width, height int) bool {
+	if width > fastThumbnailMaxSize || height > fastThumbnailMaxSize {
+		return false
+	}
+
+	bounds := src.Bounds()
+	return bounds.Dx() > width*fastThumbnailFactor*2 && bounds.Dy() > height*fastThumbnailFactor*2
+}
+
+// boxDownsample cheaply downsamples src so that it still covers fastThumbnailFactor times the target size
+func boxDownsample(src image.Image, width, height int) image.Image {
+	bounds := src.Bounds()
+	srcW := float64(bounds.Dx())
+	srcH := float64(bounds.Dy())
+
+	scale := 0.0
+	if width > 0 {
+		scale = float64(width*fastThumbnailFactor) / srcW
+	}
+	if height > 0 && float64(height*fastThumbnailFactor)/srcH > scale {
+		scale = float64(height*fastThumbnailFactor) / srcH
+	}
+	if scale <= 0 || scale >= 1 {
+		return src
+	}
+
+	return imaging.Resize(src, int(srcW*scale+0.5), int(srcH*scale+0.5), imaging.Box)
+}
+
+// resize resizes src preserving the aspect ratio, taking the fast path when enabled
+func resize(src image.Image, width, height int, filter imaging.ResampleFilter, fast bool) image.Image {
+	if fast && fastThumbnailEligible(src, width, height) {
+		src = boxDownsample(src, width, height)
+	}
+	return imaging.Resize(src, width, height, filter)
+}
+
+// fill resizes and crops src to fill the area, taking the fast path when enabled
+func fill(src image.Image, width, height int, filter imaging.ResampleFilter, fast bool) image.Image {
+	if fast && fastThumbnailEligible(src, width, height) {
+		src = boxDownsample(src, width, height)
+	}
+	return imaging.Fill(src, width, height, imaging.Center, filter)
+}
+
+// focalCrop returns the area of a source of the given size to fill width x height,
+// as centered on the focal point (fx, fy) as the source edges allow
+func focalCrop(srcW, srcH, width, height int, fx, fy float64) image.Rectangle {
+	// Largest area of the target aspect ratio fitting in the source
+	cropW, cropH := srcW, srcW*height/width
+	if cropH > srcH {
+		cropW, cropH = srcH*width/height, srcH
+	}
+	if cropW < 1 {
+		cropW = 1
+	}
+	if cropH < 1 {
+		cropH = 1
+	}
+
+	x := int(fx*float64(srcW)+0.5) - cropW/2
+	y := int(fy*float64(srcH)+0.5) - cropH/2
+	if x < 0 {
+		x = 0
+	} else if x > srcW-cropW {
+		x = srcW - cropW
+	}
+	if y < 0 {
+		y = 0
+	} else if y > srcH-cropH {
+		y = srcH - cropH
+	}
+
+	return image.Rect(x, y, x+cropW, y+cropH)
+}
+
+// fillFocal crops src around the focal point (fx, fy) and resizes it to fill the area
+func fillFocal(src image.Image, width, height int, fx, fy float64, filter imaging.ResampleFilter, fast bool) image.Image {
+	bounds := src.Bounds()
+	crop := focalCrop(bounds.Dx(), bounds.Dy(), width, height, fx, fy).Add(bounds.Min)
+	return resize(imaging.Crop(src, crop), width, height, filter, fast)
+}
@@ -0,0 +1,79 @@
+package upload
+
+import (
+	"image"
+	"path/filepath"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+func openTestImage(tb testing.TB) image.Image {
+	img, err := imaging.Open(filepath.Join("testdata", "normal.jpg"))
+	if err != nil {
+		tb.Fatalf("cannot open test image: %v", err)
+	}
+	// Enlarge so that thumbnails are generated at a high ratio
+	return imaging.Resize(img, 2400, 0, imaging.Lanczos)
+}
+
+func TestFastFillQuality(t *testing.T) {
+	src := openTestImage(t)
+
+	slow := imaging.Clone(fill(src, 100, 100, imaging.Lanczos, false))
+	fast := imaging.Clone(fill(src, 100, 100, imaging.Lanczos, true))
+
+	if slow.Bounds() != fast.Bounds() {
+		t.Fatalf("bounds differ: %v != %v", slow.Bounds(), fast.Bounds())
+	}
+
+	// Mean absolute difference per channel must stay small
+	var diff int
+	for i := range slow.Pix {
+		d := int(slow.Pix[i]) - int(fast.Pix[i])
+		if d < 0 {
+			d = -d
+		}
+		diff += d
+	}
+	if mean := float64(diff) / float64(len(slow.Pix)); mean > 4 {
+		t.Errorf("fast thumbnail deviates too much: mean difference %.2f", mean)
+	}
+}
+
+func BenchmarkFill(b *testing.B) {
+	src := openTestImage(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fill(src, 100, 100, imaging.Lanczos, false)
+	}
+}
+
+func BenchmarkFastFill(b *testing.B) {
+	src := openTestImage(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fill(src, 100, 100, imaging.Lanczos, true)
+	}
+}
+
+func TestFocalCrop(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		fx, fy        float64
+		expected      image.Rectangle
+	}{
+		{"Center", 100, 100, 0.5, 0.5, image.Rect(100, 0, 300, 200)},
+		{"Left Third", 100, 100, 0.3, 0.5, image.Rect(20, 0, 220, 200)},
+		{"Clamped Left", 100, 100, 0, 0.5, image.Rect(0, 0, 200, 200)},
+		{"Clamped Right", 100, 100, 1, 0.5, image.Rect(200, 0, 400, 200)},
+		{"Wide", 400, 100, 0.5, 0.9, image.Rect(0, 100, 400, 200)},
+	}
+
+	for _, tt := range tests {
+		if got := focalCrop(400, 200, tt.width, tt.height, tt.fx, tt.fy); got != tt.expected {
+			t.Errorf("%v: expected %v, got %v", tt.name, tt.expected, got)
+		}
+	}
+}
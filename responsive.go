@@ -0,0 +1,188 @@
+package upload
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/disintegration/imaging"
+)
+
+// ResponsiveManifest describes the variants of a responsive set, e.g. to render <picture> elements
+type ResponsiveManifest struct {
+	Source string           `json:"source"`
+	Width  int              `json:"width"`
+	Height int              `json:"height"`
+	Sizes  []ResponsiveSize `json:"sizes"`
+}
+
+// ResponsiveSize holds the variants of a responsive set sharing a size, one per codec
+type ResponsiveSize struct {
+	Width  int              `json:"width"`
+	Height int              `json:"height"`
+	Files  []ResponsiveFile `json:"files"`
+}
+
+// ResponsiveFile describes a variant of a responsive set
+type ResponsiveFile struct {
+	Codec string `json:"codec"`
+	MIME  string `json:"mime"`
+	Path  string `json:"path"`
+	Bytes int64  `json:"bytes"`
+}
+
+// ResponsiveSet generates the variants of the image at baseDiskPath for every width, in every
+// codec (e.g. "jpg", "png"), and writes their manifest as <base>.responsive.json.
+// The source is decoded and normalized as for Process once, and resized once per width for all codecs. Variants are named
+// as formats "<width>w" of those codecs (e.g. name_640w.png), widths above the source being skipped.
+// Codecs imaging cannot encode, such as WebP and AVIF, are rejected.
+func (p *ImageProcessor) ResponsiveSet(baseDiskPath string, widths []int, codecs []string) (*ResponsiveManifest, error) {
+	if len(widths) == 0 || len(codecs) == 0 {
+		return nil, fmt.Errorf("responsive set needs widths and codecs")
+	}
+	imagingFormats := make([]imaging.Format, len(codecs))
+	for i, codec := range codecs {
+		f, err := imaging.FormatFromExtension(normalizeExt(codec))
+		if err != nil {
+			return nil, fmt.Errorf("codec %v unsupported", codec)
+		}
+		imagingFormats[i] = f
+	}
+
+	// Widths by ascending order, once each
+	sorted := append([]int(nil), widths...)
+	sort.Ints(sorted)
+	unique := sorted[:0]
+	for _, width := range sorted {
+		if width > 0 && (len(unique) == 0 || unique[len(unique)-1] != width) {
+			unique = append(unique, width)
+		}
+	}
+	if len(unique) == 0 {
+		return nil, fmt.Errorf("responsive set needs positive widths")
+	}
+
+	var formats []Format
+	for _, width := range unique {
+		for _, codec := range codecs {
+			formats = append(formats, responsiveFormat(width, codec))
+		}
+	}
+	if err := p.options.checkPaths(baseDiskPath, formats...); err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(baseDiskPath)
+	if err != nil {
+		log.Printf("error opening %v: %v\n", baseDiskPath, err)
+		return nil, err
+	}
+	config, imgType, err := image.DecodeConfig(file)
+	file.Close()
+	if err != nil {
+		log.Printf("error decoding image: %v", err)
+		return nil, err
+	}
+
+	reserved := reserve(decodedSize(&config))
+	defer reserved.release()
+
+	src, err := p.diskSource(baseDiskPath, &config, imgType, reserved)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &ResponsiveManifest{Source: baseDiskPath, Width: config.Width, Height: config.Height}
+	for _, width := range unique {
+		if width > config.Width && len(manifest.Sizes) > 0 {
+			continue
+		}
+
+		format := responsiveFormat(width, "")
+		_, quality := src.overrides.apply(format)
+		img := newPipeline(src.img, p.options).Resize(format).Image()
+		size := ResponsiveSize{Width: img.Bounds().Dx(), Height: img.Bounds().Dy()}
+
+		for i, codec := range codecs {
+			format.output = codec
+			fileDiskPath := p.options.variantPath(baseDiskPath, format)
+			n, err := p.encodeResponsive(fileDiskPath, img, imgType, quality, imagingFormats[i])
+			if err != nil {
+				log.Printf("Image encode format error: %v", err)
+				return nil, &FormatError{Name: format.name + "." + codec, Path: baseDiskPath, Err: err}
+			}
+			size.Files = append(size.Files, ResponsiveFile{
+				Codec: normalizeExt(codec),
+				MIME:  variantMIME(baseDiskPath, format, p.options),
+				Path:  fileDiskPath,
+				Bytes: n,
+			})
+		}
+		manifest.Sizes = append(manifest.Sizes, size)
+	}
+
+	if err := writeResponsiveManifest(p.options.storage, baseDiskPath+".responsive.json", manifest); err != nil {
+		log.Printf("Image manifest error: %v", err)
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// responsiveFormat returns the format of the variants of a responsive set of width in codec
+func responsiveFormat(width int, codec string) Format {
+	return Format{name: fmt.Sprintf("%dw", width), width: width, output: codec}
+}
+
+// encodeResponsive encodes img as f to fileDiskPath and returns its size in bytes
+// quality is the JPEG quality set for the image by its overrides file, 0 if none
+func (p *ImageProcessor) encodeResponsive(fileDiskPath string, img image.Image, imgType string, quality int, f imaging.Format) (int64, error) {
+	var encodeOpts []imaging.EncodeOption
+	if p.options.deterministic {
+		encodeOpts = append(encodeOpts, deterministicEncodeOptions...)
+	}
+
+	// Qualities of the image prevail over the type Override, then over the curve
+	if override := p.options.Override(imgType); quality <= 0 && override != nil {
+		quality = override.quality
+	}
+	if quality <= 0 && p.options.qualityFunc != nil {
+		quality = p.options.qualityFunc(img.Bounds().Dx(), img.Bounds().Dy())
+	}
+	if quality > 0 {
+		encodeOpts = append(encodeOpts, imaging.JPEGQuality(quality))
+	}
+
+	w, err := p.createVariant(fileDiskPath)
+	if err != nil {
+		return 0, err
+	}
+	counter := &countingWriter{w: w}
+	if err := newPipeline(img, p.options).Quantize(f).Encode(counter, f, encodeOpts...); err != nil {
+		w.Abort()
+		return 0, err
+	}
+	if err := w.Close(); err != nil {
+		return 0, err
+	}
+	return counter.n, nil
+}
+
+// writeResponsiveManifest writes manifest as JSON at key
+func writeResponsiveManifest(storage Storage, key string, manifest *ResponsiveManifest) error {
+	w, err := storage.Create(key)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(manifest); err != nil {
+		w.Abort()
+		return err
+	}
+
+	return w.Close()
+}
@@ -0,0 +1,148 @@
+package upload
+
+import (
+	"encoding/json"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestResponsiveSet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "responsive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	baseDiskPath := filepath.Join(dir, "a.jpg")
+	if err := imaging.Save(imaging.New(400, 200, color.White), baseDiskPath); err != nil {
+		t.Fatal(err)
+	}
+
+	p := NewImageProcessor()
+	manifest, err := p.ResponsiveSet(baseDiskPath, []int{200, 100, 800, 200}, []string{"jpg", "png"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		width, height int
+		paths         []string
+	}{
+		{100, 50, []string{baseDiskPath + ":100w", filepath.Join(dir, "a_100w.png")}},
+		{200, 100, []string{baseDiskPath + ":200w", filepath.Join(dir, "a_200w.png")}},
+	}
+	if len(manifest.Sizes) != len(expected) {
+		t.Fatalf("expected %d sizes, got %d", len(expected), len(manifest.Sizes))
+	}
+	for i, size := range manifest.Sizes {
+		if size.Width != expected[i].width || size.Height != expected[i].height {
+			t.Errorf("expected size %dx%d, got %dx%d", expected[i].width, expected[i].height, size.Width, size.Height)
+		}
+		for j, file := range size.Files {
+			if file.Path != expected[i].paths[j] {
+				t.Errorf("expected path %v, got %v", expected[i].paths[j], file.Path)
+			}
+			info, err := os.Stat(file.Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Size() != file.Bytes {
+				t.Errorf("expected %d bytes for %v, got %d", info.Size(), file.Path, file.Bytes)
+			}
+		}
+	}
+	if mime := manifest.Sizes[0].Files[1].MIME; mime != "image/png" {
+		t.Errorf("expected image/png, got %v", mime)
+	}
+
+	data, err := ioutil.ReadFile(baseDiskPath + ".responsive.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written ResponsiveManifest
+	if err := json.Unmarshal(data, &written); err != nil {
+		t.Fatal(err)
+	}
+	if len(written.Sizes) != 2 || written.Width != 400 {
+		t.Errorf("unexpected manifest %s", data)
+	}
+
+	if _, err := p.ResponsiveSet(baseDiskPath, []int{100}, []string{"webp"}); err == nil {
+		t.Error("expected webp to be rejected")
+	}
+
+	// Variants written outside of the allowed directories are rejected
+	escaping := NewImageProcessor(AllowedDirs(dir), ConvertedNaming("../{base}_{format}.{ext}"))
+	if _, err := escaping.ResponsiveSet(baseDiskPath, []int{100}, []string{"png"}); err != ErrPathNotAllowed {
+		t.Errorf("expected ErrPathNotAllowed, got %v", err)
+	}
+}
+
+func TestResponsiveSetQuality(t *testing.T) {
+	dir, err := ioutil.TempDir("", "responsive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	baseDiskPath := filepath.Join(dir, "a.jpg")
+	if err := imaging.Save(imaging.New(400, 200, color.White), baseDiskPath); err != nil {
+		t.Fatal(err)
+	}
+
+	override := SourceOverride(TypeImageJPEG, OverrideQuality(10))
+	expected, err := NewImageProcessor(override).ResponsiveSet(baseDiskPath, []int{200}, []string{"jpg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The type quality prevails over the curve
+	called := false
+	p := NewImageProcessor(override, QualityFunc(func(width, height int) int {
+		called = true
+		return 95
+	}))
+	manifest, err := p.ResponsiveSet(baseDiskPath, []int{200}, []string{"jpg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if called {
+		t.Error("expected the quality curve to be skipped")
+	}
+	if manifest.Sizes[0].Files[0].Bytes != expected.Sizes[0].Files[0].Bytes {
+		t.Errorf("expected %d bytes, got %d", expected.Sizes[0].Files[0].Bytes, manifest.Sizes[0].Files[0].Bytes)
+	}
+}
+
+func TestResponsiveSetConvertSRGB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "responsive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	baseDiskPath := filepath.Join(dir, "wide.jpg")
+	content := withSegments(greenJPEG(t), iccSegment(buildICCProfile(adobeRGBColorants, 2.2)))
+	if err := ioutil.WriteFile(baseDiskPath, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The source is normalized as for Process
+	manifest, err := NewImageProcessor(ConvertSRGB(true)).ResponsiveSet(baseDiskPath, []int{8}, []string{"png"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := imaging.Open(manifest.Sizes[0].Files[0].Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Adobe RGB greens are more saturated than sRGB ones
+	if r, g, _, _ := img.At(4, 4).RGBA(); r>>8 >= 90 || g>>8 < 200 {
+		t.Errorf("expected colors converted to sRGB, got %v", img.At(4, 4))
+	}
+}
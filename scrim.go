@@ -0,0 +1,57 @@
+package upload
+
+import (
+	"image"
+	"image/color"
+
+	"github.com/disintegration/imaging"
+)
+
+// scrim holds a gradient overlaid on a side of variants
+type scrim struct {
+	side  int         // Left, Right, Top or Bottom
+	color color.NRGBA // Color of the gradient, its alpha scaling the opacities
+	from  float64     // Opacity at the side
+	to    float64     // Opacity at the opposite side
+}
+
+// opacity returns the opacity of the scrim at pos along its axis of length size
+func (s *scrim) opacity(pos, size int) float64 {
+	t := 0.0
+	if size > 1 {
+		t = float64(pos) / float64(size-1)
+	}
+	// Gradients run from the side towards the opposite one
+	if s.side == Right || s.side == Bottom {
+		t = 1 - t
+	}
+	return s.from + (s.to-s.from)*t
+}
+
+// apply composites the scrim over img
+func (s *scrim) apply(img image.Image) *image.NRGBA {
+	dst := imaging.Clone(img)
+	w, h := dst.Bounds().Dx(), dst.Bounds().Dy()
+	colorAlpha := float64(s.color.A) / 255
+	c := [3]float64{float64(s.color.R), float64(s.color.G), float64(s.color.B)}
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var a float64
+			if s.side == Left || s.side == Right {
+				a = s.opacity(x, w)
+			} else {
+				a = s.opacity(y, h)
+			}
+			a = clampFraction(a) * colorAlpha
+
+			i := y*dst.Stride + x*4
+			for ch := 0; ch < 3; ch++ {
+				dst.Pix[i+ch] = uint8(float64(dst.Pix[i+ch])*(1-a) + c[ch]*a + 0.5)
+			}
+			// Transparent pixels become as opaque as the scrim over them
+			dst.Pix[i+3] = uint8(float64(dst.Pix[i+3])*(1-a) + 255*a + 0.5)
+		}
+	}
+	return dst
+}
@@ -0,0 +1,84 @@
+package upload
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// Sidecar describes a variant, written as <variant>.json next to it
+// Its JSON schema is stable:
+//
+//	{
+//	  "format": "thumb",          // format name
+//	  "width": 200,               // width in pixels
+//	  "height": 200,              // height in pixels
+//	  "codec": "jpeg",            // encoding of the variant
+//	  "bytes": 12345,             // size of the variant in bytes
+//	  "dominant_color": "#1a2b3c" // average color of the variant
+//	}
+type Sidecar struct {
+	Format        string `json:"format"`
+	Width         int    `json:"width"`
+	Height        int    `json:"height"`
+	Codec         string `json:"codec"`
+	Bytes         int64  `json:"bytes"`
+	DominantColor string `json:"dominant_color"`
+}
+
+// newSidecar returns the Sidecar of an encoded variant
+func newSidecar(format Format, img image.Image, codec imaging.Format, size int64) Sidecar {
+	bounds := img.Bounds()
+	return Sidecar{
+		Format:        format.name,
+		Width:         bounds.Dx(),
+		Height:        bounds.Dy(),
+		Codec:         strings.ToLower(codec.String()),
+		Bytes:         size,
+		DominantColor: dominantColor(img),
+	}
+}
+
+// writeSidecar writes the Sidecar of a variant to storage
+func writeSidecar(storage Storage, fileDiskPath string, sidecar Sidecar) error {
+	w, err := storage.Create(fileDiskPath + ".json")
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(sidecar); err != nil {
+		w.Abort()
+		return err
+	}
+
+	return w.Close()
+}
+
+// dominantColor returns the average color of img as a hex string
+func dominantColor(img image.Image) string {
+	if img.Bounds().Empty() {
+		return ""
+	}
+
+	avg := color.NRGBAModel.Convert(imaging.Resize(img, 1, 1, imaging.Box).At(0, 0)).(color.NRGBA)
+	return fmt.Sprintf("#%02x%02x%02x", avg.R, avg.G, avg.B)
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
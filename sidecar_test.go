@@ -0,0 +1,60 @@
+package upload
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+// memStorage implements the Storage interface in memory
+type memStorage struct {
+	files map[string]*bytes.Buffer
+}
+
+func newMemStorage() *memStorage {
+	return &memStorage{files: make(map[string]*bytes.Buffer)}
+}
+
+// Create returns a writer storing the variant at key once closed
+func (s *memStorage) Create(key string) (StorageWriter, error) {
+	return &memWriter{Buffer: &bytes.Buffer{}, storage: s, key: key}, nil
+}
+
+func (s *memStorage) Delete(key string) error {
+	delete(s.files, key)
+	return nil
+}
+
+type memWriter struct {
+	*bytes.Buffer
+	storage *memStorage
+	key     string
+}
+
+func (w *memWriter) Close() error     { w.storage.files[w.key] = w.Buffer; return nil }
+func (w *memWriter) Abort() error     { return nil }
+func (w *memWriter) Location() string { return w.key }
+
+func TestWriteSidecar(t *testing.T) {
+	storage := newMemStorage()
+	sidecar := Sidecar{Format: "thumb", Width: 200, Height: 100, Codec: "jpeg", Bytes: 1234, DominantColor: "#ffffff"}
+
+	if err := writeSidecar(storage, "image.jpg:thumb", sidecar); err != nil {
+		t.Fatal(err)
+	}
+
+	buf, ok := storage.files["image.jpg:thumb.json"]
+	if !ok {
+		t.Fatal("sidecar not written")
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &fields); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"format", "width", "height", "codec", "bytes", "dominant_color"} {
+		if _, ok := fields[key]; !ok {
+			t.Errorf("sidecar missing %q", key)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package upload
+
+import (
+	"fmt"
+	"html"
+	"image"
+	"log"
+	"mime"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// srcSetEntry is a variant candidate of a srcset
+type srcSetEntry struct {
+	url   string
+	width int
+	mime  string
+}
+
+// srcSetEntries returns the existing variants of an image by ascending width,
+// one per width, with their URL under baseURL
+func (p *ImageProcessor) srcSetEntries(baseURL, baseDiskPath string) ([]srcSetEntry, error) {
+	variants := p.ListVariants(baseDiskPath)
+
+	var entries []srcSetEntry
+	seen := make(map[string]bool)
+	for _, format := range p.options.formats {
+		fileDiskPath, ok := variants[format.name]
+		if !ok {
+			continue
+		}
+
+		width, err := variantWidth(fileDiskPath)
+		if err != nil {
+			log.Printf("error reading variant %v: %v\n", fileDiskPath, err)
+			return nil, err
+		}
+
+		entry := srcSetEntry{
+			url:   variantURL(baseURL, baseDiskPath, fileDiskPath),
+			width: width,
+			mime:  variantMIME(baseDiskPath, format, p.options),
+		}
+		key := fmt.Sprintf("%s %d", entry.mime, entry.width)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		entries = append(entries, entry)
+	}
+
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no variants of %v", baseDiskPath)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].width < entries[j].width })
+	return entries, nil
+}
+
+// variantURL returns the URL under baseURL of the variant at fileDiskPath of the image at
+// baseDiskPath, its path relative to the image directory (e.g. ShardDir subdirectories) escaped
+func variantURL(baseURL, baseDiskPath, fileDiskPath string) string {
+	rel, err := filepath.Rel(filepath.Dir(baseDiskPath), fileDiskPath)
+	if err != nil {
+		rel = filepath.Base(fileDiskPath)
+	}
+
+	segments := strings.Split(filepath.ToSlash(rel), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.TrimSuffix(baseURL, "/") + "/" + strings.Join(segments, "/")
+}
+
+// variantWidth returns the width of the variant at fileDiskPath
+func variantWidth(fileDiskPath string) (int, error) {
+	file, err := os.Open(fileDiskPath)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return 0, err
+	}
+	return config.Width, nil
+}
+
+// variantMIME returns the MIME type of the variant of an image for a specific format
+func variantMIME(baseDiskPath string, format Format, options *OptionsImage) string {
+	ext := filepath.Ext(baseDiskPath)
+	if options.converted(baseDiskPath, format) {
+		ext = "." + normalizeExt(format.output)
+	}
+	return mime.TypeByExtension(ext)
+}
+
+// joinSrcSet formats entries as a srcset attribute value
+func joinSrcSet(entries []srcSetEntry) string {
+	candidates := make([]string, len(entries))
+	for i, entry := range entries {
+		candidates[i] = fmt.Sprintf("%s %dw", entry.url, entry.width)
+	}
+	return strings.Join(candidates, ", ")
+}
+
+// SrcSet returns the srcset of the existing variants of an image, e.g. "/media/a.jpg:thumb 400w, /media/a.jpg:hero 800w"
+// Variant URLs are their paths relative to the image directory under baseURL
+func (p *ImageProcessor) SrcSet(baseURL, baseDiskPath string) (string, error) {
+	entries, err := p.srcSetEntries(baseURL, baseDiskPath)
+	if err != nil {
+		return "", err
+	}
+
+	return joinSrcSet(entries), nil
+}
+
+// Picture returns a <picture> element offering the existing variants of an image
+// Variants encoded in another format than the image (see OutputFormat) are offered as <source>
+// elements by MIME type, before the <img> fallback listing variants in the format of the image
+func (p *ImageProcessor) Picture(baseURL, baseDiskPath, alt string) (string, error) {
+	entries, err := p.srcSetEntries(baseURL, baseDiskPath)
+	if err != nil {
+		return "", err
+	}
+
+	srcMIME := mime.TypeByExtension(filepath.Ext(baseDiskPath))
+	var (
+		types  []string
+		byType = make(map[string][]srcSetEntry)
+	)
+	for _, entry := range entries {
+		if _, ok := byType[entry.mime]; !ok && entry.mime != srcMIME {
+			types = append(types, entry.mime)
+		}
+		byType[entry.mime] = append(byType[entry.mime], entry)
+	}
+
+	var b strings.Builder
+	b.WriteString("<picture>")
+	for _, t := range types {
+		fmt.Fprintf(&b, `<source type="%s" srcset="%s">`, html.EscapeString(t), html.EscapeString(joinSrcSet(byType[t])))
+	}
+
+	// Fall back to the largest variant when none is in the format of the image
+	fallback := byType[srcMIME]
+	if len(fallback) == 0 {
+		fallback = entries[len(entries)-1:]
+	}
+	fmt.Fprintf(&b, `<img src="%s" srcset="%s" alt="%s">`,
+		html.EscapeString(fallback[len(fallback)-1].url), html.EscapeString(joinSrcSet(fallback)), html.EscapeString(alt))
+	b.WriteString("</picture>")
+
+	return b.String(), nil
+}
@@ -0,0 +1,98 @@
+package upload
+
+import (
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestSrcSet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "srcset")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := NewImageProcessor(
+		Formats("small", 100, 0, false),
+		Formats("large", 300, 0, false),
+		Formats("missing", 500, 0, false),
+		Formats("card", 200, 0, false),
+		FormatOptions("card", OutputFormat("png")),
+	)
+
+	baseDiskPath := filepath.Join(dir, "a.jpg")
+	for _, name := range []string{"small", "large", "card"} {
+		format, _ := p.options.Format(name)
+		f, _ := p.options.outputFormat(baseDiskPath, format)
+		file, err := os.Create(p.options.variantPath(baseDiskPath, format))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := imaging.Encode(file, imaging.New(format.width, 50, color.White), f); err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+	}
+
+	srcSet, err := p.SrcSet("/media/", baseDiskPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "/media/a.jpg:small 100w, /media/a_card.png 200w, /media/a.jpg:large 300w"
+	if srcSet != expected {
+		t.Errorf("expected srcset %q, got %q", expected, srcSet)
+	}
+
+	picture, err := p.Picture("/media", baseDiskPath, `"a"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = `<picture><source type="image/png" srcset="/media/a_card.png 200w">` +
+		`<img src="/media/a.jpg:large" srcset="/media/a.jpg:small 100w, /media/a.jpg:large 300w" alt="&#34;a&#34;"></picture>`
+	if picture != expected {
+		t.Errorf("expected picture %q, got %q", expected, picture)
+	}
+
+	if _, err := p.SrcSet("/media", filepath.Join(dir, "none.jpg")); err == nil {
+		t.Error("expected error without variants")
+	}
+}
+
+func TestSrcSetSharded(t *testing.T) {
+	dir, err := ioutil.TempDir("", "srcset")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := NewImageProcessor(Formats("small", 100, 0, false), ShardLevels(2))
+
+	baseDiskPath := filepath.Join(dir, "a b.jpg")
+	format, _ := p.options.Format("small")
+	fileDiskPath := p.options.variantPath(baseDiskPath, format)
+	if err := os.MkdirAll(filepath.Dir(fileDiskPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Create(fileDiskPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := imaging.Encode(file, imaging.New(100, 50, color.White), imaging.JPEG); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	srcSet, err := p.SrcSet("/media", baseDiskPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "/media/" + ShardDir("a b.jpg", 2) + "/a%20b.jpg:small 100w"
+	if srcSet != expected {
+		t.Errorf("expected srcset %q, got %q", expected, srcSet)
+	}
+}
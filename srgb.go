@@ -0,0 +1,263 @@
+package upload
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"log"
+	"math"
+)
+
+// xyzD50ToLinearSRGB converts D50 adapted XYZ, the ICC connection space, to linear sRGB
+var xyzD50ToLinearSRGB = [3][3]float64{
+	{3.1338561, -1.6168667, -0.4906146},
+	{-0.9787684, 1.9161415, 0.0334540},
+	{0.0719453, -0.2289914, 1.4052427},
+}
+
+// srgbColorants holds the red, green and blue colorants of sRGB in D50 adapted XYZ
+var srgbColorants = [3][3]float64{
+	{0.4360747, 0.2225045, 0.0139322},
+	{0.3850649, 0.7168786, 0.0971045},
+	{0.1430804, 0.0606169, 0.7141733},
+}
+
+// iccTransform converts pixels of an RGB matrix/TRC ICC profile to sRGB
+type iccTransform struct {
+	curves [3][256]float64 // Linear value of every 8 bit component
+	matrix [3][3]float64   // Linear source RGB to linear sRGB
+}
+
+// newICCTransform parses the colorant and tone curve tags of an RGB ICC profile
+// It returns nil if the profile is already sRGB
+func newICCTransform(profile []byte) (*iccTransform, error) {
+	if len(profile) < 132 || string(profile[16:20]) != "RGB " {
+		return nil, fmt.Errorf("icc profile not rgb")
+	}
+
+	tags := map[string][]byte{}
+	count := int(binary.BigEndian.Uint32(profile[128:]))
+	for i := 0; i < count; i++ {
+		entry := 132 + i*12
+		if entry+12 > len(profile) {
+			return nil, fmt.Errorf("icc tag table truncated")
+		}
+		offset := int(binary.BigEndian.Uint32(profile[entry+4:]))
+		size := int(binary.BigEndian.Uint32(profile[entry+8:]))
+		if offset < 0 || size < 0 || offset+size > len(profile) {
+			return nil, fmt.Errorf("icc tag %q out of bounds", profile[entry:entry+4])
+		}
+		tags[string(profile[entry:entry+4])] = profile[offset : offset+size]
+	}
+
+	var colorants [3][3]float64
+	for i, sig := range []string{"rXYZ", "gXYZ", "bXYZ"} {
+		xyz, err := parseICCXYZ(tags[sig])
+		if err != nil {
+			return nil, fmt.Errorf("icc tag %v: %v", sig, err)
+		}
+		colorants[i] = xyz
+	}
+
+	t := &iccTransform{}
+	srgbCurves := true
+	for i, sig := range []string{"rTRC", "gTRC", "bTRC"} {
+		curve, err := parseICCCurve(tags[sig])
+		if err != nil {
+			return nil, fmt.Errorf("icc tag %v: %v", sig, err)
+		}
+		for v := range t.curves[i] {
+			t.curves[i][v] = curve(float64(v) / 255)
+			if math.Abs(t.curves[i][v]-srgbToLinear(float64(v)/255)) > 0.002 {
+				srgbCurves = false
+			}
+		}
+	}
+
+	if srgbCurves && sameColorants(colorants, srgbColorants) {
+		return nil, nil
+	}
+
+	// Linear source RGB to XYZ, colorants being the matrix columns, then to linear sRGB
+	for r := 0; r < 3; r++ {
+		for c := 0; c < 3; c++ {
+			for k := 0; k < 3; k++ {
+				t.matrix[r][c] += xyzD50ToLinearSRGB[r][k] * colorants[c][k]
+			}
+		}
+	}
+	return t, nil
+}
+
+// convertToSRGB returns img converted to sRGB according to the ICC profile embedded in content
+// img is returned untouched if content embeds no profile or an sRGB one
+func convertToSRGB(img image.Image, content []byte) image.Image {
+	profile := iccProfile(content)
+	if len(profile) == 0 {
+		return img
+	}
+
+	t, err := newICCTransform(profile)
+	if err != nil {
+		log.Printf("Image icc profile error: %v\n", err)
+		return img
+	}
+	if t == nil {
+		return img
+	}
+	return t.apply(img)
+}
+
+// apply returns img converted to sRGB
+func (t *iccTransform) apply(img image.Image) *image.NRGBA {
+	bounds := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := img.At(x, y).RGBA()
+			i := dst.PixOffset(x-bounds.Min.X, y-bounds.Min.Y)
+			if a == 0 {
+				continue
+			}
+
+			// Unpremultiply to 8 bit components
+			lin := [3]float64{
+				t.curves[0][uint8(r*0xFFFF/a>>8)],
+				t.curves[1][uint8(g*0xFFFF/a>>8)],
+				t.curves[2][uint8(b*0xFFFF/a>>8)],
+			}
+			for c := 0; c < 3; c++ {
+				v := t.matrix[c][0]*lin[0] + t.matrix[c][1]*lin[1] + t.matrix[c][2]*lin[2]
+				dst.Pix[i+c] = uint8(linearToSRGB(v)*255 + 0.5)
+			}
+			dst.Pix[i+3] = uint8(a >> 8)
+		}
+	}
+	return dst
+}
+
+// parseICCXYZ parses an XYZType tag
+func parseICCXYZ(tag []byte) ([3]float64, error) {
+	var xyz [3]float64
+	if len(tag) < 20 || string(tag[:4]) != "XYZ " {
+		return xyz, fmt.Errorf("not an XYZ tag")
+	}
+	for i := range xyz {
+		xyz[i] = s15Fixed16(tag[8+i*4:])
+	}
+	return xyz, nil
+}
+
+// parseICCCurve parses a curveType or parametricCurveType tag
+func parseICCCurve(tag []byte) (func(float64) float64, error) {
+	if len(tag) < 12 {
+		return nil, fmt.Errorf("curve truncated")
+	}
+
+	switch string(tag[:4]) {
+	case "curv":
+		count := int(binary.BigEndian.Uint32(tag[8:]))
+		if len(tag) < 12+count*2 {
+			return nil, fmt.Errorf("curve truncated")
+		}
+		switch count {
+		case 0:
+			return func(v float64) float64 { return v }, nil
+		case 1:
+			gamma := float64(binary.BigEndian.Uint16(tag[12:])) / 256
+			return func(v float64) float64 { return math.Pow(v, gamma) }, nil
+		}
+		table := make([]float64, count)
+		for i := range table {
+			table[i] = float64(binary.BigEndian.Uint16(tag[12+i*2:])) / 0xFFFF
+		}
+		return func(v float64) float64 {
+			pos := v * float64(count-1)
+			i := int(pos)
+			if i >= count-1 {
+				return table[count-1]
+			}
+			return table[i] + (table[i+1]-table[i])*(pos-float64(i))
+		}, nil
+
+	case "para":
+		// Parameters g, a, b, c, d, e, f as used by the function type
+		counts := []int{1, 3, 4, 5, 7}
+		fn := int(binary.BigEndian.Uint16(tag[8:]))
+		if fn >= len(counts) || len(tag) < 12+counts[fn]*4 {
+			return nil, fmt.Errorf("parametric curve %d unsupported", fn)
+		}
+		p := make([]float64, 7)
+		for i := 0; i < counts[fn]; i++ {
+			p[i] = s15Fixed16(tag[12+i*4:])
+		}
+		g, a, b, c, d, e, f := p[0], p[1], p[2], p[3], p[4], p[5], p[6]
+		return func(v float64) float64 {
+			switch fn {
+			case 0:
+				return math.Pow(v, g)
+			case 1:
+				if v >= -b/a {
+					return math.Pow(a*v+b, g)
+				}
+				return 0
+			case 2:
+				if v >= -b/a {
+					return math.Pow(a*v+b, g) + c
+				}
+				return c
+			case 3:
+				if v >= d {
+					return math.Pow(a*v+b, g)
+				}
+				return c * v
+			default:
+				if v >= d {
+					return math.Pow(a*v+b, g) + e
+				}
+				return c*v + f
+			}
+		}, nil
+	}
+
+	return nil, fmt.Errorf("not a curve tag")
+}
+
+// s15Fixed16 decodes a signed 15.16 fixed point number
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+// sameColorants checks if colorants match within the precision of profiles
+func sameColorants(a, b [3][3]float64) bool {
+	for i := range a {
+		for j := range a[i] {
+			if math.Abs(a[i][j]-b[i][j]) > 0.002 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// srgbToLinear decodes an sRGB component
+func srgbToLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// linearToSRGB encodes a linear component to sRGB, clipping out of gamut values
+func linearToSRGB(v float64) float64 {
+	switch {
+	case v <= 0:
+		return 0
+	case v >= 1:
+		return 1
+	case v <= 0.0031308:
+		return v * 12.92
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
@@ -0,0 +1,155 @@
+package upload
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/lsldigital/gocipe-upload/core"
+)
+
+// buildICCProfile builds an RGB matrix/TRC profile with a gamma curve
+func buildICCProfile(colorants [3][3]float64, gamma float64) []byte {
+	var data []byte
+	type tag struct {
+		sig  string
+		data []byte
+	}
+	var tags []tag
+
+	for i, sig := range []string{"rXYZ", "gXYZ", "bXYZ"} {
+		xyz := append([]byte("XYZ "), 0, 0, 0, 0)
+		for _, v := range colorants[i] {
+			xyz = appendUint32BE(xyz, uint32(int32(v*65536+0.5)))
+		}
+		tags = append(tags, tag{sig, xyz})
+	}
+	curve := append([]byte("curv"), 0, 0, 0, 0, 0, 0, 0, 1)
+	curve = append(curve, byte(int(gamma*256+0.5)>>8), byte(int(gamma*256+0.5)))
+	for _, sig := range []string{"rTRC", "gTRC", "bTRC"} {
+		tags = append(tags, tag{sig, curve})
+	}
+
+	header := make([]byte, 128)
+	copy(header[16:], "RGB ")
+	data = append(header, 0, 0, 0, byte(len(tags)))
+
+	offset := 132 + len(tags)*12
+	var body []byte
+	for _, t := range tags {
+		data = append(data, t.sig...)
+		data = appendUint32BE(data, uint32(offset+len(body)))
+		data = appendUint32BE(data, uint32(len(t.data)))
+		body = append(body, t.data...)
+	}
+	return append(data, body...)
+}
+
+func appendUint32BE(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func TestICCTransform(t *testing.T) {
+	adobeRGB := [3][3]float64{
+		{0.6097559, 0.3111242, 0.0194811},
+		{0.2052401, 0.6256560, 0.0608902},
+		{0.1492240, 0.0632197, 0.7448387},
+	}
+
+	transform, err := newICCTransform(buildICCProfile(adobeRGB, 2.2))
+	if err != nil || transform == nil {
+		t.Fatalf("expected transform, got %v", err)
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	img.Set(0, 0, color.NRGBA{128, 128, 128, 255})
+	img.Set(1, 0, color.NRGBA{100, 200, 100, 255})
+	out := transform.apply(img)
+
+	gray := out.NRGBAAt(0, 0)
+	for _, c := range []uint8{gray.R, gray.G, gray.B} {
+		if c < 126 || c > 130 {
+			t.Errorf("expected gray preserved, got %v", gray)
+		}
+	}
+
+	// Adobe RGB greens are more saturated than sRGB ones
+	green := out.NRGBAAt(1, 0)
+	if green.R >= 100 || green.G <= 200 {
+		t.Errorf("expected saturation increased, got %v", green)
+	}
+
+	transform, err = newICCTransform(buildICCProfile(srgbColorants, 2.2))
+	if err != nil || transform == nil {
+		t.Errorf("expected transform for gamma 2.2 curves, got %v", err)
+	}
+
+	if _, err := newICCTransform([]byte("short")); err == nil {
+		t.Error("expected error for truncated profile")
+	}
+}
+
+func TestConvertSRGBWithMaxICCSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "srgb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	profile := buildICCProfile(adobeRGBColorants, 2.2)
+	content := withSegments(greenJPEG(t), iccSegment(profile))
+
+	// The profile is either kept and converted by processing or dropped and converted on upload
+	var greens []color.Color
+	for _, maxICCSize := range []int{core.NoLimit, len(profile) - 1} {
+		uploader := NewImageUploader(
+			EvaluateOptions(Dir(dir), MaxICCSize(maxICCSize)),
+			Formats("thumb", 8, 8, false),
+			ConvertSRGB(true),
+		)
+		uploaded, err := uploader.Upload("wide.jpg", content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		job, err := uploader.Processor.Process(uploaded, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		<-job.Done
+		if job.Err != nil || len(job.Variants) != 1 {
+			t.Fatalf("expected thumb variant, got %v", job.Err)
+		}
+
+		file, err := os.Open(job.Variants[0].Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, _, err := image.Decode(file)
+		file.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		greens = append(greens, img.At(4, 4))
+	}
+
+	for i, green := range greens {
+		// Adobe RGB greens are more saturated than sRGB ones
+		r, g, _, _ := green.RGBA()
+		if r>>8 >= 90 || g>>8 < 200 {
+			t.Errorf("case %d: expected colors converted to sRGB, got %v", i, green)
+		}
+	}
+	r0, g0, b0, _ := greens[0].RGBA()
+	r1, g1, b1, _ := greens[1].RGBA()
+	for _, d := range []int{int(r0>>8) - int(r1>>8), int(g0>>8) - int(g1>>8), int(b0>>8) - int(b1>>8)} {
+		if d < -8 || d > 8 {
+			t.Errorf("expected same colors with and without MaxICCSize, got %v and %v", greens[0], greens[1])
+			break
+		}
+	}
+}
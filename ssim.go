@@ -0,0 +1,113 @@
+package upload
+
+import (
+	"image"
+	"image/color"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	// ssimMinQuality and ssimMaxQuality bound the JPEG quality search
+	ssimMinQuality = 30
+	ssimMaxQuality = 95
+	// ssimWindow is the size of the square windows SSIM is averaged over
+	ssimWindow = 8
+)
+
+// searchJPEGQuality binary searches the lowest JPEG quality whose output keeps
+// an SSIM of at least target against ref.
+// Every step costs a full JPEG encode and decode of ref, so a search over the
+// default range costs about 6 extra encode/decode round trips per variant.
+func searchJPEGQuality(ref image.Image, target float64) (int, error) {
+	refGray := toGray(ref)
+
+	low, high := ssimMinQuality, ssimMaxQuality
+	best := ssimMaxQuality
+	for low <= high {
+		quality := (low + high) / 2
+
+		buf := _encodeBuffers.get()
+		if err := imaging.Encode(buf, ref, imaging.JPEG, imaging.JPEGQuality(quality)); err != nil {
+			_encodeBuffers.put(buf)
+			return 0, err
+		}
+		encoded, err := imaging.Decode(buf)
+		_encodeBuffers.put(buf)
+		if err != nil {
+			return 0, err
+		}
+
+		if ssim(refGray, toGray(encoded)) >= target {
+			best = quality
+			high = quality - 1
+		} else {
+			low = quality + 1
+		}
+	}
+
+	return best, nil
+}
+
+// toGray converts img to grayscale luminance
+func toGray(img image.Image) *image.Gray {
+	bounds := img.Bounds()
+	gray := image.NewGray(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			gray.Set(x, y, color.GrayModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)))
+		}
+	}
+	return gray
+}
+
+// ssim returns the mean structural similarity of two grayscale images of the same size
+// computed over non-overlapping ssimWindow x ssimWindow windows
+func ssim(a, b *image.Gray) float64 {
+	const (
+		c1 = (0.01 * 255) * (0.01 * 255)
+		c2 = (0.03 * 255) * (0.03 * 255)
+	)
+
+	bounds := a.Bounds()
+	if bounds != b.Bounds() {
+		return 0
+	}
+
+	var (
+		total   float64
+		windows int
+	)
+	for y := 0; y+ssimWindow <= bounds.Dy(); y += ssimWindow {
+		for x := 0; x+ssimWindow <= bounds.Dx(); x += ssimWindow {
+			var sumA, sumB, sumAA, sumBB, sumAB float64
+			for wy := y; wy < y+ssimWindow; wy++ {
+				for wx := x; wx < x+ssimWindow; wx++ {
+					pa := float64(a.GrayAt(wx, wy).Y)
+					pb := float64(b.GrayAt(wx, wy).Y)
+					sumA += pa
+					sumB += pb
+					sumAA += pa * pa
+					sumBB += pb * pb
+					sumAB += pa * pb
+				}
+			}
+
+			n := float64(ssimWindow * ssimWindow)
+			meanA := sumA / n
+			meanB := sumB / n
+			varA := sumAA/n - meanA*meanA
+			varB := sumBB/n - meanB*meanB
+			covAB := sumAB/n - meanA*meanB
+
+			total += ((2*meanA*meanB + c1) * (2*covAB + c2)) /
+				((meanA*meanA + meanB*meanB + c1) * (varA + varB + c2))
+			windows++
+		}
+	}
+
+	if windows == 0 {
+		return 1
+	}
+	return total / float64(windows)
+}
@@ -0,0 +1,37 @@
+package upload
+
+import (
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestSSIM(t *testing.T) {
+	img := openTestImage(t)
+	gray := toGray(img)
+
+	if v := ssim(gray, gray); v < 0.999 {
+		t.Errorf("identical images should have SSIM 1, got %f", v)
+	}
+
+	blurred := toGray(imaging.Blur(img, 3))
+	if v := ssim(gray, blurred); v >= 0.999 {
+		t.Errorf("blurred image should have SSIM < 1, got %f", v)
+	}
+}
+
+func TestSearchJPEGQuality(t *testing.T) {
+	img := imaging.Resize(openTestImage(t), 200, 0, imaging.Lanczos)
+
+	low, err := searchJPEGQuality(img, 0.80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	high, err := searchJPEGQuality(img, 0.99)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if low > high {
+		t.Errorf("lower SSIM target should not need higher quality: %d > %d", low, high)
+	}
+}
@@ -0,0 +1,57 @@
+package upload
+
+import (
+	"expvar"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	// _expvarMu guards the check and publication of expvar names
+	_expvarMu sync.Mutex
+)
+
+// Stats reports the activity of an ImageProcessor since its creation
+type Stats struct {
+	QueueDepth  int           // Jobs accepted and not done yet
+	Processed   int           // Jobs done, including errored ones
+	Errored     int           // Jobs done with an error
+	AvgDuration time.Duration // Average time from acceptance to done of the jobs processed
+}
+
+// stats returns the Stats of the jobs of the registry
+func (r *jobRegistry) stats() Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats := Stats{
+		QueueDepth: r.pending,
+		Processed:  r.completed,
+		Errored:    r.errored,
+	}
+	if r.completed > 0 {
+		stats.AvgDuration = r.elapsed / time.Duration(r.completed)
+	}
+	return stats
+}
+
+// Stats returns the activity of the processor
+func (p *ImageProcessor) Stats() Stats {
+	return p.jobs.stats()
+}
+
+// PublishExpvar publishes the Stats of the processor as the expvar name, e.g. on /debug/vars
+// Each processor needs its own name; names already published are rejected
+func (p *ImageProcessor) PublishExpvar(name string) error {
+	_expvarMu.Lock()
+	defer _expvarMu.Unlock()
+
+	if expvar.Get(name) != nil {
+		return fmt.Errorf("expvar %v already published", name)
+	}
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return p.Stats()
+	}))
+	return nil
+}
@@ -0,0 +1,264 @@
+package upload
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Storage represents where processed variants are written (SMI)
+type Storage interface {
+	// Create returns a writer streaming the variant stored at key
+	Create(key string) (StorageWriter, error)
+	// Delete removes the variant stored at key
+	Delete(key string) error
+}
+
+// StorageWriter streams a variant to its storage
+// Implementations may upload in parts as data is written (e.g. multipart uploads)
+// so that memory stays flat regardless of output size
+type StorageWriter interface {
+	io.WriteCloser
+	// Abort discards the data written instead of closing, e.g. when encoding failed,
+	// leaving the variant already stored at the key, if any, as is
+	Abort() error
+	// Location returns the final URL or key of the variant once closed
+	Location() string
+}
+
+// DiskStorage implements the Storage interface on local disk
+type DiskStorage struct{}
+
+// NewDiskStorage returns a new DiskStorage
+func NewDiskStorage() *DiskStorage {
+	return &DiskStorage{}
+}
+
+// Create creates the file at key on disk
+// Data is written to a temporary file renamed to key on close, so that a file
+// being replaced is never served torn; missing parent directories are created
+func (s *DiskStorage) Create(key string) (StorageWriter, error) {
+	if err := os.MkdirAll(filepath.Dir(key), 0755); err != nil {
+		return nil, err
+	}
+	file, err := ioutil.TempFile(filepath.Dir(key), filepath.Base(key)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	return &diskWriter{File: file, key: key}, nil
+}
+
+// createExclusive creates the file at key on disk like Create, but closing the writer
+// fails with ErrVariantExists rather than replacing a file at key
+func (s *DiskStorage) createExclusive(key string) (StorageWriter, error) {
+	w, err := s.Create(key)
+	if err != nil {
+		return nil, err
+	}
+	w.(*diskWriter).exclusive = true
+	return w, nil
+}
+
+// createVersioned creates the file at key on disk like Create, but closing the writer
+// keeps the file it replaces at key, if any, as <key>.N
+func (s *DiskStorage) createVersioned(key string) (StorageWriter, error) {
+	w, err := s.Create(key)
+	if err != nil {
+		return nil, err
+	}
+	w.(*diskWriter).versioned = true
+	return w, nil
+}
+
+// Delete removes the file at key from disk
+func (s *DiskStorage) Delete(key string) error {
+	return os.Remove(key)
+}
+
+// diskWriter implements the StorageWriter interface for DiskStorage
+type diskWriter struct {
+	*os.File
+	key       string
+	exclusive bool
+	versioned bool
+}
+
+// Close closes the temporary file and renames it to its key
+func (w *diskWriter) Close() error {
+	if err := w.File.Close(); err != nil {
+		os.Remove(w.Name())
+		return err
+	}
+	if err := os.Chmod(w.Name(), 0644); err != nil {
+		os.Remove(w.Name())
+		return err
+	}
+	if w.exclusive {
+		// Linking fails rather than replacing an existing file
+		err := os.Link(w.Name(), w.key)
+		os.Remove(w.Name())
+		if os.IsExist(err) {
+			return ErrVariantExists
+		}
+		return err
+	}
+	if w.versioned {
+		if err := versionFile(w.key); err != nil {
+			log.Printf("error versioning %v: %v\n", w.key, err)
+			os.Remove(w.Name())
+			return err
+		}
+	}
+	if err := os.Rename(w.Name(), w.key); err != nil {
+		os.Remove(w.Name())
+		return err
+	}
+	return nil
+}
+
+// Abort closes and removes the temporary file, never renamed to its key
+func (w *diskWriter) Abort() error {
+	w.File.Close()
+	return os.Remove(w.Name())
+}
+
+// Location returns the absolute disk path of the file
+func (w *diskWriter) Location() string {
+	if abs, err := filepath.Abs(w.key); err == nil {
+		return abs
+	}
+	return w.key
+}
+
+// MultiStorage implements the Storage interface by writing variants to several backends
+// Encoded bytes are fanned out to every backend, e.g. local disk and object storage during a migration
+type MultiStorage struct {
+	backends []Storage
+}
+
+// NewMultiStorage returns a new MultiStorage writing to backends
+func NewMultiStorage(backends ...Storage) *MultiStorage {
+	return &MultiStorage{backends: backends}
+}
+
+// StorageErrors holds the error of each backend of a MultiStorage, nil for backends that succeeded
+type StorageErrors []error
+
+// Error returns the errors of the failed backends
+func (e StorageErrors) Error() string {
+	var msgs []string
+	for i, err := range e {
+		if err != nil {
+			msgs = append(msgs, fmt.Sprintf("backend %d: %v", i, err))
+		}
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// errOrNil returns e if any backend failed
+func (e StorageErrors) errOrNil() error {
+	for _, err := range e {
+		if err != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// Create creates the variant at key on every backend
+// Backends failing are skipped by the writer; their errors are reported on Close
+func (s *MultiStorage) Create(key string) (StorageWriter, error) {
+	w := &multiWriter{
+		writers: make([]StorageWriter, len(s.backends)),
+		errs:    make(StorageErrors, len(s.backends)),
+	}
+
+	created := 0
+	for i, backend := range s.backends {
+		writer, err := backend.Create(key)
+		if err != nil {
+			w.errs[i] = err
+			continue
+		}
+		w.writers[i] = writer
+		created++
+	}
+
+	if created == 0 {
+		return nil, w.errs
+	}
+	return w, nil
+}
+
+// Delete removes the variant at key from every backend
+func (s *MultiStorage) Delete(key string) error {
+	errs := make(StorageErrors, len(s.backends))
+	for i, backend := range s.backends {
+		errs[i] = backend.Delete(key)
+	}
+	return errs.errOrNil()
+}
+
+// multiWriter implements the StorageWriter interface for MultiStorage
+type multiWriter struct {
+	writers []StorageWriter
+	errs    StorageErrors
+}
+
+// Write writes p to every backend still healthy
+func (w *multiWriter) Write(p []byte) (int, error) {
+	healthy := 0
+	for i, writer := range w.writers {
+		if writer == nil || w.errs[i] != nil {
+			continue
+		}
+		if _, err := writer.Write(p); err != nil {
+			w.errs[i] = err
+			continue
+		}
+		healthy++
+	}
+
+	if healthy == 0 {
+		return 0, w.errs
+	}
+	return len(p), nil
+}
+
+// Close closes the writer of every backend and returns the errors of the failed ones
+func (w *multiWriter) Close() error {
+	for i, writer := range w.writers {
+		if writer == nil {
+			continue
+		}
+		if err := writer.Close(); err != nil && w.errs[i] == nil {
+			w.errs[i] = err
+		}
+	}
+	return w.errs.errOrNil()
+}
+
+// Abort discards the variant on every backend
+func (w *multiWriter) Abort() error {
+	errs := make(StorageErrors, len(w.writers))
+	for i, writer := range w.writers {
+		if writer != nil {
+			errs[i] = writer.Abort()
+		}
+	}
+	return errs.errOrNil()
+}
+
+// Location returns the location of the variant on the first backend written
+func (w *multiWriter) Location() string {
+	for i, writer := range w.writers {
+		if writer != nil && w.errs[i] == nil {
+			return writer.Location()
+		}
+	}
+	return ""
+}
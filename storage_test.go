@@ -0,0 +1,166 @@
+package upload
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+// failingStorage implements the Storage interface, failing every call
+type failingStorage struct{}
+
+func (s failingStorage) Create(key string) (StorageWriter, error) {
+	return nil, errors.New("unavailable")
+}
+func (s failingStorage) Delete(key string) error { return errors.New("unavailable") }
+
+// urlStorage implements the Storage interface in memory, locating variants at URLs
+type urlStorage struct {
+	*memStorage
+	base string
+}
+
+func (s urlStorage) Create(key string) (StorageWriter, error) {
+	w, err := s.memStorage.Create(key)
+	return urlWriter{w, s.base}, err
+}
+
+type urlWriter struct {
+	StorageWriter
+	base string
+}
+
+func (w urlWriter) Location() string { return w.base + w.StorageWriter.Location() }
+
+func TestVariantLocation(t *testing.T) {
+	storage := urlStorage{newMemStorage(), "https://cdn.example.com/"}
+	p := NewImageProcessor(WithStorage(storage), Formats("thumb", 20, 20, false))
+	format, _ := p.options.Format("thumb")
+
+	src := &source{
+		diskPath: "image.jpg",
+		img:      imaging.New(40, 40, color.White),
+		config:   &image.Config{Width: 40, Height: 40},
+		imgType:  TypeImageJPEG,
+	}
+	variant, err := p.processFormat(src, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if variant.Path != "image.jpg:thumb" {
+		t.Errorf("expected path image.jpg:thumb, got %v", variant.Path)
+	}
+	if variant.Location != "https://cdn.example.com/image.jpg:thumb" {
+		t.Errorf("expected the location of the storage, got %v", variant.Location)
+	}
+}
+
+func TestMultiStorage(t *testing.T) {
+	local, remote := newMemStorage(), newMemStorage()
+	storage := NewMultiStorage(local, failingStorage{}, remote)
+
+	w, err := storage.Create("image.jpg:thumb")
+	if err != nil {
+		t.Fatalf("unexpected create error: %v", err)
+	}
+	if _, err := w.Write([]byte("variant")); err != nil {
+		t.Fatalf("unexpected write error: %v", err)
+	}
+
+	err = w.Close()
+	errs, ok := err.(StorageErrors)
+	if !ok {
+		t.Fatalf("expected StorageErrors, got %v", err)
+	}
+	if errs[0] != nil || errs[1] == nil || errs[2] != nil {
+		t.Errorf("unexpected backend errors: %v", errs)
+	}
+
+	for i, backend := range []*memStorage{local, remote} {
+		if got := backend.files["image.jpg:thumb"].String(); got != "variant" {
+			t.Errorf("backend %d: expected variant, got %q", i, got)
+		}
+	}
+
+	if _, err := NewMultiStorage(failingStorage{}).Create("image.jpg:thumb"); err == nil {
+		t.Error("expected error when no backend is available")
+	}
+}
+
+func TestDiskStorageAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key := filepath.Join(dir, "image.jpg:thumb")
+	w, err := NewDiskStorage().Create(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("variant"))
+
+	if _, err := os.Stat(key); !os.IsNotExist(err) {
+		t.Error("expected variant hidden until closed")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(key)
+	if err != nil || string(content) != "variant" {
+		t.Errorf("unexpected variant content %q: %v", content, err)
+	}
+	if w.Location() != key {
+		t.Errorf("expected location %v, got %v", key, w.Location())
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Errorf("expected temporary file removed, got %d files", len(files))
+	}
+}
+
+func TestDiskStorageAbort(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key := filepath.Join(dir, "image.jpg:thumb")
+	if err := ioutil.WriteFile(key, []byte("previous"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := NewDiskStorage().Create(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("torn"))
+	if err := w.Abort(); err != nil {
+		t.Fatal(err)
+	}
+
+	// JPEG cannot encode images 65536 pixels wide
+	p := NewImageProcessor()
+	if err := p.writePreview(key, imaging.New(1<<16, 1, color.White), 1<<16, imaging.JPEG, nil); err == nil {
+		t.Fatal("expected encode error")
+	}
+
+	content, err := ioutil.ReadFile(key)
+	if err != nil || string(content) != "previous" {
+		t.Errorf("expected previous variant untouched, got %q: %v", content, err)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Errorf("expected temporary files removed, got %d files", len(files))
+	}
+}
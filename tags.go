@@ -0,0 +1,93 @@
+package upload
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	// formatsTag is the struct tag holding formats, e.g. `upload:"thumb=150x150,card=400x300"`
+	formatsTag = "upload"
+)
+
+// FormatsFromTags returns options adding the formats declared on the upload tag of a struct field
+// v is a struct or a pointer to a struct. Each format is declared as name=WIDTHxHEIGHT,
+// a dimension left empty or set to 0 preserves the aspect ratio (e.g. hero=1200x) and
+// both keep the size of the source, only re-encoding it (e.g. optimized=x)
+func FormatsFromTags(v interface{}, fieldName string) ([]OptionImage, error) {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("formats from tags: %v is not a struct", reflect.TypeOf(v))
+	}
+
+	field, ok := t.FieldByName(fieldName)
+	if !ok {
+		return nil, fmt.Errorf("formats from tags: field %v not found in %v", fieldName, t)
+	}
+
+	tag, ok := field.Tag.Lookup(formatsTag)
+	if !ok {
+		return nil, fmt.Errorf("formats from tags: field %v.%v has no %v tag", t.Name(), fieldName, formatsTag)
+	}
+
+	var opts []OptionImage
+	for _, decl := range strings.Split(tag, ",") {
+		decl = strings.TrimSpace(decl)
+		if decl == "" {
+			continue
+		}
+
+		name, width, height, err := parseFormatTag(decl)
+		if err != nil {
+			return nil, fmt.Errorf("formats from tags: field %v.%v: %v", t.Name(), fieldName, err)
+		}
+		opts = append(opts, Formats(name, width, height, false))
+	}
+
+	if len(opts) == 0 {
+		return nil, fmt.Errorf("formats from tags: field %v.%v declares no format", t.Name(), fieldName)
+	}
+	return opts, nil
+}
+
+// parseFormatTag parses a name=WIDTHxHEIGHT format declaration
+func parseFormatTag(decl string) (string, int, int, error) {
+	parts := strings.SplitN(decl, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", 0, 0, fmt.Errorf("format %q: expected name=WIDTHxHEIGHT", decl)
+	}
+
+	name := parts[0]
+	dims := strings.SplitN(strings.ToLower(parts[1]), "x", 2)
+	if len(dims) != 2 {
+		return "", 0, 0, fmt.Errorf("format %v: dimensions %q: expected WIDTHxHEIGHT", name, parts[1])
+	}
+
+	width, err := parseTagDimension(dims[0])
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("format %v: width: %v", name, err)
+	}
+	height, err := parseTagDimension(dims[1])
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("format %v: height: %v", name, err)
+	}
+	return name, width, height, nil
+}
+
+// parseTagDimension parses a dimension, empty meaning 0
+func parseTagDimension(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%q is not a positive integer", s)
+	}
+	return n, nil
+}
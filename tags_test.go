@@ -0,0 +1,45 @@
+package upload
+
+import (
+	"testing"
+)
+
+type taggedModel struct {
+	Avatar string `upload:"thumb=150x150, card=400x300,hero=1200x"`
+	Cover  string `upload:"thumb=150"`
+	Name   string
+}
+
+func TestFormatsFromTags(t *testing.T) {
+	opts, err := FormatsFromTags(&taggedModel{}, "Avatar")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	formats := EvaluateImageOptions(opts...).Formats()
+	expected := []struct {
+		name          string
+		width, height int
+	}{
+		{"thumb", 150, 150},
+		{"card", 400, 300},
+		{"hero", 1200, 0},
+	}
+	if len(formats) != len(expected) {
+		t.Fatalf("expected %d formats, got %d", len(expected), len(formats))
+	}
+	for i, e := range expected {
+		if formats[i].name != e.name || formats[i].width != e.width || formats[i].height != e.height {
+			t.Errorf("format %d: expected %v %vx%v, got %v %vx%v", i, e.name, e.width, e.height, formats[i].name, formats[i].width, formats[i].height)
+		}
+	}
+
+	for _, field := range []string{"Cover", "Name", "Missing"} {
+		if _, err := FormatsFromTags(taggedModel{}, field); err == nil {
+			t.Errorf("expected error for field %v", field)
+		}
+	}
+	if _, err := FormatsFromTags("not a struct", "Avatar"); err == nil {
+		t.Error("expected error for non struct")
+	}
+}
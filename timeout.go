@@ -0,0 +1,44 @@
+package upload
+
+import (
+	"context"
+	"errors"
+	"image"
+	"time"
+)
+
+// ErrDecodeTimeout is reported when decoding a source takes longer than DecodeTimeout
+var ErrDecodeTimeout = errors.New("decode timeout")
+
+// decodeTimeout runs decode, giving up with ErrDecodeTimeout after timeout if positive
+// Go cannot stop a decoder: a decode given up on keeps running in the background until it
+// returns, its result being dropped, so a worker is freed but not the CPU it uses. The
+// decode holds reserved until then, so that the memory it allocates stays accounted for
+func decodeTimeout(timeout time.Duration, reserved *reservation, decode func() (image.Image, error)) (image.Image, error) {
+	if timeout <= 0 {
+		return decode()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	type result struct {
+		img image.Image
+		err error
+	}
+	// Buffered so that an abandoned decode does not leak blocked
+	done := make(chan result, 1)
+	reserved.hold()
+	go func() {
+		defer reserved.release()
+		img, err := decode()
+		done <- result{img, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.img, r.err
+	case <-ctx.Done():
+		return nil, ErrDecodeTimeout
+	}
+}
@@ -0,0 +1,122 @@
+package upload
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestDecodeTimeout(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 1, 1))
+	fast := func() (image.Image, error) { return img, nil }
+	failing := func() (image.Image, error) { return nil, errors.New("corrupt") }
+
+	release := make(chan struct{})
+	defer close(release)
+	slow := func() (image.Image, error) {
+		<-release
+		return img, nil
+	}
+
+	if decoded, err := decodeTimeout(0, nil, fast); err != nil || decoded != img {
+		t.Errorf("expected decoded image without timeout, got %v", err)
+	}
+	if decoded, err := decodeTimeout(time.Second, nil, fast); err != nil || decoded != img {
+		t.Errorf("expected decoded image within timeout, got %v", err)
+	}
+	if _, err := decodeTimeout(time.Second, nil, failing); err == nil || err == ErrDecodeTimeout {
+		t.Errorf("expected decode error, got %v", err)
+	}
+
+	start := time.Now()
+	if _, err := decodeTimeout(20*time.Millisecond, nil, slow); err != ErrDecodeTimeout {
+		t.Errorf("expected ErrDecodeTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected to give up after the timeout, took %v", elapsed)
+	}
+}
+
+func TestDecodeTimeoutReservation(t *testing.T) {
+	used := func() int64 {
+		_decodeBudget.mu.Lock()
+		defer _decodeBudget.mu.Unlock()
+		return _decodeBudget.used
+	}
+	base := used()
+
+	release := make(chan struct{})
+	slow := func() (image.Image, error) {
+		<-release
+		return nil, errors.New("dropped")
+	}
+
+	reserved := reserve(100)
+	if _, err := decodeTimeout(10*time.Millisecond, reserved, slow); err != ErrDecodeTimeout {
+		t.Fatalf("expected ErrDecodeTimeout, got %v", err)
+	}
+	reserved.release()
+
+	// The abandoned decode keeps the reservation until it returns
+	if got := used(); got != base+100 {
+		t.Errorf("expected %d bytes reserved while decoding in the background, got %d", base+100, got)
+	}
+
+	close(release)
+	deadline := time.Now().Add(time.Second)
+	for used() != base && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := used(); got != base {
+		t.Errorf("expected reservation released once the decode returned, got %d bytes", got-base)
+	}
+}
+
+func TestDecodeRetries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "late.png")
+	if _, err := NewImageProcessor().decode(path, nil); err == nil {
+		t.Fatal("expected a missing file to fail without retries")
+	}
+
+	// The file appears after the first attempt, moved in whole
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		tmp := filepath.Join(dir, "tmp.png")
+		if err := imaging.Save(imaging.New(4, 4, color.White), tmp); err == nil {
+			os.Rename(tmp, path)
+		}
+	}()
+	img, err := NewImageProcessor(OpenRetries(5, 15*time.Millisecond)).decode(path, nil)
+	if err != nil {
+		t.Fatalf("expected the file decoded once it appeared, got %v", err)
+	}
+	if img.Bounds().Dx() != 4 {
+		t.Errorf("expected a 4px wide image, got %v", img.Bounds())
+	}
+
+	// Decoding errors are not transient
+	corrupt := filepath.Join(dir, "corrupt.png")
+	if err := ioutil.WriteFile(corrupt, []byte("not an image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err := NewImageProcessor(OpenRetries(3, time.Second)).decode(corrupt, nil); err == nil {
+		t.Error("expected a decode error")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected decode errors not retried, took %v", elapsed)
+	}
+}
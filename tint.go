@@ -0,0 +1,47 @@
+package upload
+
+import (
+	"image"
+	"image/color"
+)
+
+// brightness returns the average relative luminance of img within region, from 0 to 1
+// Pixels are sampled on a grid of at most 32x32 points
+func brightness(img image.Image, region image.Rectangle) float64 {
+	region = region.Intersect(img.Bounds())
+	if region.Empty() {
+		return 0
+	}
+
+	stepX := region.Dx()/32 + 1
+	stepY := region.Dy()/32 + 1
+
+	var total float64
+	var samples int
+	for y := region.Min.Y; y < region.Max.Y; y += stepY {
+		for x := region.Min.X; x < region.Max.X; x += stepX {
+			r, g, b, _ := img.At(x, y).RGBA()
+			total += (0.2126*float64(r) + 0.7152*float64(g) + 0.0722*float64(b)) / 0xFFFF
+			samples++
+		}
+	}
+	return total / float64(samples)
+}
+
+// tintImage returns img with every pixel set to c, keeping its alpha
+func tintImage(img image.Image, c color.NRGBA) *image.NRGBA {
+	bounds := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			_, _, _, a := img.At(x, y).RGBA()
+			i := dst.PixOffset(x-bounds.Min.X, y-bounds.Min.Y)
+			dst.Pix[i] = c.R
+			dst.Pix[i+1] = c.G
+			dst.Pix[i+2] = c.B
+			dst.Pix[i+3] = uint8(uint32(c.A) * (a >> 8) / 0xFF)
+		}
+	}
+	return dst
+}
@@ -0,0 +1,37 @@
+package upload
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func TestBrightness(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, image.Rect(50, 0, 100, 100), image.White, image.Point{}, draw.Src)
+
+	if b := brightness(img, image.Rect(0, 0, 40, 40)); b > 0.01 {
+		t.Errorf("expected dark region, got %v", b)
+	}
+	if b := brightness(img, image.Rect(60, 60, 100, 100)); b < 0.99 {
+		t.Errorf("expected light region, got %v", b)
+	}
+	if b := brightness(img, image.Rect(200, 200, 300, 300)); b != 0 {
+		t.Errorf("expected 0 outside image, got %v", b)
+	}
+}
+
+func TestTintImage(t *testing.T) {
+	watermark := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	watermark.Set(0, 0, color.NRGBA{255, 255, 255, 255})
+	watermark.Set(1, 0, color.NRGBA{255, 255, 255, 0})
+
+	tinted := tintImage(watermark, color.NRGBA{10, 20, 30, 255})
+	if c := tinted.NRGBAAt(0, 0); c != (color.NRGBA{10, 20, 30, 255}) {
+		t.Errorf("expected tinted opaque pixel, got %v", c)
+	}
+	if c := tinted.NRGBAAt(1, 0); c.A != 0 {
+		t.Errorf("expected transparent pixel kept, got %v", c)
+	}
+}
@@ -0,0 +1,44 @@
+package upload
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestIsImage(t *testing.T) {
+	jpeg, err := ioutil.ReadFile(filepath.Join("testdata", "normal.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tiff := []byte("II*\x00\x08\x00\x00\x00")
+	custom := []byte("CUSTOM")
+
+	tests := []struct {
+		name    string
+		options *OptionsImage
+		content []byte
+		want    bool
+	}{
+		{"Known Type", EvaluateImageOptions(), jpeg, true},
+		{"Unknown Type", EvaluateImageOptions(), tiff, false},
+		{"Signature", EvaluateImageOptions(ImageSignature([]byte("II*\x00"))), tiff, true},
+		{"Other Signature", EvaluateImageOptions(ImageSignature([]byte("MM\x00*"))), tiff, false},
+		{"Predicate", EvaluateImageOptions(ImagePredicate(func(content []byte) bool {
+			return bytes.HasPrefix(content, []byte("CUSTOM"))
+		})), custom, true},
+	}
+
+	for _, test := range tests {
+		if got := test.options.isImage(test.content); got != test.want {
+			t.Errorf("%s: expected %v, got %v", test.name, test.want, got)
+		}
+	}
+
+	// Accepted content still has to decode
+	p := NewImageProcessor(ImageSignature([]byte("II*\x00")))
+	if _, err := p.Process(&UploadedFile{diskPath: "image.tiff", content: tiff}, false); err == nil {
+		t.Error("expected undecodable content rejected")
+	}
+}
@@ -1,5 +1,12 @@
 package upload
 
+import (
+	"errors"
+)
+
+// ErrEmptyUpload is returned when an upload has no content, e.g. a missing file field
+var ErrEmptyUpload = errors.New("upload empty")
+
 // Uploader represents a file uploader (SMI)
 type Uploader interface {
 	// Upload accepts a filename, content and
@@ -8,7 +8,7 @@ import (
 // GenericUploader is a generic uploader
 // Does not have a processor
 type GenericUploader struct {
-	Options  *Options
+	Options *Options
 }
 
 // NewGenericUploader returns GenericUploader
@@ -19,6 +19,10 @@ func NewGenericUploader(common *Options) *GenericUploader {
 
 // Upload method to satisfy uploader interface
 func (u *GenericUploader) Upload(name string, content []byte) (*UploadedFile, error) {
+	if len(content) == 0 {
+		return nil, ErrEmptyUpload
+	}
+
 	fileType, err := filetype.Match(content)
 	if err != nil {
 		return nil, fmt.Errorf("Error retrieving file type: %v", err)
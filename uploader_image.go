@@ -2,12 +2,16 @@ package upload
 
 import (
 	"fmt"
+	"log"
+
 	"github.com/h2non/filetype"
+	"github.com/h2non/filetype/matchers"
+	"github.com/lsldigital/gocipe-upload/core"
 )
 
 // ImageUploader is an image uploader
 type ImageUploader struct {
-	Options  *Options
+	Options   *Options
 	Processor *ImageProcessor
 }
 
@@ -19,12 +23,54 @@ func NewImageUploader(common *Options, opts ...OptionImage) *ImageUploader {
 
 // Upload method to satisfy uploader interface
 func (u *ImageUploader) Upload(name string, content []byte) (*UploadedFile, error) {
-	if !isValidImage(content) {
+	if len(content) == 0 {
+		return nil, ErrEmptyUpload
+	}
+
+	if !u.Processor.options.isImage(content) {
 		return nil, fmt.Errorf("Not a valid image")
 	}
 
 	uploadedFile := NewUploadedFile(name, *u.Options)
 
+	// Drop oversized ICC profiles, colors converted to sRGB so that they do not shift
+	maxICCSize := u.Options.MaxICCSize()
+	iccSize := iccProfileSize(content)
+	if maxICCSize != core.NoLimit && iccSize > maxICCSize {
+		log.Printf("dropping ICC profile of %v larger than %v bytes\n", name, maxICCSize)
+		dropped, err := dropICCProfile(content)
+		if err != nil {
+			log.Printf("error converting %v to sRGB: %v\n", name, err)
+			return nil, fmt.Errorf("image invalid: %v", err)
+		}
+		content = dropped
+		uploadedFile.iccProfileDropped = true
+	}
+
+	// Protect processing from pathological animations
+	maxFrames, maxDuration := u.Options.MaxFrames(), u.Options.MaxTotalDuration()
+	if (maxFrames != core.NoLimit || maxDuration > 0) && matchers.Gif(content) {
+		truncated, dropped, err := truncateGIF(content, maxFrames, maxDuration)
+		if err != nil {
+			log.Printf("error decoding animation %v: %v\n", name, err)
+			return nil, fmt.Errorf("animation invalid: %v", err)
+		}
+		if dropped {
+			log.Printf("dropping frames of %v beyond %v frames or %v\n", name, maxFrames, maxDuration)
+			content = truncated
+			uploadedFile.framesTruncated = true
+		}
+	}
+
+	if loopCount := u.Options.LoopCount(); loopCount != nil && matchers.Gif(content) && isAnimated(content) {
+		looped, err := setGIFLoopCount(content, *loopCount)
+		if err != nil {
+			log.Printf("error decoding animation %v: %v\n", name, err)
+			return nil, fmt.Errorf("animation invalid: %v", err)
+		}
+		content = looped
+	}
+
 	if err := uploadedFile.Save(content, true); err != nil {
 		return nil, err
 	}
@@ -39,5 +85,31 @@ func (u *ImageUploader) Upload(name string, content []byte) (*UploadedFile, erro
 		return nil, err
 	}
 
+	if onDropped := u.Options.OnICCProfileDropped(); onDropped != nil && uploadedFile.iccProfileDropped {
+		onDropped(uploadedFile, iccSize)
+	}
+
 	return uploadedFile, nil
 }
+
+// UploadURL fetches an image from a remote url and uploads it
+func (u *ImageUploader) UploadURL(rawURL string) (*UploadedFile, error) {
+	name, content, err := fetchURL(rawURL, *u.Options, "image/")
+	if err != nil {
+		return nil, err
+	}
+
+	return u.Upload(name, content)
+}
+
+// UploadDataURI decodes an image posted as a base64 data URI (e.g. data:image/png;base64,...)
+// and uploads it as name; the declared MIME type must match the image
+func (u *ImageUploader) UploadDataURI(name, dataURI string) (*UploadedFile, error) {
+	content, err := parseDataURI(dataURI, "image/")
+	if err != nil {
+		log.Printf("error decoding data uri of %v: %v\n", name, err)
+		return nil, err
+	}
+
+	return u.Upload(name, content)
+}
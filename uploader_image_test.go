@@ -4,12 +4,15 @@ package upload_test
 import (
 	"flag"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
-	"github.com/stretchr/testify/suite"
 	"github.com/lsldigital/gocipe-upload"
-
+	"github.com/stretchr/testify/suite"
 )
 
 const (
@@ -37,7 +40,7 @@ func (s *ImageUploaderTestSuite) SetupSuite() {
 	common := []upload.Option{
 		upload.Dir(testDataFolder),
 		upload.Destination("tmp"),
-		upload.MediaPrefixURL("/"+testDataFolder+"/"),
+		upload.MediaPrefixURL("/" + testDataFolder + "/"),
 		upload.FileType(upload.TypeJPEG),
 		upload.FileType(upload.TypeJPEG2),
 		upload.FileType(upload.TypePNG),
@@ -63,7 +66,7 @@ func (s *ImageUploaderTestSuite) SetupSuite() {
 
 func (s *ImageUploaderTestSuite) TestImageUpload() {
 	for _, tt := range s.imageUploadTests {
-		s.Run(tt.name, func(){
+		s.Run(tt.name, func() {
 			inputContent, err := ioutil.ReadFile(filepath.Join(testDataFolder, tt.inputFile))
 			if err != nil {
 				s.Failf("Cannot open input golden file", "%s: %v", tt.inputFile, err)
@@ -114,6 +117,79 @@ func (s *ImageUploaderTestSuite) TestImageUpload() {
 	}
 }
 
+func (s *ImageUploaderTestSuite) TestImageUploadURL() {
+	server := httptest.NewServer(http.FileServer(http.Dir(testDataFolder)))
+	defer server.Close()
+
+	common := []upload.Option{
+		upload.Dir(testDataFolder),
+		upload.Destination("tmp"),
+		upload.MediaPrefixURL("/" + testDataFolder + "/"),
+		upload.FileType(upload.TypeJPEG),
+	}
+
+	s.Run("Allowed Host", func() {
+		uploader := upload.NewImageUploader(upload.EvaluateOptions(append(common, upload.AllowedHosts("127.0.0.1"))...))
+		uploaded, err := uploader.UploadURL(server.URL + "/normal.jpg")
+		if err != nil {
+			s.Failf("Cannot upload url", "%v", err)
+			return
+		}
+		defer uploaded.Delete()
+
+		expectedContent, err := ioutil.ReadFile(filepath.Join(testDataFolder, "normal.jpg"))
+		if err != nil {
+			s.Failf("Cannot open input golden file", "%v", err)
+			return
+		}
+		s.Equalf(expectedContent, uploaded.Content(), "Uploaded content invalid")
+	})
+
+	s.Run("Disallowed Host", func() {
+		uploader := upload.NewImageUploader(upload.EvaluateOptions(append(common, upload.AllowedHosts("example.com"))...))
+		_, err := uploader.UploadURL(server.URL + "/normal.jpg")
+		s.Error(err)
+	})
+
+	s.Run("No Allowed Host", func() {
+		uploader := upload.NewImageUploader(upload.EvaluateOptions(common...))
+		_, err := uploader.UploadURL(server.URL + "/normal.jpg")
+		s.Error(err)
+	})
+
+	s.Run("Redirect To Disallowed Host", func() {
+		// localhost resolves to the server too, but is not allowed
+		redirect := httptest.NewServer(http.RedirectHandler(strings.Replace(server.URL, "127.0.0.1", "localhost", 1)+"/normal.jpg", http.StatusFound))
+		defer redirect.Close()
+
+		uploader := upload.NewImageUploader(upload.EvaluateOptions(append(common, upload.AllowedHosts("127.0.0.1"))...))
+		_, err := uploader.UploadURL(redirect.URL + "/normal.jpg")
+		if s.Error(err) {
+			s.Contains(err.Error(), "host localhost not allowed")
+		}
+	})
+
+	s.Run("Timeout Without Client", func() {
+		opts := upload.EvaluateOptions(upload.HTTPClient(nil), upload.HTTPTimeout(time.Second))
+		if s.NotNil(opts.HTTPClient()) {
+			s.Equal(time.Second, opts.HTTPClient().Timeout)
+		}
+	})
+
+	s.Run("Not An Image", func() {
+		uploader := upload.NewImageUploader(upload.EvaluateOptions(append(common, upload.AllowedHosts("127.0.0.1"))...))
+		_, err := uploader.UploadURL(server.URL + "/normal.pdf")
+		s.Error(err)
+	})
+}
+
+func (s *ImageUploaderTestSuite) TestImageUploadEmpty() {
+	uploader := upload.NewImageUploader(upload.EvaluateOptions(upload.Dir(testDataFolder)))
+
+	_, err := uploader.Upload("empty.jpg", nil)
+	s.Equal(upload.ErrEmptyUpload, err)
+}
+
 func TestImageUploaderTestSuite(t *testing.T) {
 	suite.Run(t, new(ImageUploaderTestSuite))
 }
@@ -0,0 +1,45 @@
+package upload
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+)
+
+// ErrVariantExists is returned when a variant exists already with WriteErrorIfExists
+var ErrVariantExists = errors.New("variant exists")
+
+// createVariant returns a writer storing the variant at fileDiskPath according to WritePolicy
+func (p *ImageProcessor) createVariant(fileDiskPath string) (StorageWriter, error) {
+	disk, onDisk := p.options.storage.(*DiskStorage)
+	if !onDisk || p.options.writePolicy == WriteOverwrite {
+		return p.options.storage.Create(fileDiskPath)
+	}
+
+	switch p.options.writePolicy {
+	case WriteErrorIfExists:
+		if _, err := os.Stat(fileDiskPath); err == nil {
+			log.Printf("variant %v exists\n", fileDiskPath)
+			return nil, ErrVariantExists
+		}
+		return disk.createExclusive(fileDiskPath)
+	case WriteVersion:
+		return disk.createVersioned(fileDiskPath)
+	}
+	return disk.Create(fileDiskPath)
+}
+
+// versionFile links the file at path, if any, to the first free path.N
+// The file stays at path until replaced, so that it is served while its successor is written
+func versionFile(path string) error {
+	for n := 1; ; n++ {
+		err := os.Link(path, fmt.Sprintf("%s.%d", path, n))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if !os.IsExist(err) {
+			return err
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package upload
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWritePolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "writepolicy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key := filepath.Join(dir, "image.jpg:thumb")
+	write := func(p *ImageProcessor, content string) error {
+		w, err := p.createVariant(key)
+		if err != nil {
+			return err
+		}
+		w.Write([]byte(content))
+		return w.Close()
+	}
+	read := func(path string) string {
+		content, _ := ioutil.ReadFile(path)
+		return string(content)
+	}
+
+	if err := write(NewImageProcessor(WritePolicy(WriteErrorIfExists)), "first"); err != nil {
+		t.Fatal(err)
+	}
+	if err := write(NewImageProcessor(WritePolicy(WriteErrorIfExists)), "second"); err != ErrVariantExists {
+		t.Errorf("expected ErrVariantExists, got %v", err)
+	}
+
+	// A file appearing while the variant is written is not replaced
+	os.Remove(key)
+	w, err := NewImageProcessor(WritePolicy(WriteErrorIfExists)).createVariant(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(key, []byte("concurrent"), 0644)
+	if err := w.Close(); err != ErrVariantExists {
+		t.Errorf("expected ErrVariantExists on close, got %v", err)
+	}
+	if got := read(key); got != "concurrent" {
+		t.Errorf("expected concurrent file kept, got %q", got)
+	}
+
+	versioned := NewImageProcessor(WritePolicy(WriteVersion))
+
+	// The current variant stays in place while its successor is written or aborted
+	w, err = versioned.createVariant(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("aborted"))
+	if got := read(key); got != "concurrent" {
+		t.Errorf("expected current variant served while writing, got %q", got)
+	}
+	w.Abort()
+	if got := read(key); got != "concurrent" {
+		t.Errorf("expected current variant kept on abort, got %q", got)
+	}
+	if _, err := os.Stat(key + ".1"); !os.IsNotExist(err) {
+		t.Errorf("expected no version on abort, got %v", err)
+	}
+
+	for _, content := range []string{"v2", "v3"} {
+		if err := write(versioned, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for path, expected := range map[string]string{key: "v3", key + ".1": "concurrent", key + ".2": "v2"} {
+		if got := read(path); got != expected {
+			t.Errorf("%v: expected %q, got %q", filepath.Base(path), expected, got)
+		}
+	}
+
+	if err := write(NewImageProcessor(), "overwritten"); err != nil || read(key) != "overwritten" {
+		t.Errorf("expected variant overwritten by default: %v", err)
+	}
+}